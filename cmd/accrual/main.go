@@ -0,0 +1,31 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"go.uber.org/zap"
+
+	"github.com/alisaviation/internal/accrual"
+	"github.com/alisaviation/pkg/logger"
+)
+
+func main() {
+	conf := accrual.SetConfig()
+
+	if err := logger.Initialize("info"); err != nil {
+		log.Fatalf("Error initializing logger: %v", err)
+	}
+	defer logger.Log.Sync()
+
+	if len(flag.Args()) > 0 {
+		logger.Log.Fatal("Unknown flags", zap.Strings("flags", flag.Args()))
+	}
+
+	app := accrual.NewApp(conf)
+	if err := app.Run(); err != nil {
+		logger.Log.Error("Application failed", zap.Error(err))
+		os.Exit(1)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
 	"os"
@@ -15,15 +16,23 @@ import (
 
 func main() {
 	conf := config.SetConfigServer()
-	if len(flag.Args()) > 0 {
-		logger.Log.Fatal("Unknown flags", zap.Strings("flags", flag.Args()))
-	}
 
 	if err := logger.Initialize("info"); err != nil {
 		log.Fatalf("Error initializing logger: %v", err)
 	}
 	defer logger.Log.Sync()
 
+	if args := flag.Args(); len(args) > 0 {
+		if args[0] != "migrate" {
+			logger.Log.Fatal("Unknown flags", zap.Strings("flags", args))
+		}
+		if err := runMigrate(context.Background(), conf, args[1:], os.Stdout); err != nil {
+			logger.Log.Error("Migration failed", zap.Error(err))
+			os.Exit(1)
+		}
+		return
+	}
+
 	app := server.NewServerApp(conf)
 	if err := app.Run(); err != nil {
 		logger.Log.Error("Application failed", zap.Error(err))
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/alisaviation/internal/config"
+	"github.com/alisaviation/internal/database/memory"
+	"github.com/alisaviation/internal/database/postgres"
+)
+
+const migrateUsage = "usage: gophermart [flags] migrate up|down [N]|status|force VERSION"
+
+// runMigrate implements the migrate subcommand against conf.DatabaseURI.
+// Flags such as -d must come before the subcommand.
+func runMigrate(ctx context.Context, conf config.Server, args []string, out io.Writer) error {
+	apply, err := parseMigrateCommand(args)
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(conf.DatabaseURI, memory.DSNPrefix) {
+		return errors.New("in-memory storage has no migrations")
+	}
+
+	db, err := sql.Open("postgres", conf.DatabaseURI)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	m, err := postgres.NewMigrator(ctx, db)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	if err := apply(m); err != nil {
+		return err
+	}
+
+	status, err := m.Status()
+	if err != nil {
+		return err
+	}
+	printStatus(out, status)
+	return nil
+}
+
+// parseMigrateCommand validates args before any connection is made. The
+// returned func is a no-op for status, which is printed after every command.
+func parseMigrateCommand(args []string) (func(*postgres.Migrator) error, error) {
+	if len(args) == 0 {
+		return nil, errors.New(migrateUsage)
+	}
+
+	switch cmd, rest := args[0], args[1:]; {
+	case cmd == "up" && len(rest) == 0:
+		return (*postgres.Migrator).Up, nil
+	case cmd == "status" && len(rest) == 0:
+		return func(*postgres.Migrator) error { return nil }, nil
+	case cmd == "down" && len(rest) <= 1:
+		steps := 1
+		if len(rest) == 1 {
+			n, err := strconv.Atoi(rest[0])
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid number of steps %q", rest[0])
+			}
+			steps = n
+		}
+		return func(m *postgres.Migrator) error { return m.Down(steps) }, nil
+	case cmd == "force" && len(rest) == 1:
+		version, err := strconv.Atoi(rest[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid version %q", rest[0])
+		}
+		return func(m *postgres.Migrator) error { return m.Force(version) }, nil
+	default:
+		return nil, fmt.Errorf("invalid migrate command %q\n%s", strings.Join(args, " "), migrateUsage)
+	}
+}
+
+func printStatus(out io.Writer, status postgres.MigrationStatus) {
+	switch {
+	case !status.Applied:
+		fmt.Fprintf(out, "version: none, latest: %d\n", status.Latest)
+	case status.Dirty:
+		fmt.Fprintf(out, "version: %d (dirty), latest: %d\n", status.Version, status.Latest)
+	default:
+		fmt.Fprintf(out, "version: %d, latest: %d\n", status.Version, status.Latest)
+	}
+}
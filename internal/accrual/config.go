@@ -0,0 +1,68 @@
+package accrual
+
+import (
+	"flag"
+	"os"
+	"strconv"
+	"time"
+)
+
+type Config struct {
+	RunAddress        string
+	RequestsPerMinute int
+	FailureRate       float64
+	ProcessingDelay   time.Duration
+}
+
+func SetConfig() Config {
+	var config Config
+
+	config = setDefaultConfig(config)
+	config = setFlagsConfig(config)
+	config = setEnvsConfig(config)
+
+	return config
+}
+
+func setDefaultConfig(config Config) Config {
+	config.RunAddress = "localhost:8081"
+	config.RequestsPerMinute = 0
+	config.FailureRate = 0
+	config.ProcessingDelay = 2 * time.Second
+	return config
+}
+
+func setFlagsConfig(config Config) Config {
+	address := flag.String("a", config.RunAddress, "HTTP server address")
+	rpm := flag.Int("l", config.RequestsPerMinute, "Requests per minute allowed for order info, 0 disables throttling")
+	failureRate := flag.Float64("f", config.FailureRate, "Share of requests answered with 500, from 0 to 1")
+	delay := flag.Duration("t", config.ProcessingDelay, "Time spent in each processing stage")
+	flag.Parse()
+	config.RunAddress = *address
+	config.RequestsPerMinute = *rpm
+	config.FailureRate = *failureRate
+	config.ProcessingDelay = *delay
+	return config
+}
+
+func setEnvsConfig(config Config) Config {
+	if envAddress := os.Getenv("RUN_ADDRESS"); envAddress != "" {
+		config.RunAddress = envAddress
+	}
+	if envRPM := os.Getenv("ACCRUAL_REQUESTS_PER_MINUTE"); envRPM != "" {
+		if rpm, err := strconv.Atoi(envRPM); err == nil {
+			config.RequestsPerMinute = rpm
+		}
+	}
+	if envFailureRate := os.Getenv("ACCRUAL_FAILURE_RATE"); envFailureRate != "" {
+		if rate, err := strconv.ParseFloat(envFailureRate, 64); err == nil {
+			config.FailureRate = rate
+		}
+	}
+	if envDelay := os.Getenv("ACCRUAL_PROCESSING_DELAY"); envDelay != "" {
+		if delay, err := time.ParseDuration(envDelay); err == nil {
+			config.ProcessingDelay = delay
+		}
+	}
+	return config
+}
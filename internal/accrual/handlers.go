@@ -0,0 +1,107 @@
+package accrual
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+
+	"github.com/alisaviation/internal/gophermart/services"
+	"github.com/alisaviation/pkg/logger"
+)
+
+type Handler struct {
+	storage *Storage
+}
+
+func NewHandler(storage *Storage) *Handler {
+	return &Handler{storage: storage}
+}
+
+type registerOrderRequest struct {
+	Order string `json:"order"`
+	Goods []Good `json:"goods"`
+}
+
+type orderResponse struct {
+	Order   string  `json:"order"`
+	Status  string  `json:"status"`
+	Accrual float64 `json:"accrual,omitempty"`
+}
+
+func (h *Handler) GetOrder(w http.ResponseWriter, r *http.Request) {
+	number := chi.URLParam(r, "number")
+
+	order, ok := h.storage.GetOrder(number)
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	resp := orderResponse{
+		Order:  order.Number,
+		Status: order.Status,
+	}
+	if order.Status == StatusProcessed {
+		resp.Accrual = order.Accrual
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logger.Log.Error("Failed to encode order response", zap.String("order", number), zap.Error(err))
+	}
+}
+
+func (h *Handler) RegisterOrder(w http.ResponseWriter, r *http.Request) {
+	var req registerOrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	if !services.ValidateOrderNumber(req.Order) {
+		http.Error(w, "Invalid order number", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.storage.RegisterOrder(req.Order, req.Goods); err != nil {
+		if errors.Is(err, ErrOrderExists) {
+			http.Error(w, "Order already registered", http.StatusConflict)
+			return
+		}
+		logger.Log.Error("Failed to register order", zap.String("order", req.Order), zap.Error(err))
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *Handler) RegisterGoods(w http.ResponseWriter, r *http.Request) {
+	var rule RewardRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	if rule.Match == "" || rule.Reward <= 0 ||
+		(rule.RewardType != RewardTypePercent && rule.RewardType != RewardTypePoints) {
+		http.Error(w, "Invalid reward rule", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.storage.RegisterRule(rule); err != nil {
+		if errors.Is(err, ErrMatchExists) {
+			http.Error(w, "Reward rule already registered", http.StatusConflict)
+			return
+		}
+		logger.Log.Error("Failed to register reward rule", zap.String("match", rule.Match), zap.Error(err))
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,67 @@
+package accrual
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type throttle struct {
+	mu          sync.Mutex
+	limit       int
+	windowStart time.Time
+	count       int
+}
+
+func ThrottleMiddleware(requestsPerMinute int) func(http.Handler) http.Handler {
+	t := &throttle{limit: requestsPerMinute}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if t.limit <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			retryAfter, ok := t.allow(time.Now())
+			if !ok {
+				w.Header().Set("Content-Type", "text/plain")
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+				w.WriteHeader(http.StatusTooManyRequests)
+				fmt.Fprintf(w, "No more than %d requests per minute allowed", t.limit)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (t *throttle) allow(now time.Time) (int, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if now.Sub(t.windowStart) >= time.Minute {
+		t.windowStart = now
+		t.count = 0
+	}
+	if t.count >= t.limit {
+		remaining := t.windowStart.Add(time.Minute).Sub(now)
+		return int((remaining + time.Second - 1) / time.Second), false
+	}
+	t.count++
+	return 0, true
+}
+
+func FailureMiddleware(rate float64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if rate > 0 && rand.Float64() < rate {
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package accrual
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+
+	"github.com/alisaviation/pkg/logger"
+)
+
+type App struct {
+	config     Config
+	storage    *Storage
+	httpServer *http.Server
+	wg         sync.WaitGroup
+}
+
+func NewApp(conf Config) *App {
+	return &App{
+		config:  conf,
+		storage: NewStorage(),
+	}
+}
+
+func NewRouter(storage *Storage, conf Config) http.Handler {
+	handler := NewHandler(storage)
+
+	r := chi.NewRouter()
+	r.Use(
+		logger.RequestResponseLogger,
+		FailureMiddleware(conf.FailureRate),
+	)
+
+	r.Post("/api/orders", handler.RegisterOrder)
+	r.Post("/api/goods", handler.RegisterGoods)
+	r.With(ThrottleMiddleware(conf.RequestsPerMinute)).Get("/api/orders/{number}", handler.GetOrder)
+
+	return r
+}
+
+func (a *App) Run() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a.httpServer = &http.Server{
+		Addr:    a.config.RunAddress,
+		Handler: NewRouter(a.storage, a.config),
+	}
+
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		a.process(ctx)
+	}()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		logger.Log.Info("Starting accrual server", zap.String("address", a.config.RunAddress))
+		if err := a.httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serverErr <- fmt.Errorf("HTTP server failed: %w", err)
+		}
+	}()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case sig := <-sigChan:
+		logger.Log.Info("Received signal, shutting down", zap.String("signal", sig.String()))
+	case err := <-serverErr:
+		return err
+	}
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdownCancel()
+	if err := a.httpServer.Shutdown(shutdownCtx); err != nil {
+		logger.Log.Error("HTTP server shutdown failed", zap.Error(err))
+	}
+
+	cancel()
+	a.wg.Wait()
+
+	logger.Log.Info("Accrual server shutdown complete")
+	return nil
+}
+
+func (a *App) process(ctx context.Context) {
+	delay := a.config.ProcessingDelay
+	if delay <= 0 {
+		delay = time.Second
+	}
+
+	ticker := time.NewTicker(delay)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.storage.Advance()
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package accrual
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"sync"
+)
+
+const (
+	StatusRegistered = "REGISTERED"
+	StatusProcessing = "PROCESSING"
+	StatusInvalid    = "INVALID"
+	StatusProcessed  = "PROCESSED"
+
+	RewardTypePercent = "%"
+	RewardTypePoints  = "pt"
+)
+
+var (
+	ErrOrderExists = errors.New("order already registered")
+	ErrMatchExists = errors.New("reward rule already registered")
+)
+
+type Good struct {
+	Description string  `json:"description"`
+	Price       float64 `json:"price"`
+}
+
+type Order struct {
+	Number  string
+	Goods   []Good
+	Status  string
+	Accrual float64
+}
+
+type RewardRule struct {
+	Match      string  `json:"match"`
+	Reward     float64 `json:"reward"`
+	RewardType string  `json:"reward_type"`
+}
+
+type Storage struct {
+	mu     sync.RWMutex
+	orders map[string]*Order
+	rules  []RewardRule
+}
+
+func NewStorage() *Storage {
+	return &Storage{
+		orders: make(map[string]*Order),
+	}
+}
+
+func (s *Storage) RegisterOrder(number string, goods []Good) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.orders[number]; ok {
+		return ErrOrderExists
+	}
+	s.orders[number] = &Order{
+		Number: number,
+		Goods:  goods,
+		Status: StatusRegistered,
+	}
+	return nil
+}
+
+func (s *Storage) RegisterRule(rule RewardRule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, r := range s.rules {
+		if r.Match == rule.Match {
+			return ErrMatchExists
+		}
+	}
+	s.rules = append(s.rules, rule)
+	return nil
+}
+
+func (s *Storage) GetOrder(number string) (Order, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	order, ok := s.orders[number]
+	if !ok {
+		return Order{}, false
+	}
+	return *order, true
+}
+
+func (s *Storage) Advance() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, order := range s.orders {
+		switch order.Status {
+		case StatusRegistered:
+			order.Status = StatusProcessing
+		case StatusProcessing:
+			accrual, matched := calculateAccrual(order.Goods, s.rules)
+			if !matched {
+				order.Status = StatusInvalid
+				continue
+			}
+			order.Status = StatusProcessed
+			order.Accrual = accrual
+		}
+	}
+}
+
+func calculateAccrual(goods []Good, rules []RewardRule) (float64, bool) {
+	var total float64
+	matched := false
+	for _, good := range goods {
+		for _, rule := range rules {
+			if !strings.Contains(good.Description, rule.Match) {
+				continue
+			}
+			matched = true
+			switch rule.RewardType {
+			case RewardTypePercent:
+				total += good.Price * rule.Reward / 100
+			case RewardTypePoints:
+				total += rule.Reward
+			}
+			break
+		}
+	}
+	return math.Round(total*100) / 100, matched
+}
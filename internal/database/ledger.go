@@ -0,0 +1,128 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/alisaviation/internal/gophermart/models"
+	"github.com/alisaviation/pkg/money"
+)
+
+const (
+	EntryAccrual    = "ACCRUAL"
+	EntryWithdrawal = "WITHDRAWAL"
+	EntryReversal   = "REVERSAL"
+	EntryAdjustment = "ADJUSTMENT"
+
+	AccountUserPoints    = "USER_POINTS"
+	AccountUserWithdrawn = "USER_WITHDRAWN"
+	AccountAccruals      = "ACCRUALS"
+	AccountAdjustments   = "ADJUSTMENTS"
+)
+
+var (
+	ErrUnbalancedEntry = errors.New("journal entry is not balanced")
+	ErrAlreadyReversed = errors.New("journal entry already reversed")
+)
+
+type Ledger interface {
+	RecordAdjustment(ctx context.Context, userID int, amount money.Amount, description string) (*models.JournalEntry, error)
+	ReverseEntry(ctx context.Context, entryID int64, description string) (*models.JournalEntry, error)
+	GetJournal(ctx context.Context, userID int) ([]models.JournalEntry, error)
+}
+
+func NewAccrualEntry(userID int, orderNumber string, amount money.Amount, at time.Time) models.JournalEntry {
+	kind := EntryAccrual
+	if amount < 0 {
+		kind = EntryReversal
+	}
+	return models.JournalEntry{
+		Kind:        kind,
+		UserID:      userID,
+		OrderNumber: orderNumber,
+		CreatedAt:   at,
+		Postings: []models.Posting{
+			{Account: AccountUserPoints, UserID: userID, Amount: amount},
+			{Account: AccountAccruals, Amount: -amount},
+		},
+	}
+}
+
+func NewWithdrawalEntry(withdrawal *models.Withdrawal) models.JournalEntry {
+	return models.JournalEntry{
+		Kind:         EntryWithdrawal,
+		UserID:       withdrawal.UserID,
+		OrderNumber:  withdrawal.OrderNumber,
+		WithdrawalID: withdrawal.ID,
+		CreatedAt:    withdrawal.ProcessedAt,
+		Postings: []models.Posting{
+			{Account: AccountUserPoints, UserID: withdrawal.UserID, Amount: -withdrawal.Sum},
+			{Account: AccountUserWithdrawn, UserID: withdrawal.UserID, Amount: withdrawal.Sum},
+		},
+	}
+}
+
+func NewAdjustmentEntry(userID int, amount money.Amount, description string, at time.Time) models.JournalEntry {
+	return models.JournalEntry{
+		Kind:        EntryAdjustment,
+		UserID:      userID,
+		Description: description,
+		CreatedAt:   at,
+		Postings: []models.Posting{
+			{Account: AccountUserPoints, UserID: userID, Amount: amount},
+			{Account: AccountAdjustments, Amount: -amount},
+		},
+	}
+}
+
+func NewReversalEntry(original models.JournalEntry, description string, at time.Time) models.JournalEntry {
+	postings := make([]models.Posting, 0, len(original.Postings))
+	for _, p := range original.Postings {
+		postings = append(postings, models.Posting{
+			Account: p.Account,
+			UserID:  p.UserID,
+			Amount:  -p.Amount,
+		})
+	}
+
+	reverses := original.ID
+	return models.JournalEntry{
+		Kind:            EntryReversal,
+		UserID:          original.UserID,
+		OrderNumber:     original.OrderNumber,
+		WithdrawalID:    original.WithdrawalID,
+		ReversesEntryID: &reverses,
+		Description:     description,
+		CreatedAt:       at,
+		Postings:        postings,
+	}
+}
+
+func ValidateEntry(entry models.JournalEntry) error {
+	if len(entry.Postings) < 2 {
+		return fmt.Errorf("%w: entry needs at least two postings", ErrUnbalancedEntry)
+	}
+
+	var total money.Amount
+	for _, p := range entry.Postings {
+		total += p.Amount
+	}
+	if total != 0 {
+		return fmt.Errorf("%w: postings sum to %s", ErrUnbalancedEntry, total)
+	}
+	return nil
+}
+
+func UserDeltas(entry models.JournalEntry) (current, withdrawn money.Amount) {
+	for _, p := range entry.Postings {
+		switch p.Account {
+		case AccountUserPoints:
+			current += p.Amount
+		case AccountUserWithdrawn:
+			withdrawn += p.Amount
+		}
+	}
+	return current, withdrawn
+}
@@ -0,0 +1,38 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/alisaviation/internal/database"
+	"github.com/alisaviation/internal/gophermart/models"
+)
+
+func (m *MemoryStorage) CreateUser(ctx context.Context, user models.User) (int, error) {
+	defer m.enter(ctx)()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.usersByLogin[user.Login]; ok {
+		return 0, database.ErrUserExists
+	}
+
+	m.nextUserID++
+	user.ID = m.nextUserID
+	m.users[user.ID] = user
+	m.usersByLogin[user.Login] = user.ID
+	m.appendOutbox(database.NewUserRegisteredEvent(user))
+	return user.ID, nil
+}
+
+func (m *MemoryStorage) GetUserByLogin(ctx context.Context, login string) (*models.User, error) {
+	defer m.enter(ctx)()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	id, ok := m.usersByLogin[login]
+	if !ok {
+		return nil, nil
+	}
+	user := m.users[id]
+	return &user, nil
+}
@@ -0,0 +1,117 @@
+package memory
+
+import (
+	"context"
+	"sort"
+
+	"github.com/alisaviation/internal/database"
+	"github.com/alisaviation/internal/gophermart/models"
+)
+
+func (m *MemoryStorage) GetBalance(ctx context.Context, userID int) (*models.Balance, error) {
+	defer m.enter(ctx)()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	balance := m.balances[userID]
+	balance.UserID = userID
+	return &balance, nil
+}
+
+func (m *MemoryStorage) Withdraw(ctx context.Context, withdrawal *models.Withdrawal) error {
+	defer m.enter(ctx)()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.balances[withdrawal.UserID].Current < withdrawal.Sum {
+		return database.ErrInsufficientFunds
+	}
+	return m.insertWithdrawal(withdrawal)
+}
+
+func (m *MemoryStorage) insertWithdrawal(withdrawal *models.Withdrawal) error {
+	if withdrawal.Sum <= 0 {
+		return database.ErrInvalidSum
+	}
+	if _, ok := m.withdrawals[withdrawal.OrderNumber]; ok {
+		return database.ErrWithdrawalExists
+	}
+
+	m.nextWithdrawalID++
+	withdrawal.ID = m.nextWithdrawalID
+	if _, err := m.postEntry(database.NewWithdrawalEntry(withdrawal)); err != nil {
+		return err
+	}
+	m.withdrawals[withdrawal.OrderNumber] = *withdrawal
+	m.appendOutbox(database.NewWithdrawalCreatedEvent(withdrawal))
+	return nil
+}
+
+func (m *MemoryStorage) ReconcileBalances(ctx context.Context) ([]models.BalanceMismatch, error) {
+	defer m.enter(ctx)()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	expected := make(map[int]models.Balance)
+	for _, entry := range m.journal {
+		for _, p := range entry.Postings {
+			b := expected[p.UserID]
+			switch p.Account {
+			case database.AccountUserPoints:
+				b.Current += p.Amount
+			case database.AccountUserWithdrawn:
+				b.Withdrawn += p.Amount
+			}
+			expected[p.UserID] = b
+		}
+	}
+
+	var mismatches []models.BalanceMismatch
+	for id := range m.users {
+		actual, want := m.balances[id], expected[id]
+		if actual.Current != want.Current || actual.Withdrawn != want.Withdrawn {
+			mismatches = append(mismatches, models.BalanceMismatch{
+				UserID:            id,
+				Current:           actual.Current,
+				Withdrawn:         actual.Withdrawn,
+				ExpectedCurrent:   want.Current,
+				ExpectedWithdrawn: want.Withdrawn,
+			})
+		}
+	}
+	sort.Slice(mismatches, func(i, j int) bool {
+		return mismatches[i].UserID < mismatches[j].UserID
+	})
+	return mismatches, nil
+}
+
+func (m *MemoryStorage) WithdrawalExists(ctx context.Context, orderNumber string) (bool, error) {
+	defer m.enter(ctx)()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, ok := m.withdrawals[orderNumber]
+	return ok, nil
+}
+
+func (m *MemoryStorage) GetWithdrawals(ctx context.Context, userID int) ([]models.Withdrawal, error) {
+	defer m.enter(ctx)()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var withdrawals []models.Withdrawal
+	for _, entry := range m.journal {
+		if entry.UserID != userID || entry.Kind != database.EntryWithdrawal || m.reversed[entry.ID] {
+			continue
+		}
+		_, withdrawn := database.UserDeltas(entry)
+		withdrawals = append(withdrawals, models.Withdrawal{
+			ID:          entry.WithdrawalID,
+			UserID:      entry.UserID,
+			OrderNumber: entry.OrderNumber,
+			Sum:         withdrawn,
+			ProcessedAt: entry.CreatedAt,
+		})
+	}
+	return withdrawals, nil
+}
@@ -0,0 +1,97 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/alisaviation/internal/gophermart/models"
+)
+
+func (m *MemoryStorage) ReserveIdempotencyKey(ctx context.Context, record *models.IdempotencyRecord) (*models.IdempotencyRecord, bool, error) {
+	defer m.enter(ctx)()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	k := idempotencyKey{userID: record.UserID, key: record.Key}
+	if existing, ok := m.idempotency[k]; ok && !existing.ExpiresAt.Before(now) {
+		abandoned := !existing.Completed && existing.RequestHash == record.RequestHash && existing.LockedUntil.Before(now)
+		if !abandoned {
+			return copyIdempotencyRecord(existing), false, nil
+		}
+	}
+
+	m.idempotency[k] = models.IdempotencyRecord{
+		UserID:      record.UserID,
+		Key:         record.Key,
+		RequestHash: record.RequestHash,
+		Headers:     map[string][]string{},
+		ExpiresAt:   record.ExpiresAt,
+		LockedUntil: record.LockedUntil,
+	}
+	return nil, true, nil
+}
+
+func (m *MemoryStorage) CompleteIdempotencyKey(ctx context.Context, record *models.IdempotencyRecord) error {
+	defer m.enter(ctx)()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k := idempotencyKey{userID: record.UserID, key: record.Key}
+	existing, ok := m.idempotency[k]
+	if !ok {
+		return nil
+	}
+
+	stored := copyIdempotencyRecord(models.IdempotencyRecord{
+		UserID:      existing.UserID,
+		Key:         existing.Key,
+		RequestHash: existing.RequestHash,
+		Completed:   true,
+		StatusCode:  record.StatusCode,
+		Headers:     record.Headers,
+		Body:        record.Body,
+		ExpiresAt:   existing.ExpiresAt,
+		LockedUntil: existing.LockedUntil,
+	})
+	m.idempotency[k] = *stored
+	return nil
+}
+
+func (m *MemoryStorage) ReleaseIdempotencyKey(ctx context.Context, userID int, key string) error {
+	defer m.enter(ctx)()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k := idempotencyKey{userID: userID, key: key}
+	if existing, ok := m.idempotency[k]; ok && !existing.Completed {
+		delete(m.idempotency, k)
+	}
+	return nil
+}
+
+func (m *MemoryStorage) PurgeExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	defer m.enter(ctx)()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var purged int64
+	for k, record := range m.idempotency {
+		if record.ExpiresAt.Before(now) {
+			delete(m.idempotency, k)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func copyIdempotencyRecord(record models.IdempotencyRecord) *models.IdempotencyRecord {
+	headers := make(map[string][]string, len(record.Headers))
+	for name, values := range record.Headers {
+		headers[name] = append([]string(nil), values...)
+	}
+	record.Headers = headers
+	record.Body = append([]byte(nil), record.Body...)
+	return &record
+}
@@ -0,0 +1,95 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/alisaviation/internal/database"
+	"github.com/alisaviation/internal/gophermart/models"
+	"github.com/alisaviation/pkg/money"
+)
+
+// postEntry must be called with m.mu held for writing.
+func (m *MemoryStorage) postEntry(entry models.JournalEntry) (*models.JournalEntry, error) {
+	if err := database.ValidateEntry(entry); err != nil {
+		return nil, err
+	}
+	if entry.ReversesEntryID != nil && m.reversed[*entry.ReversesEntryID] {
+		return nil, database.ErrAlreadyReversed
+	}
+
+	m.nextEntryID++
+	entry.ID = m.nextEntryID
+	entry.Postings = append([]models.Posting(nil), entry.Postings...)
+	m.journal = append(m.journal, entry)
+	if entry.ReversesEntryID != nil {
+		m.reversed[*entry.ReversesEntryID] = true
+	}
+
+	current, withdrawn := database.UserDeltas(entry)
+	if current != 0 || withdrawn != 0 {
+		balance := m.balances[entry.UserID]
+		balance.UserID = entry.UserID
+		balance.Current += current
+		balance.Withdrawn += withdrawn
+		m.balances[entry.UserID] = balance
+	}
+
+	return copyEntry(entry), nil
+}
+
+func (m *MemoryStorage) RecordAdjustment(ctx context.Context, userID int, amount money.Amount, description string) (*models.JournalEntry, error) {
+	defer m.enter(ctx)()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.balances[userID].Current+amount < 0 {
+		return nil, database.ErrInsufficientFunds
+	}
+	return m.postEntry(database.NewAdjustmentEntry(userID, amount, description, time.Now()))
+}
+
+func (m *MemoryStorage) ReverseEntry(ctx context.Context, entryID int64, description string) (*models.JournalEntry, error) {
+	defer m.enter(ctx)()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	idx := sort.Search(len(m.journal), func(i int) bool { return m.journal[i].ID >= entryID })
+	if idx == len(m.journal) || m.journal[idx].ID != entryID {
+		return nil, database.ErrNotFound
+	}
+
+	reversal := database.NewReversalEntry(m.journal[idx], description, time.Now())
+	delta, _ := database.UserDeltas(reversal)
+	if m.balances[reversal.UserID].Current+delta < 0 {
+		return nil, database.ErrInsufficientFunds
+	}
+	return m.postEntry(reversal)
+}
+
+func (m *MemoryStorage) GetJournal(ctx context.Context, userID int) ([]models.JournalEntry, error) {
+	defer m.enter(ctx)()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var entries []models.JournalEntry
+	for _, entry := range m.journal {
+		if entry.UserID == userID {
+			entries = append(entries, *copyEntry(entry))
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].CreatedAt.Before(entries[j].CreatedAt)
+	})
+	return entries, nil
+}
+
+func copyEntry(entry models.JournalEntry) *models.JournalEntry {
+	entry.Postings = append([]models.Posting(nil), entry.Postings...)
+	if entry.ReversesEntryID != nil {
+		reverses := *entry.ReversesEntryID
+		entry.ReversesEntryID = &reverses
+	}
+	return &entry
+}
@@ -0,0 +1,79 @@
+// Package memory implements database.Storage in process memory. It mirrors
+// the Postgres semantics closely enough for tests and demos; nothing is
+// persisted across restarts.
+package memory
+
+import (
+	"sync"
+	"time"
+
+	"github.com/alisaviation/internal/gophermart/models"
+)
+
+const DSNPrefix = "memory://"
+
+type idempotencyKey struct {
+	userID int
+	key    string
+}
+
+type orderPoll struct {
+	attempts int
+	nextAt   time.Time
+}
+
+type session struct {
+	userID    int
+	createdAt time.Time
+	revokedAt *time.Time
+}
+
+type MemoryStorage struct {
+	// txMu is held exclusively by a running transaction and shared by
+	// every other call, so transactions are serializable.
+	txMu sync.RWMutex
+	mu   sync.RWMutex
+
+	users        map[int]models.User
+	usersByLogin map[string]int
+	orders       map[string]models.Order
+	orderPolls   map[string]orderPoll
+	withdrawals  map[string]models.Withdrawal
+	balances     map[int]models.Balance
+	journal      []models.JournalEntry
+	reversed     map[int64]bool
+
+	idempotency   map[idempotencyKey]models.IdempotencyRecord
+	sessions      map[string]session
+	refreshTokens map[string]models.RefreshToken
+	revokedTokens map[string]time.Time
+	outbox        []models.OutboxEvent
+	webhooks      map[int64]models.Webhook
+	deliveries    []models.WebhookDelivery
+
+	nextUserID       int
+	nextOrderID      int
+	nextWithdrawalID int
+	nextEntryID      int64
+	nextTokenID      int64
+	nextOutboxID     int64
+	nextWebhookID    int64
+	nextDeliveryID   int64
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		users:         make(map[int]models.User),
+		usersByLogin:  make(map[string]int),
+		orders:        make(map[string]models.Order),
+		orderPolls:    make(map[string]orderPoll),
+		withdrawals:   make(map[string]models.Withdrawal),
+		balances:      make(map[int]models.Balance),
+		reversed:      make(map[int64]bool),
+		idempotency:   make(map[idempotencyKey]models.IdempotencyRecord),
+		sessions:      make(map[string]session),
+		refreshTokens: make(map[string]models.RefreshToken),
+		revokedTokens: make(map[string]time.Time),
+		webhooks:      make(map[int64]models.Webhook),
+	}
+}
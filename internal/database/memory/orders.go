@@ -0,0 +1,177 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/alisaviation/internal/database"
+	"github.com/alisaviation/internal/gophermart/models"
+	"github.com/alisaviation/pkg/money"
+)
+
+func (m *MemoryStorage) CreateOrder(ctx context.Context, order *models.Order) error {
+	defer m.enter(ctx)()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.orders[order.Number]; ok {
+		return database.ErrOrderExists
+	}
+
+	m.nextOrderID++
+	stored := *order
+	stored.ID = m.nextOrderID
+	m.orders[order.Number] = stored
+	m.appendOutbox(database.NewOrderUploadedEvent(stored))
+	return nil
+}
+
+func (m *MemoryStorage) CreateOrders(ctx context.Context, orders []models.Order) ([]database.OrderInsertResult, error) {
+	defer m.enter(ctx)()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	results := make([]database.OrderInsertResult, 0, len(orders))
+	for _, order := range orders {
+		if existing, ok := m.orders[order.Number]; ok {
+			results = append(results, database.OrderInsertResult{Number: order.Number, OwnerID: existing.UserID})
+			continue
+		}
+
+		m.nextOrderID++
+		order.ID = m.nextOrderID
+		m.orders[order.Number] = order
+		m.appendOutbox(database.NewOrderUploadedEvent(order))
+		results = append(results, database.OrderInsertResult{Number: order.Number, Inserted: true})
+	}
+	return results, nil
+}
+
+func (m *MemoryStorage) UpdateOrderFromAccrual(ctx context.Context, number string, status string, accrual money.Amount) error {
+	defer m.enter(ctx)()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	order, ok := m.orders[number]
+	if !ok {
+		return database.ErrNotFound
+	}
+
+	delta := processedAccrual(status, accrual) - processedAccrual(order.Status, order.Accrual)
+	if delta != 0 {
+		if _, err := m.postEntry(database.NewAccrualEntry(order.UserID, number, delta, time.Now())); err != nil {
+			return err
+		}
+	}
+
+	if order.Status != status || order.Accrual != accrual {
+		m.appendOutbox(database.NewOrderUpdatedEvent(order.UserID, number, status, accrual))
+	}
+
+	if order.Status != status {
+		poll := m.orderPolls[number]
+		poll.attempts = 0
+		m.orderPolls[number] = poll
+	}
+
+	order.Status = status
+	order.Accrual = accrual
+	m.orders[number] = order
+	return nil
+}
+
+func processedAccrual(status string, accrual money.Amount) money.Amount {
+	if status == "PROCESSED" {
+		return accrual
+	}
+	return 0
+}
+
+func (m *MemoryStorage) GetOrderByNumber(ctx context.Context, number string) (*models.Order, error) {
+	defer m.enter(ctx)()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	order, ok := m.orders[number]
+	if !ok {
+		return nil, database.ErrNotFound
+	}
+	return &order, nil
+}
+
+func (m *MemoryStorage) GetOrdersByUser(ctx context.Context, userID int) ([]models.Order, error) {
+	defer m.enter(ctx)()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var orders []models.Order
+	for _, order := range m.orders {
+		if order.UserID == userID {
+			orders = append(orders, order)
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		return orders[i].UploadedAt.After(orders[j].UploadedAt)
+	})
+	return orders, nil
+}
+
+func (m *MemoryStorage) QueryOrders(ctx context.Context, query database.OrderQuery) ([]models.Order, error) {
+	defer m.enter(ctx)()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var orders []models.Order
+	for _, order := range m.orders {
+		if query.Match(order) {
+			orders = append(orders, order)
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		return query.Less(*database.CursorOf(orders[i]), *database.CursorOf(orders[j]))
+	})
+	if query.Limit > 0 && len(orders) > query.Limit {
+		orders = orders[:query.Limit]
+	}
+	return orders, nil
+}
+
+func (m *MemoryStorage) GetPendingOrders(ctx context.Context, limit int) ([]models.Order, error) {
+	defer m.enter(ctx)()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	dueAt := func(order models.Order) time.Time {
+		if next := m.orderPolls[order.Number].nextAt; !next.IsZero() {
+			return next
+		}
+		return order.UploadedAt
+	}
+
+	var orders []models.Order
+	for _, order := range m.orders {
+		if (order.Status == "NEW" || order.Status == "PROCESSING") && !dueAt(order).After(now) {
+			orders = append(orders, order)
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		a, b := dueAt(orders[i]), dueAt(orders[j])
+		if !a.Equal(b) {
+			return a.Before(b)
+		}
+		return orders[i].ID < orders[j].ID
+	})
+	if limit >= 0 && len(orders) > limit {
+		orders = orders[:limit]
+	}
+
+	for _, order := range orders {
+		poll := m.orderPolls[order.Number]
+		poll.nextAt = now.Add(database.PollBackoff(poll.attempts))
+		poll.attempts++
+		m.orderPolls[order.Number] = poll
+	}
+	return orders, nil
+}
@@ -0,0 +1,71 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/alisaviation/internal/database"
+	"github.com/alisaviation/internal/gophermart/models"
+)
+
+// appendOutbox records an event; callers hold mu. Delivered events are
+// dropped right away, so the outbox only holds pending ones in ID order.
+func (m *MemoryStorage) appendOutbox(event models.OutboxEvent) {
+	m.nextOutboxID++
+	event.ID = m.nextOutboxID
+	m.outbox = append(m.outbox, event)
+}
+
+func (m *MemoryStorage) PendingOutboxEvents(ctx context.Context, limit int) ([]models.OutboxEvent, error) {
+	defer m.enter(ctx)()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := time.Now()
+	waiting := make(map[int]bool)
+	var events []models.OutboxEvent
+	for _, event := range m.outbox {
+		if len(events) == limit {
+			break
+		}
+		if waiting[event.UserID] {
+			continue
+		}
+		if event.NextAttemptAt.After(now) {
+			waiting[event.UserID] = true
+			continue
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+func (m *MemoryStorage) MarkOutboxEventDelivered(ctx context.Context, id int64) error {
+	defer m.enter(ctx)()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, event := range m.outbox {
+		if event.ID == id {
+			m.outbox = append(m.outbox[:i:i], m.outbox[i+1:]...)
+			return nil
+		}
+	}
+	return database.ErrNotFound
+}
+
+func (m *MemoryStorage) MarkOutboxEventFailed(ctx context.Context, id int64, nextAttemptAt time.Time, lastError string) error {
+	defer m.enter(ctx)()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.outbox {
+		if m.outbox[i].ID == id {
+			m.outbox[i].Attempts++
+			m.outbox[i].NextAttemptAt = nextAttemptAt
+			m.outbox[i].LastError = lastError
+			return nil
+		}
+	}
+	return database.ErrNotFound
+}
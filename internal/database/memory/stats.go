@@ -0,0 +1,39 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/alisaviation/internal/database"
+	"github.com/alisaviation/pkg/money"
+)
+
+func (m *MemoryStorage) CountOrdersByStatus(ctx context.Context) (map[string]int, error) {
+	defer m.enter(ctx)()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	counts := make(map[string]int)
+	for _, order := range m.orders {
+		counts[order.Status]++
+	}
+	return counts, nil
+}
+
+func (m *MemoryStorage) WithdrawalTotals(ctx context.Context) (int, money.Amount, error) {
+	defer m.enter(ctx)()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	count := 0
+	for _, entry := range m.journal {
+		if entry.Kind == database.EntryWithdrawal && !m.reversed[entry.ID] {
+			count++
+		}
+	}
+
+	var sum money.Amount
+	for _, balance := range m.balances {
+		sum += balance.Withdrawn
+	}
+	return count, sum, nil
+}
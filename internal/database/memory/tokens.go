@@ -0,0 +1,163 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/alisaviation/internal/database"
+	"github.com/alisaviation/internal/gophermart/models"
+)
+
+func (m *MemoryStorage) CreateSession(ctx context.Context, sessionID string, userID int) error {
+	defer m.enter(ctx)()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.sessions[sessionID]; ok {
+		return errors.New("session already exists")
+	}
+	m.sessions[sessionID] = session{userID: userID, createdAt: time.Now()}
+	return nil
+}
+
+func (m *MemoryStorage) RevokeSession(ctx context.Context, sessionID string) error {
+	defer m.enter(ctx)()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[sessionID]
+	if !ok || s.revokedAt != nil {
+		return nil
+	}
+	now := time.Now()
+	s.revokedAt = &now
+	m.sessions[sessionID] = s
+	return nil
+}
+
+func (m *MemoryStorage) CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	defer m.enter(ctx)()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.insertRefreshToken(token)
+}
+
+func (m *MemoryStorage) insertRefreshToken(token *models.RefreshToken) error {
+	if _, ok := m.refreshTokens[token.TokenHash]; ok {
+		return errors.New("refresh token already exists")
+	}
+
+	m.nextTokenID++
+	token.ID = m.nextTokenID
+	m.refreshTokens[token.TokenHash] = models.RefreshToken{
+		ID:        token.ID,
+		UserID:    token.UserID,
+		SessionID: token.SessionID,
+		TokenHash: token.TokenHash,
+		ExpiresAt: token.ExpiresAt,
+	}
+	return nil
+}
+
+func (m *MemoryStorage) GetRefreshToken(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	defer m.enter(ctx)()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	token, ok := m.refreshTokens[tokenHash]
+	if !ok {
+		return nil, nil
+	}
+
+	token.Login = m.users[token.UserID].Login
+	token.SessionRevoked = m.sessions[token.SessionID].revokedAt != nil
+	if token.UsedAt != nil {
+		usedAt := *token.UsedAt
+		token.UsedAt = &usedAt
+	}
+	return &token, nil
+}
+
+func (m *MemoryStorage) RotateRefreshToken(ctx context.Context, oldHash string, next *models.RefreshToken) error {
+	defer m.enter(ctx)()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	old, ok := m.refreshTokens[oldHash]
+	if !ok {
+		return database.ErrRefreshTokenUsed
+	}
+	if m.sessions[old.SessionID].revokedAt != nil {
+		return database.ErrSessionRevoked
+	}
+	if old.UsedAt != nil {
+		return database.ErrRefreshTokenUsed
+	}
+
+	if err := m.insertRefreshToken(next); err != nil {
+		return err
+	}
+	now := time.Now()
+	old.UsedAt = &now
+	m.refreshTokens[oldHash] = old
+	return nil
+}
+
+func (m *MemoryStorage) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	defer m.enter(ctx)()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.revokedTokens[jti]; !ok {
+		m.revokedTokens[jti] = expiresAt
+	}
+	return nil
+}
+
+func (m *MemoryStorage) IsTokenRevoked(ctx context.Context, jti, sessionID string) (bool, error) {
+	defer m.enter(ctx)()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, ok := m.revokedTokens[jti]; ok && jti != "" {
+		return true, nil
+	}
+	if sessionID == "" {
+		return false, nil
+	}
+	s, ok := m.sessions[sessionID]
+	return !ok || s.revokedAt != nil, nil
+}
+
+func (m *MemoryStorage) PurgeExpiredTokens(ctx context.Context) (int64, error) {
+	defer m.enter(ctx)()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var purged int64
+	live := make(map[string]bool)
+	for hash, token := range m.refreshTokens {
+		if token.ExpiresAt.Before(now) || m.sessions[token.SessionID].revokedAt != nil {
+			delete(m.refreshTokens, hash)
+			purged++
+			continue
+		}
+		live[token.SessionID] = true
+	}
+	for id, s := range m.sessions {
+		if !live[id] && (s.revokedAt != nil || s.createdAt.Before(now.Add(-database.SessionPurgeGrace))) {
+			delete(m.sessions, id)
+			purged++
+		}
+	}
+	for jti, expiresAt := range m.revokedTokens {
+		if expiresAt.Before(now) {
+			delete(m.revokedTokens, jti)
+			purged++
+		}
+	}
+	return purged, nil
+}
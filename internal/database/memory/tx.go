@@ -0,0 +1,103 @@
+package memory
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/alisaviation/internal/database"
+	"github.com/alisaviation/internal/gophermart/models"
+)
+
+type txKey struct {
+	storage *MemoryStorage
+}
+
+// snapshot holds everything a rollback has to restore. ID counters are left
+// alone, the same way Postgres sequences are not rolled back.
+type snapshot struct {
+	users         map[int]models.User
+	usersByLogin  map[string]int
+	orders        map[string]models.Order
+	orderPolls    map[string]orderPoll
+	withdrawals   map[string]models.Withdrawal
+	balances      map[int]models.Balance
+	journal       []models.JournalEntry
+	reversed      map[int64]bool
+	idempotency   map[idempotencyKey]models.IdempotencyRecord
+	sessions      map[string]session
+	refreshTokens map[string]models.RefreshToken
+	revokedTokens map[string]time.Time
+	outbox        []models.OutboxEvent
+	webhooks      map[int64]models.Webhook
+	deliveries    []models.WebhookDelivery
+}
+
+func (m *MemoryStorage) enter(ctx context.Context) func() {
+	if ctx.Value(txKey{m}) != nil {
+		return func() {}
+	}
+	m.txMu.RLock()
+	return m.txMu.RUnlock
+}
+
+func (m *MemoryStorage) WithinTx(ctx context.Context, opts database.TxOptions, fn func(ctx context.Context) error) error {
+	if ctx.Value(txKey{m}) != nil {
+		return fn(ctx)
+	}
+
+	m.txMu.Lock()
+	defer m.txMu.Unlock()
+
+	saved := m.snapshot()
+	if err := fn(context.WithValue(ctx, txKey{m}, true)); err != nil {
+		m.restore(saved)
+		return err
+	}
+	return nil
+}
+
+func (m *MemoryStorage) snapshot() snapshot {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return snapshot{
+		users:         maps.Clone(m.users),
+		usersByLogin:  maps.Clone(m.usersByLogin),
+		orders:        maps.Clone(m.orders),
+		orderPolls:    maps.Clone(m.orderPolls),
+		withdrawals:   maps.Clone(m.withdrawals),
+		balances:      maps.Clone(m.balances),
+		journal:       append([]models.JournalEntry(nil), m.journal...),
+		reversed:      maps.Clone(m.reversed),
+		idempotency:   maps.Clone(m.idempotency),
+		sessions:      maps.Clone(m.sessions),
+		refreshTokens: maps.Clone(m.refreshTokens),
+		revokedTokens: maps.Clone(m.revokedTokens),
+		outbox:        slices.Clone(m.outbox),
+		webhooks:      maps.Clone(m.webhooks),
+		deliveries:    slices.Clone(m.deliveries),
+	}
+}
+
+func (m *MemoryStorage) restore(s snapshot) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.users = s.users
+	m.usersByLogin = s.usersByLogin
+	m.orders = s.orders
+	m.orderPolls = s.orderPolls
+	m.withdrawals = s.withdrawals
+	m.balances = s.balances
+	m.journal = s.journal
+	m.reversed = s.reversed
+	m.idempotency = s.idempotency
+	m.sessions = s.sessions
+	m.refreshTokens = s.refreshTokens
+	m.revokedTokens = s.revokedTokens
+	m.outbox = s.outbox
+	m.webhooks = s.webhooks
+	m.deliveries = s.deliveries
+}
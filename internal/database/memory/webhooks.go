@@ -0,0 +1,208 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"time"
+
+	"github.com/alisaviation/internal/database"
+	"github.com/alisaviation/internal/gophermart/models"
+)
+
+func (m *MemoryStorage) CreateWebhook(ctx context.Context, webhook *models.Webhook) error {
+	defer m.enter(ctx)()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.nextWebhookID++
+	webhook.ID = m.nextWebhookID
+	webhook.CreatedAt = time.Now()
+	m.webhooks[webhook.ID] = cloneWebhook(*webhook)
+	return nil
+}
+
+func (m *MemoryStorage) GetWebhook(ctx context.Context, id int64) (*models.Webhook, error) {
+	defer m.enter(ctx)()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	webhook, ok := m.webhooks[id]
+	if !ok {
+		return nil, database.ErrNotFound
+	}
+	webhook = cloneWebhook(webhook)
+	return &webhook, nil
+}
+
+func (m *MemoryStorage) GetWebhooksByUser(ctx context.Context, userID int) ([]models.Webhook, error) {
+	defer m.enter(ctx)()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var webhooks []models.Webhook
+	for _, webhook := range m.webhooks {
+		if webhook.UserID == userID {
+			webhooks = append(webhooks, cloneWebhook(webhook))
+		}
+	}
+	slices.SortFunc(webhooks, func(a, b models.Webhook) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return webhooks, nil
+}
+
+func (m *MemoryStorage) UpdateWebhook(ctx context.Context, webhook *models.Webhook) error {
+	defer m.enter(ctx)()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.webhooks[webhook.ID]
+	if !ok {
+		return database.ErrNotFound
+	}
+	stored.URL = webhook.URL
+	stored.Secret = webhook.Secret
+	stored.Events = webhook.Events
+	stored.Enabled = webhook.Enabled
+	stored.ConsecutiveFailures = webhook.ConsecutiveFailures
+	stored.DisabledAt = webhook.DisabledAt
+	m.webhooks[webhook.ID] = cloneWebhook(stored)
+	return nil
+}
+
+func (m *MemoryStorage) DeleteWebhook(ctx context.Context, id int64) error {
+	defer m.enter(ctx)()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.webhooks[id]; !ok {
+		return database.ErrNotFound
+	}
+	delete(m.webhooks, id)
+	m.deliveries = slices.DeleteFunc(m.deliveries, func(d models.WebhookDelivery) bool {
+		return d.WebhookID == id
+	})
+	return nil
+}
+
+func (m *MemoryStorage) EnqueueWebhookDeliveries(ctx context.Context, userID int, delivery models.WebhookDelivery) (int, error) {
+	defer m.enter(ctx)()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var ids []int64
+	for id, webhook := range m.webhooks {
+		if webhook.UserID == userID && webhook.Enabled && database.Subscribed(webhook, delivery.EventType) {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+
+	queued := 0
+	now := time.Now()
+	for _, id := range ids {
+		exists := slices.ContainsFunc(m.deliveries, func(d models.WebhookDelivery) bool {
+			return d.WebhookID == id && d.EventID == delivery.EventID
+		})
+		if exists {
+			continue
+		}
+
+		m.nextDeliveryID++
+		m.deliveries = append(m.deliveries, models.WebhookDelivery{
+			ID:            m.nextDeliveryID,
+			WebhookID:     id,
+			EventID:       delivery.EventID,
+			EventType:     delivery.EventType,
+			Payload:       delivery.Payload,
+			Status:        database.WebhookDeliveryPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		})
+		queued++
+	}
+	return queued, nil
+}
+
+func (m *MemoryStorage) PendingWebhookDeliveries(ctx context.Context, limit int) ([]models.WebhookDelivery, error) {
+	defer m.enter(ctx)()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := time.Now()
+	var deliveries []models.WebhookDelivery
+	for _, delivery := range m.deliveries {
+		if len(deliveries) == limit {
+			break
+		}
+		if delivery.Status != database.WebhookDeliveryPending || delivery.NextAttemptAt.After(now) {
+			continue
+		}
+		if !m.webhooks[delivery.WebhookID].Enabled {
+			continue
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
+
+func (m *MemoryStorage) RecordWebhookAttempt(ctx context.Context, attempt models.WebhookAttempt, disableAfter int) (bool, error) {
+	defer m.enter(ctx)()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := slices.IndexFunc(m.deliveries, func(d models.WebhookDelivery) bool {
+		return d.ID == attempt.DeliveryID && d.Status == database.WebhookDeliveryPending
+	})
+	if i < 0 {
+		return false, database.ErrNotFound
+	}
+
+	delivery := &m.deliveries[i]
+	delivery.Attempts++
+	delivery.LastAttemptAt = &attempt.AttemptedAt
+	delivery.ResponseCode = attempt.ResponseCode
+	delivery.LastError = attempt.Error
+
+	webhook := m.webhooks[delivery.WebhookID]
+	disabled := false
+	if attempt.Succeeded {
+		delivery.Status = database.WebhookDeliverySucceeded
+		delivery.DeliveredAt = &attempt.AttemptedAt
+		webhook.ConsecutiveFailures = 0
+	} else {
+		if attempt.NextAttemptAt.IsZero() {
+			delivery.Status = database.WebhookDeliveryFailed
+		} else {
+			delivery.NextAttemptAt = attempt.NextAttemptAt
+		}
+		webhook.ConsecutiveFailures++
+		if webhook.Enabled && disableAfter > 0 && webhook.ConsecutiveFailures >= disableAfter {
+			webhook.Enabled = false
+			webhook.DisabledAt = &attempt.AttemptedAt
+			disabled = true
+		}
+	}
+	m.webhooks[webhook.ID] = webhook
+	return disabled, nil
+}
+
+func (m *MemoryStorage) GetWebhookDeliveries(ctx context.Context, webhookID int64, limit int) ([]models.WebhookDelivery, error) {
+	defer m.enter(ctx)()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var deliveries []models.WebhookDelivery
+	for i := len(m.deliveries) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		if m.deliveries[i].WebhookID == webhookID {
+			deliveries = append(deliveries, m.deliveries[i])
+		}
+	}
+	return deliveries, nil
+}
+
+func cloneWebhook(webhook models.Webhook) models.Webhook {
+	webhook.Events = slices.Clone(webhook.Events)
+	return webhook
+}
@@ -0,0 +1,92 @@
+package database
+
+import (
+	"slices"
+	"time"
+
+	"github.com/alisaviation/internal/gophermart/models"
+)
+
+// OrderInsertResult reports the outcome of one order of a batch insert.
+// When the number was already taken, OwnerID is the user it belongs to, or
+// zero if a concurrent insert made the owner unknown to this statement.
+type OrderInsertResult struct {
+	Number   string
+	Inserted bool
+	OwnerID  int
+}
+
+const (
+	PollBackoffBase = time.Second
+	PollBackoffMax  = 5 * time.Minute
+)
+
+// PollBackoff is how long a pending order waits for its next poll after
+// attempts earlier ones since its status last changed.
+func PollBackoff(attempts int) time.Duration {
+	delay := PollBackoffBase
+	for i := 0; i < attempts && delay < PollBackoffMax; i++ {
+		delay *= 2
+	}
+	return min(delay, PollBackoffMax)
+}
+
+// OrderQuery selects a page of a user's order history. Orders are sorted by
+// uploaded_at and then by ID, so pages stay stable when several orders share
+// an upload time.
+type OrderQuery struct {
+	UserID int
+	// Statuses keeps orders in any of the listed statuses; empty means all.
+	Statuses []string
+	// From is inclusive and To is exclusive; zero values leave the range open.
+	From time.Time
+	To   time.Time
+	// Ascending lists the oldest orders first.
+	Ascending bool
+	// After continues a previous page from the last order it returned.
+	After *OrderCursor
+	// Limit caps the number of orders returned; zero means no limit.
+	Limit int
+}
+
+// OrderCursor is the position of an order in the sort order of OrderQuery.
+type OrderCursor struct {
+	UploadedAt time.Time
+	ID         int
+}
+
+func CursorOf(order models.Order) *OrderCursor {
+	return &OrderCursor{UploadedAt: order.UploadedAt, ID: order.ID}
+}
+
+// Match reports whether order passes the filters and lies after the cursor
+// of q. Backends that cannot express the query natively filter with it.
+func (q OrderQuery) Match(order models.Order) bool {
+	if order.UserID != q.UserID {
+		return false
+	}
+	if len(q.Statuses) > 0 && !slices.Contains(q.Statuses, order.Status) {
+		return false
+	}
+	if !q.From.IsZero() && order.UploadedAt.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !order.UploadedAt.Before(q.To) {
+		return false
+	}
+	if q.After != nil {
+		return q.Less(*q.After, *CursorOf(order))
+	}
+	return true
+}
+
+// Less reports whether a comes before b in the sort order of q.
+func (q OrderQuery) Less(a, b OrderCursor) bool {
+	if !a.UploadedAt.Equal(b.UploadedAt) {
+		return a.UploadedAt.Before(b.UploadedAt) == q.Ascending
+	}
+	if a.ID == b.ID {
+		return false
+	}
+	return (a.ID < b.ID) == q.Ascending
+}
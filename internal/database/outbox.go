@@ -0,0 +1,78 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/alisaviation/internal/gophermart/models"
+	"github.com/alisaviation/pkg/money"
+)
+
+const (
+	EventUserRegistered    = "user.registered"
+	EventOrderUploaded     = "order.uploaded"
+	EventOrderUpdated      = "order.updated"
+	EventWithdrawalCreated = "withdrawal.created"
+)
+
+// Outbox is read by the relay. Events are written by the storage methods
+// that make the corresponding change, in the same transaction.
+type Outbox interface {
+	// PendingOutboxEvents returns undelivered events that are due, oldest
+	// first. An event is only returned together with every earlier
+	// undelivered event of the same user, so a relay that delivers them in
+	// order never overtakes an event that is waiting for a retry.
+	PendingOutboxEvents(ctx context.Context, limit int) ([]models.OutboxEvent, error)
+	MarkOutboxEventDelivered(ctx context.Context, id int64) error
+	// MarkOutboxEventFailed counts a failed attempt and holds the event and
+	// the later events of its user back until nextAttemptAt.
+	MarkOutboxEventFailed(ctx context.Context, id int64, nextAttemptAt time.Time, lastError string) error
+}
+
+func NewUserRegisteredEvent(user models.User) models.OutboxEvent {
+	return newOutboxEvent(EventUserRegistered, user.ID, struct {
+		UserID int    `json:"user_id"`
+		Login  string `json:"login"`
+	}{user.ID, user.Login})
+}
+
+func NewOrderUploadedEvent(order models.Order) models.OutboxEvent {
+	return newOutboxEvent(EventOrderUploaded, order.UserID, struct {
+		Number     string    `json:"number"`
+		Status     string    `json:"status"`
+		UploadedAt time.Time `json:"uploaded_at"`
+	}{order.Number, order.Status, order.UploadedAt})
+}
+
+func NewOrderUpdatedEvent(userID int, number, status string, accrual money.Amount) models.OutboxEvent {
+	return newOutboxEvent(EventOrderUpdated, userID, struct {
+		Number  string       `json:"number"`
+		Status  string       `json:"status"`
+		Accrual money.Amount `json:"accrual"`
+	}{number, status, accrual})
+}
+
+func NewWithdrawalCreatedEvent(withdrawal *models.Withdrawal) models.OutboxEvent {
+	return newOutboxEvent(EventWithdrawalCreated, withdrawal.UserID, struct {
+		Order       string       `json:"order"`
+		Sum         money.Amount `json:"sum"`
+		ProcessedAt time.Time    `json:"processed_at"`
+	}{withdrawal.OrderNumber, withdrawal.Sum, withdrawal.ProcessedAt})
+}
+
+func newOutboxEvent(kind string, userID int, payload any) models.OutboxEvent {
+	// The payloads above are plain structs that always marshal.
+	data, err := json.Marshal(payload)
+	if err != nil {
+		panic(err)
+	}
+	now := time.Now()
+	return models.OutboxEvent{
+		Type:          kind,
+		UserID:        userID,
+		Payload:       data,
+		CreatedAt:     now,
+		NextAttemptAt: now,
+	}
+}
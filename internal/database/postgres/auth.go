@@ -1,23 +1,47 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 
+	"github.com/alisaviation/internal/database"
 	"github.com/alisaviation/internal/gophermart/models"
 )
 
-func (p *PostgresStorage) CreateUser(user models.User) (int, error) {
-	var id int
-	err := p.db.QueryRow(
+func (p *PostgresStorage) CreateUser(ctx context.Context, user models.User) (int, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := p.begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx,
 		"INSERT INTO users (login, password_hash) VALUES ($1, $2) RETURNING id",
 		user.Login, user.PasswordHash,
-	).Scan(&id)
-	return id, err
+	).Scan(&user.ID)
+	if isUniqueViolation(err) {
+		return 0, database.ErrUserExists
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if err := insertOutboxEvent(ctx, tx, database.NewUserRegisteredEvent(user)); err != nil {
+		return 0, err
+	}
+	return user.ID, tx.Commit()
 }
 
-func (p *PostgresStorage) GetUserByLogin(login string) (*models.User, error) {
+func (p *PostgresStorage) GetUserByLogin(ctx context.Context, login string) (*models.User, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
 	var user models.User
-	err := p.db.QueryRow(
+	err := p.conn(ctx).QueryRowContext(ctx,
 		"SELECT id, login, password_hash FROM users WHERE login = $1",
 		login,
 	).Scan(&user.ID, &user.Login, &user.PasswordHash)
@@ -1,61 +1,197 @@
 package postgres
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 
+	"github.com/lib/pq"
+
+	"github.com/alisaviation/internal/database"
 	"github.com/alisaviation/internal/gophermart/models"
+	"github.com/alisaviation/pkg/money"
+)
+
+const (
+	uniqueViolationCode = "23505"
+	checkViolationCode  = "23514"
 )
 
-func (p *PostgresStorage) GetBalance(userID int) (*models.Balance, error) {
+type execQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == uniqueViolationCode
+}
+
+func isCheckViolation(err error, constraint string) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == checkViolationCode && pqErr.Constraint == constraint
+}
+
+func (p *PostgresStorage) GetBalance(ctx context.Context, userID int) (*models.Balance, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
 	balance := &models.Balance{
 		UserID: userID,
 	}
 
-	err := p.db.QueryRow(`
-        SELECT COALESCE(
-            (SELECT SUM(accrual) 
-             FROM orders 
-             WHERE user_id = $1 AND status = 'PROCESSED')
-            -
-            (SELECT COALESCE(SUM(sum), 0)
-             FROM withdrawals 
-             WHERE user_id = $1)
-        , 0) AS current_balance`,
-		userID).Scan(&balance.Current)
+	err := p.conn(ctx).QueryRowContext(ctx, `
+        SELECT
+            COALESCE(SUM(amount) FILTER (WHERE account = $2), 0),
+            COALESCE(SUM(amount) FILTER (WHERE account = $3), 0)
+        FROM postings
+        WHERE user_id = $1`,
+		userID, database.AccountUserPoints, database.AccountUserWithdrawn,
+	).Scan(&balance.Current, &balance.Withdrawn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get balance: %w", err)
+	}
+
+	return balance, nil
+}
+
+func (p *PostgresStorage) Withdraw(ctx context.Context, withdrawal *models.Withdrawal) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
 
+	tx, err := p.begin(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get current balance:: %w", err)
+		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
+	defer tx.Rollback()
 
-	err = p.db.QueryRow(`
-        SELECT COALESCE(SUM(sum), 0) 
-        FROM withdrawals 
-        WHERE user_id = $1`,
-		userID).Scan(&balance.Withdrawn)
+	current, err := lockBalance(ctx, tx, withdrawal.UserID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get withdrawn balance: %w", err)
+		return err
+	}
+	if current < withdrawal.Sum {
+		return database.ErrInsufficientFunds
+	}
+	if err := insertWithdrawal(ctx, tx, withdrawal); err != nil {
+		return err
 	}
 
-	return balance, nil
+	return tx.Commit()
 }
 
-func (p *PostgresStorage) CreateWithdrawal(withdrawal *models.Withdrawal) error {
-	query := `
-		INSERT INTO withdrawals (user_id, order_number, sum, processed_at)
-		VALUES ($1, $2, $3, $4)`
+func lockBalance(ctx context.Context, tx execQuerier, userID int) (money.Amount, error) {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO balances (user_id) VALUES ($1)
+		ON CONFLICT (user_id) DO NOTHING`, userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to init user balance: %w", err)
+	}
 
-	_, err := p.db.Exec(query,
+	var current money.Amount
+	err = tx.QueryRowContext(ctx, `SELECT current FROM balances WHERE user_id = $1 FOR UPDATE`, userID).Scan(&current)
+	if err != nil {
+		return 0, fmt.Errorf("failed to lock user balance: %w", err)
+	}
+	return current, nil
+}
+
+// lockBalances locks the balances of userIDs in ascending order, so that
+// concurrent batches cannot deadlock on each other.
+func lockBalances(ctx context.Context, tx execQuerier, userIDs []int64) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO balances (user_id) SELECT DISTINCT unnest($1::integer[]) ORDER BY 1
+		ON CONFLICT (user_id) DO NOTHING`, pq.Array(userIDs))
+	if err != nil {
+		return fmt.Errorf("failed to init user balances: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		SELECT user_id FROM balances WHERE user_id = ANY($1::integer[]) ORDER BY user_id FOR UPDATE`,
+		pq.Array(userIDs))
+	if err != nil {
+		return fmt.Errorf("failed to lock user balances: %w", err)
+	}
+	return nil
+}
+
+func insertWithdrawal(ctx context.Context, tx execQuerier, withdrawal *models.Withdrawal) error {
+	err := tx.QueryRowContext(ctx, `
+		INSERT INTO withdrawals (user_id, order_number, sum, processed_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id`,
 		withdrawal.UserID,
 		withdrawal.OrderNumber,
 		withdrawal.Sum,
-		withdrawal.ProcessedAt)
+		withdrawal.ProcessedAt,
+	).Scan(&withdrawal.ID)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return database.ErrWithdrawalExists
+		}
+		if isCheckViolation(err, "withdrawals_sum_positive") {
+			return database.ErrInvalidSum
+		}
+		return fmt.Errorf("failed to insert withdrawal: %w", err)
+	}
 
-	return err
+	if _, err := postEntry(ctx, tx, database.NewWithdrawalEntry(withdrawal)); err != nil {
+		return err
+	}
+	return insertOutboxEvent(ctx, tx, database.NewWithdrawalCreatedEvent(withdrawal))
 }
 
-func (p *PostgresStorage) WithdrawalExists(orderNumber string) (bool, error) {
+func (p *PostgresStorage) ReconcileBalances(ctx context.Context) ([]models.BalanceMismatch, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := p.conn(ctx).QueryContext(ctx, `
+		SELECT u.id,
+		       COALESCE(b.current, 0),
+		       COALESCE(b.withdrawn, 0),
+		       COALESCE(l.current, 0),
+		       COALESCE(l.withdrawn, 0)
+		FROM users u
+		LEFT JOIN balances b ON b.user_id = u.id
+		LEFT JOIN (
+		    SELECT user_id,
+		           SUM(amount) FILTER (WHERE account = $1) AS current,
+		           SUM(amount) FILTER (WHERE account = $2) AS withdrawn
+		    FROM postings
+		    WHERE user_id IS NOT NULL
+		    GROUP BY user_id
+		) l ON l.user_id = u.id
+		WHERE COALESCE(b.current, 0) <> COALESCE(l.current, 0)
+		   OR COALESCE(b.withdrawn, 0) <> COALESCE(l.withdrawn, 0)
+		ORDER BY u.id`,
+		database.AccountUserPoints, database.AccountUserWithdrawn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reconcile balances: %w", err)
+	}
+	defer rows.Close()
+
+	var mismatches []models.BalanceMismatch
+	for rows.Next() {
+		var m models.BalanceMismatch
+		if err := rows.Scan(&m.UserID, &m.Current, &m.Withdrawn, &m.ExpectedCurrent, &m.ExpectedWithdrawn); err != nil {
+			return nil, fmt.Errorf("failed to scan balance mismatch: %w", err)
+		}
+		mismatches = append(mismatches, m)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return mismatches, nil
+}
+
+func (p *PostgresStorage) WithdrawalExists(ctx context.Context, orderNumber string) (bool, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
 	var exists bool
-	err := p.db.QueryRow(`
+	err := p.conn(ctx).QueryRowContext(ctx, `
 		SELECT EXISTS (
 			SELECT 1 FROM withdrawals 
 			WHERE order_number = $1
@@ -64,14 +200,22 @@ func (p *PostgresStorage) WithdrawalExists(orderNumber string) (bool, error) {
 	return exists, err
 }
 
-func (p *PostgresStorage) GetWithdrawals(userID int) ([]models.Withdrawal, error) {
-	query := `
-		SELECT order_number, sum, processed_at
-		FROM withdrawals
-		WHERE user_id = $1
-		ORDER BY processed_at ASC`
+func (p *PostgresStorage) GetWithdrawals(ctx context.Context, userID int) ([]models.Withdrawal, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
 
-	rows, err := p.db.Query(query, userID)
+	query := `
+		SELECT e.withdrawal_id, e.user_id, e.order_number, p.amount, e.created_at
+		FROM journal_entries e
+		JOIN postings p ON p.entry_id = e.id AND p.account = $2
+		WHERE e.user_id = $1
+		  AND e.kind = $3
+		  AND NOT EXISTS (
+		      SELECT 1 FROM journal_entries r WHERE r.reverses_entry_id = e.id
+		  )
+		ORDER BY e.created_at ASC`
+
+	rows, err := p.conn(ctx).QueryContext(ctx, query, userID, database.AccountUserWithdrawn, database.EntryWithdrawal)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query withdrawals: %w", err)
 	}
@@ -80,7 +224,7 @@ func (p *PostgresStorage) GetWithdrawals(userID int) ([]models.Withdrawal, error
 	var withdrawals []models.Withdrawal
 	for rows.Next() {
 		var w models.Withdrawal
-		if err := rows.Scan(&w.OrderNumber, &w.Sum, &w.ProcessedAt); err != nil {
+		if err := rows.Scan(&w.ID, &w.UserID, &w.OrderNumber, &w.Sum, &w.ProcessedAt); err != nil {
 			return nil, fmt.Errorf("failed to scan withdrawal: %w", err)
 		}
 		withdrawals = append(withdrawals, w)
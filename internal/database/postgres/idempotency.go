@@ -0,0 +1,116 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/alisaviation/internal/gophermart/models"
+)
+
+func (p *PostgresStorage) ReserveIdempotencyKey(ctx context.Context, record *models.IdempotencyRecord) (*models.IdempotencyRecord, bool, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	var reserved bool
+	err := p.conn(ctx).QueryRowContext(ctx, `
+		INSERT INTO idempotency_keys (user_id, key, request_hash, expires_at, locked_until)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id, key) DO UPDATE
+		SET request_hash = EXCLUDED.request_hash,
+		    completed = FALSE,
+		    status_code = 0,
+		    headers = '{}',
+		    body = '',
+		    expires_at = EXCLUDED.expires_at,
+		    locked_until = EXCLUDED.locked_until
+		WHERE idempotency_keys.expires_at < NOW()
+		   OR (NOT idempotency_keys.completed
+		       AND idempotency_keys.request_hash = EXCLUDED.request_hash
+		       AND COALESCE(idempotency_keys.locked_until, '-infinity') < NOW())
+		RETURNING TRUE`,
+		record.UserID, record.Key, record.RequestHash, record.ExpiresAt, record.LockedUntil,
+	).Scan(&reserved)
+	if err == nil {
+		return nil, true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, false, fmt.Errorf("failed to reserve idempotency key: %w", err)
+	}
+
+	existing := &models.IdempotencyRecord{}
+	var headers []byte
+	var lockedUntil sql.NullTime
+	err = p.conn(ctx).QueryRowContext(ctx, `
+		SELECT user_id, key, request_hash, completed, status_code, headers, body, expires_at, locked_until
+		FROM idempotency_keys
+		WHERE user_id = $1 AND key = $2`,
+		record.UserID, record.Key,
+	).Scan(
+		&existing.UserID,
+		&existing.Key,
+		&existing.RequestHash,
+		&existing.Completed,
+		&existing.StatusCode,
+		&headers,
+		&existing.Body,
+		&existing.ExpiresAt,
+		&lockedUntil,
+	)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get idempotency key: %w", err)
+	}
+	if err := json.Unmarshal(headers, &existing.Headers); err != nil {
+		return nil, false, fmt.Errorf("failed to decode stored headers: %w", err)
+	}
+	existing.LockedUntil = lockedUntil.Time
+
+	return existing, false, nil
+}
+
+func (p *PostgresStorage) CompleteIdempotencyKey(ctx context.Context, record *models.IdempotencyRecord) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	headers, err := json.Marshal(record.Headers)
+	if err != nil {
+		return fmt.Errorf("failed to encode headers: %w", err)
+	}
+
+	_, err = p.conn(ctx).ExecContext(ctx, `
+		UPDATE idempotency_keys
+		SET completed = TRUE, status_code = $3, headers = $4, body = $5
+		WHERE user_id = $1 AND key = $2`,
+		record.UserID, record.Key, record.StatusCode, string(headers), record.Body)
+	if err != nil {
+		return fmt.Errorf("failed to complete idempotency key: %w", err)
+	}
+	return nil
+}
+
+func (p *PostgresStorage) ReleaseIdempotencyKey(ctx context.Context, userID int, key string) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	_, err := p.conn(ctx).ExecContext(ctx, `
+		DELETE FROM idempotency_keys
+		WHERE user_id = $1 AND key = $2 AND completed = FALSE`,
+		userID, key)
+	if err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+	return nil
+}
+
+func (p *PostgresStorage) PurgeExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	result, err := p.conn(ctx).ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires_at < NOW()`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge idempotency keys: %w", err)
+	}
+	return result.RowsAffected()
+}
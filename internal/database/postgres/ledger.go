@@ -0,0 +1,209 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/alisaviation/internal/database"
+	"github.com/alisaviation/internal/gophermart/models"
+	"github.com/alisaviation/pkg/money"
+)
+
+func postEntry(ctx context.Context, tx execQuerier, entry models.JournalEntry) (*models.JournalEntry, error) {
+	if err := database.ValidateEntry(entry); err != nil {
+		return nil, err
+	}
+
+	err := tx.QueryRowContext(ctx, `
+		INSERT INTO journal_entries (kind, user_id, order_number, withdrawal_id, reverses_entry_id, description, created_at)
+		VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, 0), $5, $6, $7)
+		RETURNING id`,
+		entry.Kind,
+		entry.UserID,
+		entry.OrderNumber,
+		entry.WithdrawalID,
+		entry.ReversesEntryID,
+		entry.Description,
+		entry.CreatedAt,
+	).Scan(&entry.ID)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, database.ErrAlreadyReversed
+		}
+		return nil, fmt.Errorf("failed to insert journal entry: %w", err)
+	}
+
+	for _, p := range entry.Postings {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO postings (entry_id, account, user_id, amount)
+			VALUES ($1, $2, NULLIF($3, 0), $4)`,
+			entry.ID, p.Account, p.UserID, p.Amount)
+		if err != nil {
+			return nil, fmt.Errorf("failed to insert posting: %w", err)
+		}
+	}
+
+	current, withdrawn := database.UserDeltas(entry)
+	if current != 0 || withdrawn != 0 {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO balances (user_id, current, withdrawn, version) VALUES ($1, $2, $3, 1)
+			ON CONFLICT (user_id) DO UPDATE
+			SET current = balances.current + EXCLUDED.current,
+			    withdrawn = balances.withdrawn + EXCLUDED.withdrawn,
+			    version = balances.version + 1`,
+			entry.UserID, current, withdrawn)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update balance: %w", err)
+		}
+	}
+
+	return &entry, nil
+}
+
+func (p *PostgresStorage) RecordAdjustment(ctx context.Context, userID int, amount money.Amount, description string) (*models.JournalEntry, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := p.begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	current, err := lockBalance(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if current+amount < 0 {
+		return nil, database.ErrInsufficientFunds
+	}
+
+	entry, err := postEntry(ctx, tx, database.NewAdjustmentEntry(userID, amount, description, time.Now()))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit adjustment: %w", err)
+	}
+	return entry, nil
+}
+
+func (p *PostgresStorage) ReverseEntry(ctx context.Context, entryID int64, description string) (*models.JournalEntry, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := p.begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var userID int
+	err = tx.QueryRowContext(ctx, `SELECT user_id FROM journal_entries WHERE id = $1`, entryID).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get journal entry: %w", err)
+	}
+
+	current, err := lockBalance(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := queryJournal(ctx, tx, `WHERE e.id = $1`, entryID)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, ErrNotFound
+	}
+
+	reversal := database.NewReversalEntry(entries[0], description, time.Now())
+	delta, _ := database.UserDeltas(reversal)
+	if current+delta < 0 {
+		return nil, database.ErrInsufficientFunds
+	}
+
+	entry, err := postEntry(ctx, tx, reversal)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit reversal: %w", err)
+	}
+	return entry, nil
+}
+
+func (p *PostgresStorage) GetJournal(ctx context.Context, userID int) ([]models.JournalEntry, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	return queryJournal(ctx, p.conn(ctx), `WHERE e.user_id = $1`, userID)
+}
+
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func queryJournal(ctx context.Context, q querier, where string, arg any) ([]models.JournalEntry, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT e.id, e.kind, e.user_id, COALESCE(e.order_number, ''), COALESCE(e.withdrawal_id, 0),
+		       e.reverses_entry_id, e.description, e.created_at,
+		       p.account, COALESCE(p.user_id, 0), p.amount
+		FROM journal_entries e
+		JOIN postings p ON p.entry_id = e.id
+		`+where+`
+		ORDER BY e.created_at ASC, e.id ASC, p.id ASC`, arg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query journal: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []models.JournalEntry
+	for rows.Next() {
+		var (
+			entry    models.JournalEntry
+			reverses sql.NullInt64
+			posting  models.Posting
+		)
+		err := rows.Scan(
+			&entry.ID,
+			&entry.Kind,
+			&entry.UserID,
+			&entry.OrderNumber,
+			&entry.WithdrawalID,
+			&reverses,
+			&entry.Description,
+			&entry.CreatedAt,
+			&posting.Account,
+			&posting.UserID,
+			&posting.Amount,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan journal entry: %w", err)
+		}
+		if reverses.Valid {
+			entry.ReversesEntryID = &reverses.Int64
+		}
+
+		if n := len(entries); n > 0 && entries[n-1].ID == entry.ID {
+			entries[n-1].Postings = append(entries[n-1].Postings, posting)
+			continue
+		}
+		entry.Postings = []models.Posting{posting}
+		entries = append(entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return entries, nil
+}
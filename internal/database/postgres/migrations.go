@@ -0,0 +1,188 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/lib/pq"
+)
+
+const undefinedTableCode = "42P01"
+
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+// MigrationStatus describes the schema version recorded in the database
+// against the newest migration compiled into the binary.
+type MigrationStatus struct {
+	Version uint
+	Latest  uint
+	Dirty   bool
+	// Applied is false on a database that has never been migrated.
+	Applied bool
+}
+
+func (s MigrationStatus) Pending() bool {
+	return !s.Applied || s.Version < s.Latest
+}
+
+// Migrator applies the migrations embedded in the binary, so it works the
+// same wherever the binary is deployed.
+type Migrator struct {
+	m *migrate.Migrate
+}
+
+// NewMigrator holds one connection of db until Close; db itself stays open.
+func NewMigrator(ctx context.Context, db *sql.DB) (*Migrator, error) {
+	src, err := migrationSource()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		src.Close()
+		return nil, fmt.Errorf("failed to acquire connection: %w", err)
+	}
+
+	driver, err := postgres.WithConnection(ctx, conn, &postgres.Config{})
+	if err != nil {
+		src.Close()
+		conn.Close()
+		return nil, fmt.Errorf("failed to create postgres driver: %w", err)
+	}
+
+	m, err := migrate.NewWithInstance("iofs", src, "postgres", driver)
+	if err != nil {
+		src.Close()
+		driver.Close()
+		return nil, fmt.Errorf("failed to create migrate instance: %w", err)
+	}
+	return &Migrator{m: m}, nil
+}
+
+// Migrate brings the schema up to date. It is what the server runs on start
+// unless auto-migration is disabled.
+func Migrate(ctx context.Context, db *sql.DB) error {
+	m, err := NewMigrator(ctx, db)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	return m.Up()
+}
+
+// SchemaStatus reports the migration status of db without changing it. It
+// reads the version table directly rather than through a Migrator, which
+// would create the table and wait on the migration lock held by a replica
+// that is migrating.
+func SchemaStatus(ctx context.Context, db *sql.DB) (MigrationStatus, error) {
+	latest, err := LatestMigration()
+	if err != nil {
+		return MigrationStatus{}, err
+	}
+
+	var version int64
+	var dirty bool
+	err = db.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	var pqErr *pq.Error
+	switch {
+	case errors.Is(err, sql.ErrNoRows), errors.As(err, &pqErr) && pqErr.Code == undefinedTableCode:
+		return MigrationStatus{Latest: latest}, nil
+	case err != nil:
+		return MigrationStatus{}, fmt.Errorf("failed to read schema version: %w", err)
+	case version < 0:
+		return MigrationStatus{Latest: latest, Dirty: dirty}, nil
+	}
+	return MigrationStatus{Version: uint(version), Latest: latest, Dirty: dirty, Applied: true}, nil
+}
+
+func (m *Migrator) Close() error {
+	srcErr, dbErr := m.m.Close()
+	return errors.Join(srcErr, dbErr)
+}
+
+func (m *Migrator) Up() error {
+	if err := m.m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return fmt.Errorf("failed to apply migrations: %w", err)
+	}
+	return nil
+}
+
+// Down rolls back the given number of migrations.
+func (m *Migrator) Down(steps int) error {
+	if steps <= 0 {
+		return fmt.Errorf("invalid number of steps %d", steps)
+	}
+	if err := m.m.Steps(-steps); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return fmt.Errorf("failed to roll back migrations: %w", err)
+	}
+	return nil
+}
+
+// Force records version as applied and clears the dirty flag without running
+// any migration. It is the way out after a migration failed halfway and the
+// schema was repaired by hand.
+func (m *Migrator) Force(version int) error {
+	if err := m.m.Force(version); err != nil {
+		return fmt.Errorf("failed to force version %d: %w", version, err)
+	}
+	return nil
+}
+
+func (m *Migrator) Status() (MigrationStatus, error) {
+	latest, err := LatestMigration()
+	if err != nil {
+		return MigrationStatus{}, err
+	}
+
+	version, dirty, err := m.m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		return MigrationStatus{Latest: latest}, nil
+	}
+	if err != nil {
+		return MigrationStatus{}, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return MigrationStatus{Version: version, Latest: latest, Dirty: dirty, Applied: true}, nil
+}
+
+// LatestMigration returns the newest migration version embedded in the binary.
+func LatestMigration() (uint, error) {
+	src, err := migrationSource()
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	version, err := src.First()
+	if err != nil {
+		return 0, fmt.Errorf("no migrations found: %w", err)
+	}
+	for {
+		next, err := src.Next(version)
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, fs.ErrNotExist) {
+			return version, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to read migrations: %w", err)
+		}
+		version = next
+	}
+}
+
+func migrationSource() (source.Driver, error) {
+	src, err := iofs.New(migrationsFS, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open embedded migrations: %w", err)
+	}
+	return src, nil
+}
@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS balances (
+    user_id INTEGER PRIMARY KEY REFERENCES users(id),
+    current DECIMAL(10, 2) NOT NULL DEFAULT 0,
+    withdrawn DECIMAL(10, 2) NOT NULL DEFAULT 0,
+    version BIGINT NOT NULL DEFAULT 0
+);
+
+INSERT INTO balances (user_id, current, withdrawn)
+SELECT u.id,
+       COALESCE(o.accrued, 0) - COALESCE(w.withdrawn, 0),
+       COALESCE(w.withdrawn, 0)
+FROM users u
+LEFT JOIN (
+    SELECT user_id, SUM(accrual) AS accrued
+    FROM orders
+    WHERE status = 'PROCESSED'
+    GROUP BY user_id
+) o ON o.user_id = u.id
+LEFT JOIN (
+    SELECT user_id, SUM(sum) AS withdrawn
+    FROM withdrawals
+    GROUP BY user_id
+) w ON w.user_id = u.id
+ON CONFLICT (user_id) DO NOTHING;
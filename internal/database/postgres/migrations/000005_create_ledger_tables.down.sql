@@ -0,0 +1,7 @@
+DROP TRIGGER IF EXISTS postings_balanced ON postings;
+DROP TRIGGER IF EXISTS postings_append_only ON postings;
+DROP TRIGGER IF EXISTS journal_entries_append_only ON journal_entries;
+DROP FUNCTION IF EXISTS check_journal_entry_balanced();
+DROP FUNCTION IF EXISTS reject_ledger_mutation();
+DROP TABLE postings;
+DROP TABLE journal_entries;
\ No newline at end of file
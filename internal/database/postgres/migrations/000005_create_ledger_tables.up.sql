@@ -0,0 +1,78 @@
+CREATE TABLE IF NOT EXISTS journal_entries (
+    id BIGSERIAL PRIMARY KEY,
+    kind TEXT NOT NULL,
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    order_number TEXT,
+    withdrawal_id INTEGER REFERENCES withdrawals(id),
+    reverses_entry_id BIGINT UNIQUE REFERENCES journal_entries(id),
+    description TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS journal_entries_user_id_idx ON journal_entries (user_id, created_at);
+
+CREATE TABLE IF NOT EXISTS postings (
+    id BIGSERIAL PRIMARY KEY,
+    entry_id BIGINT NOT NULL REFERENCES journal_entries(id),
+    account TEXT NOT NULL,
+    user_id INTEGER REFERENCES users(id),
+    amount DECIMAL(12, 2) NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS postings_entry_id_idx ON postings (entry_id);
+CREATE INDEX IF NOT EXISTS postings_account_user_id_idx ON postings (account, user_id);
+
+CREATE OR REPLACE FUNCTION reject_ledger_mutation() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'ledger table % is append-only', TG_TABLE_NAME;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER journal_entries_append_only
+    BEFORE UPDATE OR DELETE ON journal_entries
+    FOR EACH ROW EXECUTE FUNCTION reject_ledger_mutation();
+
+CREATE TRIGGER postings_append_only
+    BEFORE UPDATE OR DELETE ON postings
+    FOR EACH ROW EXECUTE FUNCTION reject_ledger_mutation();
+
+CREATE OR REPLACE FUNCTION check_journal_entry_balanced() RETURNS trigger AS $$
+BEGIN
+    IF (SELECT COALESCE(SUM(amount), 0) FROM postings WHERE entry_id = NEW.entry_id) <> 0 THEN
+        RAISE EXCEPTION 'journal entry % is not balanced', NEW.entry_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE CONSTRAINT TRIGGER postings_balanced
+    AFTER INSERT ON postings
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE FUNCTION check_journal_entry_balanced();
+
+WITH accruals AS (
+    INSERT INTO journal_entries (kind, user_id, order_number, created_at)
+    SELECT 'ACCRUAL', user_id, number, uploaded_at
+    FROM orders
+    WHERE status = 'PROCESSED' AND accrual > 0
+    RETURNING id, user_id, order_number
+)
+INSERT INTO postings (entry_id, account, user_id, amount)
+SELECT a.id, 'USER_POINTS', a.user_id, o.accrual
+FROM accruals a JOIN orders o ON o.number = a.order_number
+UNION ALL
+SELECT a.id, 'ACCRUALS', NULL, -o.accrual
+FROM accruals a JOIN orders o ON o.number = a.order_number;
+
+WITH spent AS (
+    INSERT INTO journal_entries (kind, user_id, order_number, withdrawal_id, created_at)
+    SELECT 'WITHDRAWAL', user_id, order_number, id, COALESCE(processed_at, NOW())
+    FROM withdrawals
+    RETURNING id, user_id, withdrawal_id
+)
+INSERT INTO postings (entry_id, account, user_id, amount)
+SELECT s.id, 'USER_POINTS', s.user_id, -w.sum
+FROM spent s JOIN withdrawals w ON w.id = s.withdrawal_id
+UNION ALL
+SELECT s.id, 'USER_WITHDRAWN', s.user_id, w.sum
+FROM spent s JOIN withdrawals w ON w.id = s.withdrawal_id;
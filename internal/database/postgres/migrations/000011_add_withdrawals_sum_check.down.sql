@@ -0,0 +1 @@
+ALTER TABLE withdrawals DROP CONSTRAINT IF EXISTS withdrawals_sum_positive;
@@ -0,0 +1,16 @@
+-- Older releases accepted non-positive sums and 000005 journaled them, so the
+-- rows cannot simply be deleted. The check is added NOT VALID so such rows do
+-- not block startup, and validated only when none exist. Otherwise, once they
+-- are corrected, run:
+--   ALTER TABLE withdrawals VALIDATE CONSTRAINT withdrawals_sum_positive;
+ALTER TABLE withdrawals ADD CONSTRAINT withdrawals_sum_positive CHECK (sum > 0) NOT VALID;
+
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM withdrawals WHERE sum <= 0) THEN
+        RAISE NOTICE 'withdrawals_sum_positive left NOT VALID: non-positive withdrawals exist';
+    ELSE
+        ALTER TABLE withdrawals VALIDATE CONSTRAINT withdrawals_sum_positive;
+    END IF;
+END;
+$$;
@@ -0,0 +1,7 @@
+ALTER TABLE orders
+    ADD COLUMN IF NOT EXISTS next_poll_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN IF NOT EXISTS poll_attempts INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS orders_pending_next_poll_at_idx
+    ON orders ((COALESCE(next_poll_at, uploaded_at)))
+    WHERE status IN ('NEW', 'PROCESSING');
@@ -1,36 +1,208 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
 
+	"github.com/lib/pq"
+
+	"github.com/alisaviation/internal/database"
 	"github.com/alisaviation/internal/gophermart/models"
+	"github.com/alisaviation/pkg/money"
 )
 
-func (p *PostgresStorage) CreateOrder(order *models.Order) error {
+func (p *PostgresStorage) CreateOrder(ctx context.Context, order *models.Order) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := p.begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
 	query := `INSERT INTO orders (user_id, number, status, accrual, uploaded_at) 
               VALUES ($1, $2, $3, $4, $5)`
-	_, err := p.db.Exec(query, order.UserID, order.Number, order.Status, order.Accrual, order.UploadedAt)
-	return err
+	_, err = tx.ExecContext(ctx, query, order.UserID, order.Number, order.Status, order.Accrual, order.UploadedAt)
+	if isUniqueViolation(err) {
+		return database.ErrOrderExists
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := insertOutboxEvent(ctx, tx, database.NewOrderUploadedEvent(*order)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// CreateOrders inserts the batch with its outbox events and looks up the
+// owners of the numbers that were already taken in a single statement. The
+// outer SELECT runs on the snapshot taken before the insert, so a number
+// inserted by this statement has no owner there, and one inserted
+// concurrently may have none either.
+func (p *PostgresStorage) CreateOrders(ctx context.Context, orders []models.Order) ([]database.OrderInsertResult, error) {
+	if len(orders) == 0 {
+		return nil, nil
+	}
+
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	// Arrays travel as text so that amounts and timestamps keep their
+	// precision.
+	var (
+		userIDs    = make([]int64, len(orders))
+		numbers    = make([]string, len(orders))
+		statuses   = make([]string, len(orders))
+		accruals   = make([]string, len(orders))
+		uploadedAt = make([]string, len(orders))
+		payloads   = make([]string, len(orders))
+	)
+	for i, order := range orders {
+		userIDs[i] = int64(order.UserID)
+		numbers[i] = order.Number
+		statuses[i] = order.Status
+		accruals[i] = order.Accrual.String()
+		uploadedAt[i] = order.UploadedAt.Format(time.RFC3339Nano)
+		payloads[i] = string(database.NewOrderUploadedEvent(order).Payload)
+	}
+
+	query := `
+        WITH input AS (
+            SELECT * FROM unnest($1::integer[], $2::text[], $3::text[], $4::numeric[], $5::timestamptz[], $6::jsonb[])
+                WITH ORDINALITY AS t(user_id, number, status, accrual, uploaded_at, payload, pos)
+        ), inserted AS (
+            INSERT INTO orders (user_id, number, status, accrual, uploaded_at)
+            SELECT user_id, number, status, accrual, uploaded_at FROM input
+            ON CONFLICT (number) DO NOTHING
+            RETURNING number
+        ), events AS (
+            INSERT INTO outbox_events (type, user_id, payload)
+            SELECT $7, input.user_id, input.payload
+            FROM input JOIN inserted ON inserted.number = input.number
+            ORDER BY input.pos
+        )
+        SELECT input.number, inserted.number IS NOT NULL, COALESCE(orders.user_id, 0)
+        FROM input
+        LEFT JOIN inserted ON inserted.number = input.number
+        LEFT JOIN orders ON orders.number = input.number
+        ORDER BY input.pos`
+
+	tx, err := p.begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// The statement inserts outbox events, see insertOutboxEvent.
+	if err := lockBalances(ctx, tx, userIDs); err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.QueryContext(ctx, query,
+		pq.Array(userIDs), pq.Array(numbers), pq.Array(statuses), pq.Array(accruals), pq.Array(uploadedAt),
+		pq.Array(payloads), database.EventOrderUploaded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert orders: %w", err)
+	}
+	defer rows.Close()
+
+	results := make([]database.OrderInsertResult, 0, len(orders))
+	for rows.Next() {
+		var result database.OrderInsertResult
+		if err := rows.Scan(&result.Number, &result.Inserted, &result.OwnerID); err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	return results, tx.Commit()
 }
 
-func (p *PostgresStorage) UpdateOrderFromAccrual(number string, status string, accrual float64) error {
+func (p *PostgresStorage) UpdateOrderFromAccrual(ctx context.Context, number string, status string, accrual money.Amount) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := p.begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var (
+		userID     int
+		oldStatus  string
+		oldAccrual money.Amount
+	)
+	err = tx.QueryRowContext(ctx,
+		`SELECT user_id, status, COALESCE(accrual, 0) FROM orders WHERE number = $1 FOR UPDATE`,
+		number,
+	).Scan(&userID, &oldStatus, &oldAccrual)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to lock order: %w", err)
+	}
+
+	// A status change means the order is moving, so its poll backoff starts
+	// over.
 	query := `
         UPDATE orders 
-        SET status = $1, accrual = $2 
+        SET status = $1, accrual = $2,
+            poll_attempts = CASE WHEN status = $1 THEN poll_attempts ELSE 0 END
         WHERE number = $3`
 
-	_, err := p.db.Exec(query, status, accrual, number)
-	return err
+	if _, err := tx.ExecContext(ctx, query, status, accrual, number); err != nil {
+		return fmt.Errorf("failed to update order: %w", err)
+	}
+
+	if status != oldStatus || accrual != oldAccrual {
+		if err := insertOutboxEvent(ctx, tx, database.NewOrderUpdatedEvent(userID, number, status, accrual)); err != nil {
+			return err
+		}
+	}
+
+	delta := processedAccrual(status, accrual) - processedAccrual(oldStatus, oldAccrual)
+	if delta != 0 {
+		if _, err := postEntry(ctx, tx, database.NewAccrualEntry(userID, number, delta, time.Now())); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
-func (p *PostgresStorage) GetOrderByNumber(number string) (*models.Order, error) {
+func processedAccrual(status string, accrual money.Amount) money.Amount {
+	if status == "PROCESSED" {
+		return accrual
+	}
+	return 0
+}
+
+func (p *PostgresStorage) GetOrderByNumber(ctx context.Context, number string) (*models.Order, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
 	var order models.Order
-	query := `SELECT id, user_id, number, status, uploaded_at FROM orders WHERE number = $1`
-	err := p.db.QueryRow(query, number).Scan(
+	query := `SELECT id, user_id, number, status, COALESCE(accrual, 0), uploaded_at FROM orders WHERE number = $1`
+	err := p.conn(ctx).QueryRowContext(ctx, query, number).Scan(
 		&order.ID,
 		&order.UserID,
 		&order.Number,
 		&order.Status,
+		&order.Accrual,
 		&order.UploadedAt,
 	)
 
@@ -45,14 +217,143 @@ func (p *PostgresStorage) GetOrderByNumber(number string) (*models.Order, error)
 	return &order, nil
 }
 
-func (p *PostgresStorage) GetOrdersByUser(userID int) ([]models.Order, error) {
+func (p *PostgresStorage) GetOrdersByUser(ctx context.Context, userID int) ([]models.Order, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
 	query := `
         SELECT id, user_id, number, status, accrual, uploaded_at 
         FROM orders 
         WHERE user_id = $1 
         ORDER BY uploaded_at DESC`
 
-	rows, err := p.db.Query(query, userID)
+	rows, err := p.conn(ctx).QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orders []models.Order
+	for rows.Next() {
+		var order models.Order
+		err := rows.Scan(
+			&order.ID,
+			&order.UserID,
+			&order.Number,
+			&order.Status,
+			&order.Accrual,
+			&order.UploadedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, order)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return orders, nil
+}
+
+func (p *PostgresStorage) QueryOrders(ctx context.Context, q database.OrderQuery) ([]models.Order, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	args := []any{q.UserID}
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	conds := []string{"user_id = $1"}
+	if len(q.Statuses) > 0 {
+		conds = append(conds, "status = ANY("+arg(pq.Array(q.Statuses))+")")
+	}
+	if !q.From.IsZero() {
+		conds = append(conds, "uploaded_at >= "+arg(q.From))
+	}
+	if !q.To.IsZero() {
+		conds = append(conds, "uploaded_at < "+arg(q.To))
+	}
+
+	direction, cmp := "DESC", "<"
+	if q.Ascending {
+		direction, cmp = "ASC", ">"
+	}
+	if q.After != nil {
+		conds = append(conds, fmt.Sprintf("(uploaded_at, id) %s (%s, %s)", cmp, arg(q.After.UploadedAt), arg(q.After.ID)))
+	}
+
+	query := `
+        SELECT id, user_id, number, status, accrual, uploaded_at
+        FROM orders
+        WHERE ` + strings.Join(conds, " AND ") + `
+        ORDER BY uploaded_at ` + direction + `, id ` + direction
+	if q.Limit > 0 {
+		query += " LIMIT " + arg(q.Limit)
+	}
+
+	rows, err := p.conn(ctx).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orders []models.Order
+	for rows.Next() {
+		var order models.Order
+		err := rows.Scan(
+			&order.ID,
+			&order.UserID,
+			&order.Number,
+			&order.Status,
+			&order.Accrual,
+			&order.UploadedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, order)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return orders, nil
+}
+
+func (p *PostgresStorage) GetPendingOrders(ctx context.Context, limit int) ([]models.Order, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	// A never polled order is due from its upload time. SKIP LOCKED lets
+	// replicas claim disjoint batches.
+	query := `
+        WITH due AS (
+            SELECT id, COALESCE(next_poll_at, uploaded_at) AS due_at
+            FROM orders
+            WHERE status IN ('NEW', 'PROCESSING')
+              AND COALESCE(next_poll_at, uploaded_at) <= NOW()
+            ORDER BY COALESCE(next_poll_at, uploaded_at), id
+            LIMIT $1
+            FOR UPDATE SKIP LOCKED
+        ), claimed AS (
+            UPDATE orders o
+            SET next_poll_at = NOW() + LEAST($2 * POWER(2, LEAST(o.poll_attempts, 30)), $3) * INTERVAL '1 millisecond',
+                poll_attempts = o.poll_attempts + 1
+            FROM due
+            WHERE o.id = due.id
+            RETURNING o.id, o.user_id, o.number, o.status, COALESCE(o.accrual, 0) AS accrual, o.uploaded_at, due.due_at
+        )
+        SELECT id, user_id, number, status, accrual, uploaded_at
+        FROM claimed
+        ORDER BY due_at, id`
+
+	rows, err := p.conn(ctx).QueryContext(ctx, query, limit,
+		database.PollBackoffBase.Milliseconds(), database.PollBackoffMax.Milliseconds())
 	if err != nil {
 		return nil, err
 	}
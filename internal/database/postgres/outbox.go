@@ -0,0 +1,122 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/alisaviation/internal/database"
+	"github.com/alisaviation/internal/gophermart/models"
+)
+
+// insertOutboxEvent takes the user's balance lock before inserting, and
+// keeps it until tx ends. Events of one user are therefore committed in ID
+// order, which the relay relies on to deliver them in order: without the lock
+// a transaction could commit a higher ID while a lower one is still pending.
+func insertOutboxEvent(ctx context.Context, tx execQuerier, event models.OutboxEvent) error {
+	if _, err := lockBalance(ctx, tx, event.UserID); err != nil {
+		return err
+	}
+
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO outbox_events (type, user_id, payload, created_at, next_attempt_at)
+		VALUES ($1, $2, $3, $4, $5)`,
+		event.Type, event.UserID, []byte(event.Payload), event.CreatedAt, event.NextAttemptAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert outbox event: %w", err)
+	}
+	return nil
+}
+
+func (p *PostgresStorage) PendingOutboxEvents(ctx context.Context, limit int) ([]models.OutboxEvent, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+        SELECT id, type, user_id, payload, created_at, attempts, next_attempt_at, last_error
+        FROM outbox_events e
+        WHERE delivered_at IS NULL
+          AND NOT EXISTS (
+              SELECT 1 FROM outbox_events waiting
+              WHERE waiting.user_id = e.user_id
+                AND waiting.delivered_at IS NULL
+                AND waiting.id <= e.id
+                AND waiting.next_attempt_at > NOW())
+        ORDER BY id
+        LIMIT $1`
+
+	rows, err := p.conn(ctx).QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.OutboxEvent
+	for rows.Next() {
+		var (
+			event   models.OutboxEvent
+			payload []byte
+		)
+		err := rows.Scan(
+			&event.ID,
+			&event.Type,
+			&event.UserID,
+			&payload,
+			&event.CreatedAt,
+			&event.Attempts,
+			&event.NextAttemptAt,
+			&event.LastError,
+		)
+		if err != nil {
+			return nil, err
+		}
+		event.Payload = payload
+		events = append(events, event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+func (p *PostgresStorage) MarkOutboxEventDelivered(ctx context.Context, id int64) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	res, err := p.conn(ctx).ExecContext(ctx,
+		`UPDATE outbox_events SET delivered_at = NOW(), attempts = attempts + 1 WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
+}
+
+func (p *PostgresStorage) MarkOutboxEventFailed(ctx context.Context, id int64, nextAttemptAt time.Time, lastError string) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	res, err := p.conn(ctx).ExecContext(ctx, `
+		UPDATE outbox_events
+		SET attempts = attempts + 1, next_attempt_at = $2, last_error = $3
+		WHERE id = $1 AND delivered_at IS NULL`,
+		id, nextAttemptAt, lastError)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
+}
+
+func expectAffected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return database.ErrNotFound
+	}
+	return nil
+}
@@ -1,56 +1,34 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
-	"errors"
-	"fmt"
-	"path/filepath"
-	"runtime"
+	"time"
 
-	"github.com/golang-migrate/migrate/v4"
-	"github.com/golang-migrate/migrate/v4/database/postgres"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/alisaviation/internal/database"
 )
 
-var (
-	ErrNotFound = errors.New("entity not found")
-)
+// ErrNotFound is kept for callers that predate database.ErrNotFound.
+var ErrNotFound = database.ErrNotFound
 
 type PostgresStorage struct {
-	db *sql.DB
+	db           *sql.DB
+	queryTimeout time.Duration
 }
 
-func NewPostgresDatabase(db *sql.DB) (*PostgresStorage, error) {
-	storage := &PostgresStorage{db: db}
-	if err := storage.runMigrations(); err != nil {
-		return nil, fmt.Errorf("failed to run migrations: %w", err)
-	}
-	return storage, nil
+// NewPostgresDatabase bounds every storage call by queryTimeout on top of
+// the caller's context. A zero timeout leaves the caller's deadline alone.
+//
+// The schema is expected to be up to date; see Migrate.
+func NewPostgresDatabase(db *sql.DB, queryTimeout time.Duration) (*PostgresStorage, error) {
+	return &PostgresStorage{db: db, queryTimeout: queryTimeout}, nil
 }
-func (p *PostgresStorage) runMigrations() error {
-	_, filename, _, ok := runtime.Caller(0)
-	if !ok {
-		return fmt.Errorf("failed to get current file path")
-	}
-	migrationsPath := filepath.Join(filepath.Dir(filename), "migrations")
 
-	driver, err := postgres.WithInstance(p.db, &postgres.Config{})
-	if err != nil {
-		return fmt.Errorf("failed to create postgres driver: %w", err)
+func (p *PostgresStorage) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if p.queryTimeout <= 0 {
+		return context.WithCancel(ctx)
 	}
-
-	m, err := migrate.NewWithDatabaseInstance(
-		fmt.Sprintf("file://%s", migrationsPath),
-		"postgres", driver)
-	if err != nil {
-		return fmt.Errorf("failed to create migrate instance: %w", err)
-	}
-
-	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
-		return fmt.Errorf("failed to apply migrations: %w", err)
-	}
-
-	return nil
+	return context.WithTimeout(ctx, p.queryTimeout)
 }
 
 //
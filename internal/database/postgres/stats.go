@@ -0,0 +1,57 @@
+package postgres
+
+import (
+	"context"
+
+	"github.com/alisaviation/internal/database"
+	"github.com/alisaviation/pkg/money"
+)
+
+func (p *PostgresStorage) CountOrdersByStatus(ctx context.Context) (map[string]int, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := p.conn(ctx).QueryContext(ctx, `SELECT status, COUNT(*) FROM orders GROUP BY status`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var (
+			status string
+			n      int
+		)
+		if err := rows.Scan(&status, &n); err != nil {
+			return nil, err
+		}
+		counts[status] = n
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+func (p *PostgresStorage) WithdrawalTotals(ctx context.Context) (int, money.Amount, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	var (
+		count int
+		sum   money.Amount
+	)
+	err := p.conn(ctx).QueryRowContext(ctx, `
+		SELECT
+		    (SELECT COUNT(*)
+		     FROM journal_entries e
+		     WHERE e.kind = $1
+		       AND NOT EXISTS (SELECT 1 FROM journal_entries r WHERE r.reverses_entry_id = e.id)),
+		    (SELECT COALESCE(SUM(withdrawn), 0) FROM balances)`,
+		database.EntryWithdrawal,
+	).Scan(&count, &sum)
+	return count, sum, err
+}
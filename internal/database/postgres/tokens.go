@@ -0,0 +1,203 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/alisaviation/internal/database"
+	"github.com/alisaviation/internal/gophermart/models"
+)
+
+func (p *PostgresStorage) CreateSession(ctx context.Context, sessionID string, userID int) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	_, err := p.conn(ctx).ExecContext(ctx,
+		"INSERT INTO sessions (id, user_id) VALUES ($1, $2)",
+		sessionID, userID,
+	)
+	return err
+}
+
+func (p *PostgresStorage) RevokeSession(ctx context.Context, sessionID string) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	_, err := p.conn(ctx).ExecContext(ctx,
+		"UPDATE sessions SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL",
+		sessionID,
+	)
+	return err
+}
+
+func (p *PostgresStorage) CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	return insertRefreshToken(ctx, p.conn(ctx), token)
+}
+
+func insertRefreshToken(ctx context.Context, tx execQuerier, token *models.RefreshToken) error {
+	return tx.QueryRowContext(ctx, `
+		INSERT INTO refresh_tokens (session_id, user_id, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id`,
+		token.SessionID, token.UserID, token.TokenHash, token.ExpiresAt,
+	).Scan(&token.ID)
+}
+
+func (p *PostgresStorage) GetRefreshToken(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	var token models.RefreshToken
+	var usedAt sql.NullTime
+	err := p.conn(ctx).QueryRowContext(ctx, `
+		SELECT t.id, t.user_id, u.login, t.session_id, t.token_hash, t.expires_at, t.used_at,
+		       s.revoked_at IS NOT NULL
+		FROM refresh_tokens t
+		JOIN users u ON u.id = t.user_id
+		JOIN sessions s ON s.id = t.session_id
+		WHERE t.token_hash = $1`,
+		tokenHash,
+	).Scan(
+		&token.ID,
+		&token.UserID,
+		&token.Login,
+		&token.SessionID,
+		&token.TokenHash,
+		&token.ExpiresAt,
+		&usedAt,
+		&token.SessionRevoked,
+	)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if usedAt.Valid {
+		token.UsedAt = &usedAt.Time
+	}
+	return &token, nil
+}
+
+func (p *PostgresStorage) RotateRefreshToken(ctx context.Context, oldHash string, next *models.RefreshToken) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := p.begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Lock the session so that a logout either lands before the rotation and
+	// is seen here, or waits and revokes the new token along with the session.
+	var revoked bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT s.revoked_at IS NOT NULL
+		FROM refresh_tokens t
+		JOIN sessions s ON s.id = t.session_id
+		WHERE t.token_hash = $1
+		FOR UPDATE OF s`,
+		oldHash,
+	).Scan(&revoked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.ErrRefreshTokenUsed
+	}
+	if err != nil {
+		return fmt.Errorf("failed to lock session: %w", err)
+	}
+	if revoked {
+		return database.ErrSessionRevoked
+	}
+
+	res, err := tx.ExecContext(ctx,
+		"UPDATE refresh_tokens SET used_at = NOW() WHERE token_hash = $1 AND used_at IS NULL",
+		oldHash,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to mark refresh token used: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to mark refresh token used: %w", err)
+	}
+	if affected == 0 {
+		return database.ErrRefreshTokenUsed
+	}
+
+	if err := insertRefreshToken(ctx, tx, next); err != nil {
+		return fmt.Errorf("failed to insert refresh token: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+func (p *PostgresStorage) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	_, err := p.conn(ctx).ExecContext(ctx, `
+		INSERT INTO revoked_tokens (jti, expires_at) VALUES ($1, $2)
+		ON CONFLICT (jti) DO NOTHING`,
+		jti, expiresAt,
+	)
+	return err
+}
+
+func (p *PostgresStorage) IsTokenRevoked(ctx context.Context, jti, sessionID string) (bool, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	var revoked bool
+	err := p.conn(ctx).QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1 AND jti <> '')
+		    OR ($2 <> '' AND NOT EXISTS (SELECT 1 FROM sessions WHERE id = $2 AND revoked_at IS NULL))`,
+		jti, sessionID,
+	).Scan(&revoked)
+	return revoked, err
+}
+
+func (p *PostgresStorage) PurgeExpiredTokens(ctx context.Context) (int64, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	// Sessions go last, and only once no refresh token refers to them. One
+	// revoked or left without tokens in between is picked up next time.
+	var purged int64
+	for _, q := range []struct {
+		query string
+		args  []any
+	}{
+		{query: `DELETE FROM refresh_tokens WHERE expires_at < NOW()`},
+		{query: `DELETE FROM revoked_tokens WHERE expires_at < NOW()`},
+		{query: `
+			DELETE FROM refresh_tokens t
+			USING sessions s
+			WHERE s.id = t.session_id AND s.revoked_at IS NOT NULL`},
+		{
+			query: `
+			DELETE FROM sessions s
+			WHERE (s.revoked_at IS NOT NULL OR s.created_at < NOW() - $1 * INTERVAL '1 millisecond')
+			  AND NOT EXISTS (SELECT 1 FROM refresh_tokens t WHERE t.session_id = s.id)`,
+			args: []any{database.SessionPurgeGrace.Milliseconds()},
+		},
+	} {
+		res, err := p.conn(ctx).ExecContext(ctx, q.query, q.args...)
+		if err != nil {
+			return purged, fmt.Errorf("failed to purge expired tokens: %w", err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return purged, fmt.Errorf("failed to purge expired tokens: %w", err)
+		}
+		purged += n
+	}
+	return purged, nil
+}
@@ -0,0 +1,72 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/alisaviation/internal/tracing"
+)
+
+// tracedConn starts a client span for every statement run through it. Both
+// conn and txHandle hand out statements this way, so repository code needs
+// no tracing calls of its own.
+type tracedConn struct {
+	dbtx
+}
+
+func (c tracedConn) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	ctx, span := startStatement(ctx, query)
+	defer span.End()
+
+	res, err := c.dbtx.ExecContext(ctx, query, args...)
+	tracing.RecordError(span, err)
+	return res, err
+}
+
+func (c tracedConn) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	ctx, span := startStatement(ctx, query)
+	defer span.End()
+
+	rows, err := c.dbtx.QueryContext(ctx, query, args...)
+	tracing.RecordError(span, err)
+	return rows, err
+}
+
+func (c tracedConn) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	ctx, span := startStatement(ctx, query)
+	defer span.End()
+
+	row := c.dbtx.QueryRowContext(ctx, query, args...)
+	tracing.RecordError(span, row.Err())
+	return row
+}
+
+// startStatement names the span after the SQL verb, which keeps span names
+// few while the full text goes into db.query.text. Arguments are left out
+// as they may hold credentials. Statements outside any span, such as the
+// polling of background workers, are not traced so they do not each start
+// a trace of their own.
+func startStatement(ctx context.Context, query string) (context.Context, trace.Span) {
+	parent := trace.SpanFromContext(ctx)
+	if !parent.SpanContext().IsValid() {
+		return ctx, parent
+	}
+
+	query = strings.TrimSpace(query)
+	operation := "SQL"
+	if fields := strings.Fields(query); len(fields) > 0 {
+		operation = strings.ToUpper(fields[0])
+	}
+
+	return tracing.Tracer().Start(ctx, operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemPostgreSQL,
+			semconv.DBOperationName(operation),
+			semconv.DBQueryText(query),
+		))
+}
@@ -0,0 +1,132 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/alisaviation/internal/database"
+)
+
+const (
+	defaultTxRetries = 3
+	txRetryBackoff   = 10 * time.Millisecond
+
+	serializationFailureCode = "40001"
+	deadlockDetectedCode     = "40P01"
+)
+
+type txKey struct {
+	storage *PostgresStorage
+}
+
+type dbtx interface {
+	execQuerier
+	querier
+}
+
+// conn returns the transaction carried by ctx, if any, so repository calls
+// made inside WithinTx join it.
+func (p *PostgresStorage) conn(ctx context.Context) dbtx {
+	if tx, ok := ctx.Value(txKey{p}).(*sql.Tx); ok {
+		return tracedConn{tx}
+	}
+	return tracedConn{p.db}
+}
+
+// txHandle lets multi-statement methods run standalone or inside a unit of
+// work without changing their bodies: a joined handle leaves commit and
+// rollback to the outer transaction.
+type txHandle struct {
+	*sql.Tx
+	joined bool
+}
+
+func (t *txHandle) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return tracedConn{t.Tx}.ExecContext(ctx, query, args...)
+}
+
+func (t *txHandle) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return tracedConn{t.Tx}.QueryContext(ctx, query, args...)
+}
+
+func (t *txHandle) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return tracedConn{t.Tx}.QueryRowContext(ctx, query, args...)
+}
+
+func (t *txHandle) Commit() error {
+	if t.joined {
+		return nil
+	}
+	return t.Tx.Commit()
+}
+
+func (t *txHandle) Rollback() error {
+	if t.joined {
+		return nil
+	}
+	return t.Tx.Rollback()
+}
+
+func (p *PostgresStorage) begin(ctx context.Context) (*txHandle, error) {
+	if tx, ok := ctx.Value(txKey{p}).(*sql.Tx); ok {
+		return &txHandle{Tx: tx, joined: true}, nil
+	}
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &txHandle{Tx: tx}, nil
+}
+
+func (p *PostgresStorage) WithinTx(ctx context.Context, opts database.TxOptions, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txKey{p}).(*sql.Tx); ok {
+		return fn(ctx)
+	}
+
+	retries := opts.MaxRetries
+	if retries <= 0 {
+		retries = defaultTxRetries
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := p.runTx(ctx, opts, fn)
+		if err == nil || !isRetryable(err) || attempt >= retries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(attempt+1) * txRetryBackoff):
+		}
+	}
+}
+
+func (p *PostgresStorage) runTx(ctx context.Context, opts database.TxOptions, fn func(ctx context.Context) error) error {
+	tx, err := p.db.BeginTx(ctx, &sql.TxOptions{Isolation: opts.Isolation, ReadOnly: opts.ReadOnly})
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(context.WithValue(ctx, txKey{p}, tx)); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func isRetryable(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	return pqErr.Code == serializationFailureCode || pqErr.Code == deadlockDetectedCode
+}
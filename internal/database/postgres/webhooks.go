@@ -0,0 +1,313 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/alisaviation/internal/database"
+	"github.com/alisaviation/internal/gophermart/models"
+)
+
+const webhookColumns = `id, user_id, url, secret, events, enabled, consecutive_failures, disabled_at, created_at`
+
+const deliveryColumns = `id, webhook_id, event_id, event_type, payload, status, attempts, next_attempt_at,
+	last_attempt_at, response_code, last_error, created_at, delivered_at`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanWebhook(row rowScanner) (*models.Webhook, error) {
+	var (
+		webhook    models.Webhook
+		disabledAt sql.NullTime
+	)
+	err := row.Scan(
+		&webhook.ID,
+		&webhook.UserID,
+		&webhook.URL,
+		&webhook.Secret,
+		pq.Array(&webhook.Events),
+		&webhook.Enabled,
+		&webhook.ConsecutiveFailures,
+		&disabledAt,
+		&webhook.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if disabledAt.Valid {
+		webhook.DisabledAt = &disabledAt.Time
+	}
+	return &webhook, nil
+}
+
+func scanDelivery(row rowScanner) (*models.WebhookDelivery, error) {
+	var (
+		delivery      models.WebhookDelivery
+		payload       []byte
+		lastAttemptAt sql.NullTime
+		deliveredAt   sql.NullTime
+	)
+	err := row.Scan(
+		&delivery.ID,
+		&delivery.WebhookID,
+		&delivery.EventID,
+		&delivery.EventType,
+		&payload,
+		&delivery.Status,
+		&delivery.Attempts,
+		&delivery.NextAttemptAt,
+		&lastAttemptAt,
+		&delivery.ResponseCode,
+		&delivery.LastError,
+		&delivery.CreatedAt,
+		&deliveredAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	delivery.Payload = payload
+	if lastAttemptAt.Valid {
+		delivery.LastAttemptAt = &lastAttemptAt.Time
+	}
+	if deliveredAt.Valid {
+		delivery.DeliveredAt = &deliveredAt.Time
+	}
+	return &delivery, nil
+}
+
+func (p *PostgresStorage) CreateWebhook(ctx context.Context, webhook *models.Webhook) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	return p.conn(ctx).QueryRowContext(ctx, `
+		INSERT INTO webhooks (user_id, url, secret, events, enabled)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`,
+		webhook.UserID, webhook.URL, webhook.Secret, pq.Array(nonNil(webhook.Events)), webhook.Enabled,
+	).Scan(&webhook.ID, &webhook.CreatedAt)
+}
+
+func (p *PostgresStorage) GetWebhook(ctx context.Context, id int64) (*models.Webhook, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	webhook, err := scanWebhook(p.conn(ctx).QueryRowContext(ctx,
+		`SELECT `+webhookColumns+` FROM webhooks WHERE id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, database.ErrNotFound
+	}
+	return webhook, err
+}
+
+func (p *PostgresStorage) GetWebhooksByUser(ctx context.Context, userID int) ([]models.Webhook, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := p.conn(ctx).QueryContext(ctx,
+		`SELECT `+webhookColumns+` FROM webhooks WHERE user_id = $1 ORDER BY id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var webhooks []models.Webhook
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, *webhook)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+func (p *PostgresStorage) UpdateWebhook(ctx context.Context, webhook *models.Webhook) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	res, err := p.conn(ctx).ExecContext(ctx, `
+		UPDATE webhooks
+		SET url = $2, secret = $3, events = $4, enabled = $5, consecutive_failures = $6, disabled_at = $7
+		WHERE id = $1`,
+		webhook.ID, webhook.URL, webhook.Secret, pq.Array(nonNil(webhook.Events)), webhook.Enabled,
+		webhook.ConsecutiveFailures, webhook.DisabledAt,
+	)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
+}
+
+func (p *PostgresStorage) DeleteWebhook(ctx context.Context, id int64) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	res, err := p.conn(ctx).ExecContext(ctx, `DELETE FROM webhooks WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
+}
+
+func (p *PostgresStorage) EnqueueWebhookDeliveries(ctx context.Context, userID int, delivery models.WebhookDelivery) (int, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	res, err := p.conn(ctx).ExecContext(ctx, `
+		INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, payload)
+		SELECT id, $2, $3, $4
+		FROM webhooks
+		WHERE user_id = $1 AND enabled AND (cardinality(events) = 0 OR $3 = ANY(events))
+		ON CONFLICT (webhook_id, event_id) DO NOTHING`,
+		userID, delivery.EventID, delivery.EventType, []byte(delivery.Payload),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to enqueue webhook deliveries: %w", err)
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (p *PostgresStorage) PendingWebhookDeliveries(ctx context.Context, limit int) ([]models.WebhookDelivery, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+        SELECT d.` + deliveryColumns + `
+        FROM webhook_deliveries d
+        JOIN webhooks w ON w.id = d.webhook_id
+        WHERE d.status = 'pending' AND d.next_attempt_at <= NOW() AND w.enabled
+        ORDER BY d.id
+        LIMIT $1`
+	return p.queryDeliveries(ctx, query, limit)
+}
+
+func (p *PostgresStorage) GetWebhookDeliveries(ctx context.Context, webhookID int64, limit int) ([]models.WebhookDelivery, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+        SELECT ` + deliveryColumns + `
+        FROM webhook_deliveries
+        WHERE webhook_id = $1
+        ORDER BY id DESC
+        LIMIT $2`
+	return p.queryDeliveries(ctx, query, webhookID, limit)
+}
+
+func (p *PostgresStorage) queryDeliveries(ctx context.Context, query string, args ...any) ([]models.WebhookDelivery, error) {
+	rows, err := p.conn(ctx).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []models.WebhookDelivery
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, *delivery)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+func (p *PostgresStorage) RecordWebhookAttempt(ctx context.Context, attempt models.WebhookAttempt, disableAfter int) (bool, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := p.begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	status := database.WebhookDeliveryPending
+	var deliveredAt sql.NullTime
+	switch {
+	case attempt.Succeeded:
+		status = database.WebhookDeliverySucceeded
+		deliveredAt = nullTime(attempt.AttemptedAt)
+	case attempt.NextAttemptAt.IsZero():
+		status = database.WebhookDeliveryFailed
+	}
+
+	var webhookID int64
+	err = tx.QueryRowContext(ctx, `
+		UPDATE webhook_deliveries
+		SET status = $2, attempts = attempts + 1, last_attempt_at = $3, response_code = $4,
+		    last_error = $5, next_attempt_at = COALESCE($6, next_attempt_at), delivered_at = $7
+		WHERE id = $1 AND status = 'pending'
+		RETURNING webhook_id`,
+		attempt.DeliveryID, status, attempt.AttemptedAt, attempt.ResponseCode, attempt.Error,
+		nullTime(attempt.NextAttemptAt), deliveredAt,
+	).Scan(&webhookID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, database.ErrNotFound
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if attempt.Succeeded {
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE webhooks SET consecutive_failures = 0 WHERE id = $1`, webhookID); err != nil {
+			return false, err
+		}
+		return false, tx.Commit()
+	}
+
+	var (
+		failures int
+		enabled  bool
+	)
+	err = tx.QueryRowContext(ctx, `
+		UPDATE webhooks SET consecutive_failures = consecutive_failures + 1
+		WHERE id = $1
+		RETURNING consecutive_failures, enabled`,
+		webhookID,
+	).Scan(&failures, &enabled)
+	if err != nil {
+		return false, err
+	}
+
+	disable := enabled && disableAfter > 0 && failures >= disableAfter
+	if disable {
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE webhooks SET enabled = FALSE, disabled_at = $2 WHERE id = $1`,
+			webhookID, attempt.AttemptedAt); err != nil {
+			return false, err
+		}
+	}
+	return disable, tx.Commit()
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+// nonNil keeps an empty list from being stored as NULL.
+func nonNil(items []string) []string {
+	if items == nil {
+		return []string{}
+	}
+	return items
+}
@@ -1,30 +1,104 @@
 package database
 
 import (
+	"context"
+	"errors"
+	"time"
+
 	"github.com/alisaviation/internal/gophermart/models"
+	"github.com/alisaviation/pkg/money"
+)
+
+var (
+	ErrNotFound          = errors.New("entity not found")
+	ErrUserExists        = errors.New("user already exists")
+	ErrOrderExists       = errors.New("order already exists")
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	ErrWithdrawalExists  = errors.New("withdrawal already exists")
+	ErrInvalidSum        = errors.New("withdrawal sum must be positive")
+	ErrRefreshTokenUsed  = errors.New("refresh token already used")
+	ErrSessionRevoked    = errors.New("session revoked")
 )
 
 type Storage interface {
 	User
 	Order
 	Balance
+	Ledger
+	Idempotency
+	Token
+	Outbox
+	Webhooks
+	Transactor
 }
 
 type User interface {
-	CreateUser(user models.User) (int, error)
-	GetUserByLogin(login string) (*models.User, error)
+	CreateUser(ctx context.Context, user models.User) (int, error)
+	GetUserByLogin(ctx context.Context, login string) (*models.User, error)
 }
 
 type Order interface {
-	CreateOrder(order *models.Order) error
-	GetOrderByNumber(number string) (*models.Order, error)
-	GetOrdersByUser(userID int) ([]models.Order, error)
-	UpdateOrderFromAccrual(number string, status string, accrual float64) error
+	CreateOrder(ctx context.Context, order *models.Order) error
+	// CreateOrders inserts the orders that are not taken yet and reports the
+	// outcome for each of them, in input order. Numbers must be unique.
+	CreateOrders(ctx context.Context, orders []models.Order) ([]OrderInsertResult, error)
+	GetOrderByNumber(ctx context.Context, number string) (*models.Order, error)
+	GetOrdersByUser(ctx context.Context, userID int) ([]models.Order, error)
+	QueryOrders(ctx context.Context, query OrderQuery) ([]models.Order, error)
+	// GetPendingOrders claims up to limit NEW or PROCESSING orders that are
+	// due for a poll, earliest first, and schedules the next poll of each
+	// with PollBackoff. Orders the accrual system never settles thus fall
+	// behind instead of starving newer ones.
+	GetPendingOrders(ctx context.Context, limit int) ([]models.Order, error)
+	UpdateOrderFromAccrual(ctx context.Context, number string, status string, accrual money.Amount) error
 }
 
 type Balance interface {
-	GetBalance(userID int) (*models.Balance, error)
-	CreateWithdrawal(withdrawal *models.Withdrawal) error
-	WithdrawalExists(orderNumber string) (bool, error)
-	GetWithdrawals(userID int) ([]models.Withdrawal, error)
+	GetBalance(ctx context.Context, userID int) (*models.Balance, error)
+	Withdraw(ctx context.Context, withdrawal *models.Withdrawal) error
+	WithdrawalExists(ctx context.Context, orderNumber string) (bool, error)
+	GetWithdrawals(ctx context.Context, userID int) ([]models.Withdrawal, error)
+}
+
+type Idempotency interface {
+	// ReserveIdempotencyKey claims the key for record, or returns the record
+	// already holding it. Expired keys are claimed anew, and so are
+	// unfinished ones past their LockedUntil when the request hash matches.
+	ReserveIdempotencyKey(ctx context.Context, record *models.IdempotencyRecord) (*models.IdempotencyRecord, bool, error)
+	CompleteIdempotencyKey(ctx context.Context, record *models.IdempotencyRecord) error
+	ReleaseIdempotencyKey(ctx context.Context, userID int, key string) error
+	// PurgeExpiredIdempotencyKeys deletes expired keys and reports how many.
+	PurgeExpiredIdempotencyKeys(ctx context.Context) (int64, error)
+}
+
+type Token interface {
+	CreateSession(ctx context.Context, sessionID string, userID int) error
+	RevokeSession(ctx context.Context, sessionID string) error
+	CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error
+	GetRefreshToken(ctx context.Context, tokenHash string) (*models.RefreshToken, error)
+	RotateRefreshToken(ctx context.Context, oldHash string, next *models.RefreshToken) error
+	RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error
+	IsTokenRevoked(ctx context.Context, jti, sessionID string) (bool, error)
+	// PurgeExpiredTokens deletes expired refresh tokens and revoked access
+	// token IDs, along with sessions that are revoked or have no unexpired
+	// refresh token left, and reports how many. Access tokens of a deleted
+	// session count as revoked.
+	PurgeExpiredTokens(ctx context.Context) (int64, error)
+}
+
+// SessionPurgeGrace spares a session without refresh tokens for a while
+// after it is created, so that login has time to issue the first one. It is
+// a variable so that tests can shorten it.
+var SessionPurgeGrace = time.Minute
+
+type BalanceReconciler interface {
+	ReconcileBalances(ctx context.Context) ([]models.BalanceMismatch, error)
+}
+
+// Stats reports aggregate figures for monitoring.
+type Stats interface {
+	CountOrdersByStatus(ctx context.Context) (map[string]int, error)
+	// WithdrawalTotals returns how many withdrawals were made and how many
+	// points they took, leaving out reversed ones.
+	WithdrawalTotals(ctx context.Context) (int, money.Amount, error)
 }
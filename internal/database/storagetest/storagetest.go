@@ -0,0 +1,1115 @@
+// Package storagetest is a conformance suite for database.Storage
+// implementations. Every backend runs it against itself so that services
+// can rely on the same contracts regardless of where data is kept.
+//
+// The suite only creates rows with unique names, so it is safe to run
+// against a shared database that already holds data.
+package storagetest
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/alisaviation/internal/database"
+	"github.com/alisaviation/internal/gophermart/models"
+	"github.com/alisaviation/pkg/money"
+)
+
+// Factory may return the same instance for every call.
+type Factory func(t *testing.T) database.Storage
+
+var sequence atomic.Int64
+
+// unique values do not clash with earlier runs against the same database.
+func unique(prefix string) string {
+	return fmt.Sprintf("%s-%d-%d", prefix, time.Now().UnixNano(), sequence.Add(1))
+}
+
+func Run(t *testing.T, newStorage Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s database.Storage)
+	}{
+		{"Users", testUsers},
+		{"Orders", testOrders},
+		{"PendingOrders", testPendingOrders},
+		{"PendingOrdersBackoff", testPendingOrdersBackoff},
+		{"OrderHistory", testOrderHistory},
+		{"BatchOrders", testBatchOrders},
+		{"Accruals", testAccruals},
+		{"Withdrawals", testWithdrawals},
+		{"Ledger", testLedger},
+		{"Idempotency", testIdempotency},
+		{"Tokens", testTokens},
+		{"TokenPurge", testTokenPurge},
+		{"Transactions", testTransactions},
+		{"Outbox", testOutbox},
+		{"Webhooks", testWebhooks},
+		{"Stats", testStats},
+		{"ConcurrentWithdrawals", testConcurrentWithdrawals},
+		{"ConcurrentRegistration", testConcurrentRegistration},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newStorage(t))
+		})
+	}
+}
+
+func createUser(t *testing.T, s database.Storage) (int, string) {
+	t.Helper()
+
+	login := unique("user")
+	id, err := s.CreateUser(t.Context(), models.User{Login: login, PasswordHash: "hash"})
+	require.NoError(t, err)
+	require.NotZero(t, id)
+	return id, login
+}
+
+func createOrder(t *testing.T, s database.Storage, userID int, status string, uploadedAt time.Time) string {
+	t.Helper()
+
+	number := unique("order")
+	require.NoError(t, s.CreateOrder(t.Context(), &models.Order{
+		UserID:     userID,
+		Number:     number,
+		Status:     status,
+		UploadedAt: uploadedAt,
+	}))
+	return number
+}
+
+// A processed order is the only way points enter the system.
+func fund(t *testing.T, s database.Storage, userID int, amount money.Amount) {
+	t.Helper()
+
+	number := createOrder(t, s, userID, "NEW", time.Now())
+	require.NoError(t, s.UpdateOrderFromAccrual(t.Context(), number, "PROCESSED", amount))
+}
+
+func testUsers(t *testing.T, s database.Storage) {
+	id, login := createUser(t, s)
+
+	user, err := s.GetUserByLogin(t.Context(), login)
+	require.NoError(t, err)
+	require.NotNil(t, user)
+	assert.Equal(t, id, user.ID)
+	assert.Equal(t, login, user.Login)
+	assert.Equal(t, "hash", user.PasswordHash)
+
+	_, err = s.CreateUser(t.Context(), models.User{Login: login, PasswordHash: "other"})
+	assert.ErrorIs(t, err, database.ErrUserExists)
+
+	missing, err := s.GetUserByLogin(t.Context(), unique("missing"))
+	assert.NoError(t, err, "a missing user is not an error")
+	assert.Nil(t, missing)
+}
+
+func testOrders(t *testing.T, s database.Storage) {
+	userID, _ := createUser(t, s)
+	otherID, _ := createUser(t, s)
+
+	_, err := s.GetOrderByNumber(t.Context(), unique("missing"))
+	assert.ErrorIs(t, err, database.ErrNotFound)
+
+	base := time.Now().Add(-time.Hour)
+	first := createOrder(t, s, userID, "NEW", base)
+	second := createOrder(t, s, userID, "NEW", base.Add(time.Minute))
+	third := createOrder(t, s, userID, "NEW", base.Add(2*time.Minute))
+	createOrder(t, s, otherID, "NEW", base)
+
+	err = s.CreateOrder(t.Context(), &models.Order{UserID: otherID, Number: first, Status: "NEW", UploadedAt: base})
+	assert.ErrorIs(t, err, database.ErrOrderExists)
+
+	order, err := s.GetOrderByNumber(t.Context(), first)
+	require.NoError(t, err)
+	assert.Equal(t, userID, order.UserID, "a conflicting insert must not change the owner")
+	assert.Equal(t, "NEW", order.Status)
+	assert.WithinDuration(t, base, order.UploadedAt, time.Millisecond)
+
+	orders, err := s.GetOrdersByUser(t.Context(), userID)
+	require.NoError(t, err)
+	require.Len(t, orders, 3)
+	assert.Equal(t, []string{third, second, first}, orderNumbers(orders), "newest first")
+
+	none, err := s.GetOrdersByUser(t.Context(), -1)
+	require.NoError(t, err)
+	assert.Empty(t, none)
+}
+
+func testOrderHistory(t *testing.T, s database.Storage) {
+	userID, _ := createUser(t, s)
+	otherID, _ := createUser(t, s)
+
+	base := time.Now().Add(-time.Hour).Truncate(time.Second)
+	first := createOrder(t, s, userID, "NEW", base)
+	// second and third share an upload time, so pages must break the tie.
+	second := createOrder(t, s, userID, "PROCESSED", base.Add(time.Minute))
+	third := createOrder(t, s, userID, "INVALID", base.Add(time.Minute))
+	fourth := createOrder(t, s, userID, "NEW", base.Add(2*time.Minute))
+	createOrder(t, s, otherID, "NEW", base)
+
+	all, err := s.QueryOrders(t.Context(), database.OrderQuery{UserID: userID})
+	require.NoError(t, err)
+	require.Len(t, all, 4)
+	assert.Equal(t, fourth, all[0].Number, "newest first")
+	assert.Equal(t, first, all[3].Number)
+	assert.ElementsMatch(t, []string{second, third}, orderNumbers(all[1:3]))
+
+	page := func(q database.OrderQuery) []string {
+		t.Helper()
+
+		var numbers []string
+		q.Limit = 1
+		for {
+			orders, err := s.QueryOrders(t.Context(), q)
+			require.NoError(t, err)
+			if len(orders) == 0 {
+				return numbers
+			}
+			require.Len(t, orders, 1)
+			numbers = append(numbers, orders[0].Number)
+			q.After = database.CursorOf(orders[0])
+		}
+	}
+	assert.Equal(t, orderNumbers(all), page(database.OrderQuery{UserID: userID}))
+	assert.Equal(t, reversed(orderNumbers(all)), page(database.OrderQuery{UserID: userID, Ascending: true}))
+
+	filtered, err := s.QueryOrders(t.Context(), database.OrderQuery{UserID: userID, Statuses: []string{"NEW", "INVALID"}})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{first, third, fourth}, orderNumbers(filtered))
+
+	ranged, err := s.QueryOrders(t.Context(), database.OrderQuery{
+		UserID: userID,
+		From:   base.Add(time.Minute),
+		To:     base.Add(2 * time.Minute),
+	})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{second, third}, orderNumbers(ranged), "from is inclusive, to is exclusive")
+}
+
+func testBatchOrders(t *testing.T, s database.Storage) {
+	userID, _ := createUser(t, s)
+	otherID, _ := createUser(t, s)
+	mine := createOrder(t, s, userID, "NEW", time.Now())
+	theirs := createOrder(t, s, otherID, "NEW", time.Now())
+
+	fresh := unique("order")
+	uploadedAt := time.Now().Truncate(time.Microsecond)
+	batch := []models.Order{
+		{UserID: userID, Number: theirs, Status: "NEW", UploadedAt: uploadedAt},
+		{UserID: userID, Number: fresh, Status: "NEW", UploadedAt: uploadedAt},
+		{UserID: userID, Number: mine, Status: "NEW", UploadedAt: uploadedAt},
+	}
+	results, err := s.CreateOrders(t.Context(), batch)
+	require.NoError(t, err)
+	assert.Equal(t, []database.OrderInsertResult{
+		{Number: theirs, OwnerID: otherID},
+		{Number: fresh, Inserted: true},
+		{Number: mine, OwnerID: userID},
+	}, results)
+
+	order, err := s.GetOrderByNumber(t.Context(), fresh)
+	require.NoError(t, err)
+	assert.Equal(t, userID, order.UserID)
+	assert.Equal(t, "NEW", order.Status)
+	assert.True(t, uploadedAt.Equal(order.UploadedAt))
+
+	order, err = s.GetOrderByNumber(t.Context(), theirs)
+	require.NoError(t, err)
+	assert.Equal(t, otherID, order.UserID, "a batch must not take over another user's order")
+
+	results, err = s.CreateOrders(t.Context(), nil)
+	require.NoError(t, err)
+	assert.Empty(t, results)
+}
+
+func testPendingOrders(t *testing.T, s database.Storage) {
+	userID, _ := createUser(t, s)
+
+	// Far in the past so these are due ahead of anything else in a shared
+	// database.
+	base := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(sequence.Add(1)) * time.Hour)
+	processing := createOrder(t, s, userID, "PROCESSING", base.Add(time.Minute))
+	fresh := createOrder(t, s, userID, "NEW", base)
+	createOrder(t, s, userID, "INVALID", base)
+	processed := createOrder(t, s, userID, "NEW", base)
+	require.NoError(t, s.UpdateOrderFromAccrual(t.Context(), processed, "PROCESSED", money.FromFloat(1)))
+	future := createOrder(t, s, userID, "NEW", time.Now().Add(time.Hour))
+
+	pending, err := s.GetPendingOrders(t.Context(), 1000)
+	require.NoError(t, err)
+
+	var ours []string
+	for _, order := range pending {
+		assert.Contains(t, []string{"NEW", "PROCESSING"}, order.Status)
+		if order.UserID == userID {
+			ours = append(ours, order.Number)
+		}
+	}
+	assert.Equal(t, []string{fresh, processing}, ours, "due orders, earliest first")
+	assert.NotContains(t, ours, future, "orders are not due before they are uploaded")
+
+	pending, err = s.GetPendingOrders(t.Context(), 1000)
+	require.NoError(t, err)
+	for _, order := range pending {
+		assert.NotEqual(t, userID, order.UserID, "a polled order waits out its backoff")
+	}
+}
+
+// testPendingOrdersBackoff checks that orders the accrual system never
+// settles do not keep newer orders from being polled.
+func testPendingOrdersBackoff(t *testing.T, s database.Storage) {
+	userID, _ := createUser(t, s)
+
+	base := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(sequence.Add(1)) * time.Hour)
+	stuck := []string{
+		createOrder(t, s, userID, "NEW", base),
+		createOrder(t, s, userID, "NEW", base.Add(time.Second)),
+	}
+
+	pending, err := s.GetPendingOrders(t.Context(), 1000)
+	require.NoError(t, err)
+	var polled []string
+	for _, order := range pending {
+		if order.UserID == userID {
+			polled = append(polled, order.Number)
+		}
+	}
+	require.Equal(t, stuck, polled)
+
+	newer := createOrder(t, s, userID, "NEW", base.Add(time.Minute))
+	pending, err = s.GetPendingOrders(t.Context(), 1)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, newer, pending[0].Number, "stuck orders must not block newer ones")
+
+	// A status change restarts the backoff but keeps the scheduled poll.
+	require.NoError(t, s.UpdateOrderFromAccrual(t.Context(), stuck[0], "PROCESSING", 0))
+	pending, err = s.GetPendingOrders(t.Context(), 1000)
+	require.NoError(t, err)
+	for _, order := range pending {
+		assert.NotEqual(t, userID, order.UserID)
+	}
+}
+
+func testAccruals(t *testing.T, s database.Storage) {
+	userID, _ := createUser(t, s)
+
+	err := s.UpdateOrderFromAccrual(t.Context(), unique("missing"), "PROCESSED", money.FromFloat(1))
+	assert.ErrorIs(t, err, database.ErrNotFound)
+
+	number := createOrder(t, s, userID, "NEW", time.Now())
+	require.NoError(t, s.UpdateOrderFromAccrual(t.Context(), number, "PROCESSING", 0))
+	require.NoError(t, s.UpdateOrderFromAccrual(t.Context(), number, "PROCESSED", money.FromFloat(729.98)))
+	require.NoError(t, s.UpdateOrderFromAccrual(t.Context(), number, "PROCESSED", money.FromFloat(729.98)))
+
+	order, err := s.GetOrderByNumber(t.Context(), number)
+	require.NoError(t, err)
+	assert.Equal(t, "PROCESSED", order.Status)
+
+	orders, err := s.GetOrdersByUser(t.Context(), userID)
+	require.NoError(t, err)
+	require.Len(t, orders, 1)
+	assert.Equal(t, money.FromFloat(729.98), orders[0].Accrual)
+
+	balance, err := s.GetBalance(t.Context(), userID)
+	require.NoError(t, err)
+	assert.Equal(t, money.FromFloat(729.98), balance.Current, "repeated updates must not credit twice")
+	assert.Equal(t, money.Amount(0), balance.Withdrawn)
+
+	// A corrected accrual only posts the difference.
+	require.NoError(t, s.UpdateOrderFromAccrual(t.Context(), number, "PROCESSED", money.FromFloat(700)))
+	balance, err = s.GetBalance(t.Context(), userID)
+	require.NoError(t, err)
+	assert.Equal(t, money.FromFloat(700), balance.Current)
+
+	empty, err := s.GetBalance(t.Context(), -1)
+	require.NoError(t, err)
+	assert.Equal(t, money.Amount(0), empty.Current)
+	assert.Equal(t, money.Amount(0), empty.Withdrawn)
+}
+
+func testWithdrawals(t *testing.T, s database.Storage) {
+	userID, _ := createUser(t, s)
+	fund(t, s, userID, money.FromFloat(100))
+
+	withdrawal := func(number string, sum money.Amount, at time.Time) *models.Withdrawal {
+		return &models.Withdrawal{UserID: userID, OrderNumber: number, Sum: sum, ProcessedAt: at}
+	}
+
+	first := unique("withdrawal")
+	exists, err := s.WithdrawalExists(t.Context(), first)
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	err = s.Withdraw(t.Context(), withdrawal(first, money.FromFloat(100.01), time.Now()))
+	assert.ErrorIs(t, err, database.ErrInsufficientFunds)
+
+	for _, sum := range []money.Amount{0, money.FromFloat(-1000)} {
+		err = s.Withdraw(t.Context(), withdrawal(unique("withdrawal"), sum, time.Now()))
+		assert.ErrorIs(t, err, database.ErrInvalidSum)
+	}
+
+	base := time.Now().Add(-time.Minute)
+	w := withdrawal(first, money.FromFloat(0.1), base)
+	require.NoError(t, s.Withdraw(t.Context(), w))
+	assert.NotZero(t, w.ID)
+
+	err = s.Withdraw(t.Context(), withdrawal(first, money.FromFloat(1), time.Now()))
+	assert.ErrorIs(t, err, database.ErrWithdrawalExists)
+
+	second := unique("withdrawal")
+	require.NoError(t, s.Withdraw(t.Context(), withdrawal(second, money.FromFloat(0.2), base.Add(time.Second))))
+
+	exists, err = s.WithdrawalExists(t.Context(), first)
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	balance, err := s.GetBalance(t.Context(), userID)
+	require.NoError(t, err)
+	assert.Equal(t, money.FromFloat(99.7), balance.Current)
+	assert.Equal(t, money.FromFloat(0.3), balance.Withdrawn)
+
+	withdrawals, err := s.GetWithdrawals(t.Context(), userID)
+	require.NoError(t, err)
+	require.Len(t, withdrawals, 2)
+	assert.Equal(t, first, withdrawals[0].OrderNumber, "oldest first")
+	assert.Equal(t, money.FromFloat(0.1), withdrawals[0].Sum)
+	assert.Equal(t, second, withdrawals[1].OrderNumber)
+
+	err = s.Withdraw(t.Context(), withdrawal(unique("withdrawal"), money.FromFloat(500), time.Now()))
+	assert.ErrorIs(t, err, database.ErrInsufficientFunds)
+	err = s.Withdraw(t.Context(), withdrawal(second, money.FromFloat(1), time.Now()))
+	assert.ErrorIs(t, err, database.ErrWithdrawalExists)
+}
+
+func testLedger(t *testing.T, s database.Storage) {
+	userID, _ := createUser(t, s)
+	fund(t, s, userID, money.FromFloat(100))
+	require.NoError(t, s.Withdraw(t.Context(), &models.Withdrawal{
+		UserID: userID, OrderNumber: unique("withdrawal"), Sum: money.FromFloat(40), ProcessedAt: time.Now(),
+	}))
+
+	journal, err := s.GetJournal(t.Context(), userID)
+	require.NoError(t, err)
+	require.Len(t, journal, 2)
+	assert.Equal(t, database.EntryAccrual, journal[0].Kind)
+	assert.Equal(t, database.EntryWithdrawal, journal[1].Kind)
+	for _, entry := range journal {
+		assert.NoError(t, database.ValidateEntry(entry))
+	}
+
+	_, err = s.ReverseEntry(t.Context(), -1, "missing")
+	assert.ErrorIs(t, err, database.ErrNotFound)
+
+	reversal, err := s.ReverseEntry(t.Context(), journal[1].ID, "refund")
+	require.NoError(t, err)
+	require.NotNil(t, reversal.ReversesEntryID)
+	assert.Equal(t, journal[1].ID, *reversal.ReversesEntryID)
+
+	_, err = s.ReverseEntry(t.Context(), journal[1].ID, "twice")
+	assert.ErrorIs(t, err, database.ErrAlreadyReversed)
+
+	withdrawals, err := s.GetWithdrawals(t.Context(), userID)
+	require.NoError(t, err)
+	assert.Empty(t, withdrawals, "reversed withdrawals are hidden")
+
+	_, err = s.RecordAdjustment(t.Context(), userID, money.FromFloat(-100.01), "too much")
+	assert.ErrorIs(t, err, database.ErrInsufficientFunds)
+
+	_, err = s.RecordAdjustment(t.Context(), userID, money.FromFloat(-25.5), "correction")
+	require.NoError(t, err)
+
+	balance, err := s.GetBalance(t.Context(), userID)
+	require.NoError(t, err)
+	assert.Equal(t, money.FromFloat(74.5), balance.Current)
+	assert.Equal(t, money.Amount(0), balance.Withdrawn)
+
+	if reconciler, ok := s.(database.BalanceReconciler); ok {
+		mismatches, err := reconciler.ReconcileBalances(t.Context())
+		require.NoError(t, err)
+		for _, m := range mismatches {
+			assert.NotEqual(t, userID, m.UserID, "balance mismatch: %+v", m)
+		}
+	}
+}
+
+func testIdempotency(t *testing.T, s database.Storage) {
+	userID, _ := createUser(t, s)
+	key := unique("key")
+
+	record := &models.IdempotencyRecord{
+		UserID:      userID,
+		Key:         key,
+		RequestHash: "hash-1",
+		ExpiresAt:   time.Now().Add(time.Hour),
+		LockedUntil: time.Now().Add(time.Hour),
+	}
+	existing, reserved, err := s.ReserveIdempotencyKey(t.Context(), record)
+	require.NoError(t, err)
+	assert.True(t, reserved)
+	assert.Nil(t, existing)
+
+	existing, reserved, err = s.ReserveIdempotencyKey(t.Context(), record)
+	require.NoError(t, err)
+	assert.False(t, reserved)
+	require.NotNil(t, existing)
+	assert.False(t, existing.Completed)
+	assert.Equal(t, "hash-1", existing.RequestHash)
+
+	record.StatusCode = 202
+	record.Headers = map[string][]string{"Content-Type": {"text/plain"}}
+	record.Body = []byte("accepted")
+	require.NoError(t, s.CompleteIdempotencyKey(t.Context(), record))
+
+	existing, reserved, err = s.ReserveIdempotencyKey(t.Context(), record)
+	require.NoError(t, err)
+	assert.False(t, reserved)
+	assert.True(t, existing.Completed)
+	assert.Equal(t, 202, existing.StatusCode)
+	assert.Equal(t, []string{"text/plain"}, existing.Headers["Content-Type"])
+	assert.Equal(t, []byte("accepted"), existing.Body)
+
+	require.NoError(t, s.ReleaseIdempotencyKey(t.Context(), userID, key))
+	_, reserved, err = s.ReserveIdempotencyKey(t.Context(), record)
+	require.NoError(t, err)
+	assert.False(t, reserved, "completed keys are not released")
+
+	pending := &models.IdempotencyRecord{UserID: userID, Key: unique("key"), RequestHash: "h", ExpiresAt: time.Now().Add(time.Hour)}
+	_, reserved, err = s.ReserveIdempotencyKey(t.Context(), pending)
+	require.NoError(t, err)
+	require.True(t, reserved)
+	require.NoError(t, s.ReleaseIdempotencyKey(t.Context(), userID, pending.Key))
+	_, reserved, err = s.ReserveIdempotencyKey(t.Context(), pending)
+	require.NoError(t, err)
+	assert.True(t, reserved, "released keys can be reserved again")
+
+	expired := &models.IdempotencyRecord{
+		UserID: userID, Key: unique("key"), RequestHash: "h",
+		ExpiresAt: time.Now().Add(-time.Minute), LockedUntil: time.Now().Add(time.Hour),
+	}
+	_, reserved, err = s.ReserveIdempotencyKey(t.Context(), expired)
+	require.NoError(t, err)
+	require.True(t, reserved)
+	expired.ExpiresAt = time.Now().Add(time.Hour)
+	_, reserved, err = s.ReserveIdempotencyKey(t.Context(), expired)
+	require.NoError(t, err)
+	assert.True(t, reserved, "expired keys can be reserved again")
+
+	abandoned := &models.IdempotencyRecord{
+		UserID: userID, Key: unique("key"), RequestHash: "h",
+		ExpiresAt: time.Now().Add(time.Hour), LockedUntil: time.Now().Add(-time.Second),
+	}
+	_, reserved, err = s.ReserveIdempotencyKey(t.Context(), abandoned)
+	require.NoError(t, err)
+	require.True(t, reserved)
+	other := *abandoned
+	other.RequestHash = "other"
+	existing, reserved, err = s.ReserveIdempotencyKey(t.Context(), &other)
+	require.NoError(t, err)
+	assert.False(t, reserved, "an abandoned key is only taken over by the same request")
+	assert.Equal(t, "h", existing.RequestHash)
+	retry := *abandoned
+	retry.LockedUntil = time.Now().Add(time.Minute)
+	_, reserved, err = s.ReserveIdempotencyKey(t.Context(), &retry)
+	require.NoError(t, err)
+	assert.True(t, reserved, "a retry takes over an unfinished key past its lock")
+	_, reserved, err = s.ReserveIdempotencyKey(t.Context(), &retry)
+	require.NoError(t, err)
+	assert.False(t, reserved, "the new lock holds")
+
+	locked := &models.IdempotencyRecord{
+		UserID: userID, Key: unique("key"), RequestHash: "h",
+		ExpiresAt: time.Now().Add(time.Hour), LockedUntil: time.Now().Add(-time.Second),
+	}
+	_, reserved, err = s.ReserveIdempotencyKey(t.Context(), locked)
+	require.NoError(t, err)
+	require.True(t, reserved)
+	locked.StatusCode = 200
+	require.NoError(t, s.CompleteIdempotencyKey(t.Context(), locked))
+	existing, reserved, err = s.ReserveIdempotencyKey(t.Context(), locked)
+	require.NoError(t, err)
+	assert.False(t, reserved, "completed keys are never taken over")
+	assert.True(t, existing.Completed)
+
+	stale := &models.IdempotencyRecord{UserID: userID, Key: unique("key"), RequestHash: "h", ExpiresAt: time.Now().Add(-time.Minute)}
+	_, reserved, err = s.ReserveIdempotencyKey(t.Context(), stale)
+	require.NoError(t, err)
+	require.True(t, reserved)
+	purged, err := s.PurgeExpiredIdempotencyKeys(t.Context())
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, purged, int64(1))
+
+	_, reserved, err = s.ReserveIdempotencyKey(t.Context(), expired)
+	require.NoError(t, err)
+	assert.False(t, reserved, "live keys survive the purge")
+}
+
+func testTokens(t *testing.T, s database.Storage) {
+	userID, login := createUser(t, s)
+	sessionID := unique("session")
+	require.NoError(t, s.CreateSession(t.Context(), sessionID, userID))
+
+	missing, err := s.GetRefreshToken(t.Context(), unique("hash"))
+	assert.NoError(t, err)
+	assert.Nil(t, missing)
+
+	first := &models.RefreshToken{UserID: userID, SessionID: sessionID, TokenHash: unique("hash"), ExpiresAt: time.Now().Add(time.Hour)}
+	require.NoError(t, s.CreateRefreshToken(t.Context(), first))
+
+	stored, err := s.GetRefreshToken(t.Context(), first.TokenHash)
+	require.NoError(t, err)
+	require.NotNil(t, stored)
+	assert.Equal(t, login, stored.Login)
+	assert.Equal(t, sessionID, stored.SessionID)
+	assert.Nil(t, stored.UsedAt)
+	assert.False(t, stored.SessionRevoked)
+
+	second := &models.RefreshToken{UserID: userID, SessionID: sessionID, TokenHash: unique("hash"), ExpiresAt: time.Now().Add(time.Hour)}
+	require.NoError(t, s.RotateRefreshToken(t.Context(), first.TokenHash, second))
+
+	third := &models.RefreshToken{UserID: userID, SessionID: sessionID, TokenHash: unique("hash"), ExpiresAt: time.Now().Add(time.Hour)}
+	assert.ErrorIs(t, s.RotateRefreshToken(t.Context(), first.TokenHash, third), database.ErrRefreshTokenUsed)
+
+	stored, err = s.GetRefreshToken(t.Context(), first.TokenHash)
+	require.NoError(t, err)
+	assert.NotNil(t, stored.UsedAt)
+
+	revoked, err := s.IsTokenRevoked(t.Context(), unique("jti"), sessionID)
+	require.NoError(t, err)
+	assert.False(t, revoked)
+
+	jti := unique("jti")
+	require.NoError(t, s.RevokeAccessToken(t.Context(), jti, time.Now().Add(time.Hour)))
+	require.NoError(t, s.RevokeAccessToken(t.Context(), jti, time.Now().Add(time.Hour)))
+	revoked, err = s.IsTokenRevoked(t.Context(), jti, "")
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	// An empty jti is never revoked, even if one was stored.
+	require.NoError(t, s.RevokeAccessToken(t.Context(), "", time.Now().Add(time.Hour)))
+	revoked, err = s.IsTokenRevoked(t.Context(), "", "")
+	require.NoError(t, err)
+	assert.False(t, revoked)
+
+	require.NoError(t, s.RevokeSession(t.Context(), sessionID))
+	revoked, err = s.IsTokenRevoked(t.Context(), unique("jti"), sessionID)
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	stored, err = s.GetRefreshToken(t.Context(), second.TokenHash)
+	require.NoError(t, err)
+	assert.True(t, stored.SessionRevoked)
+
+	fourth := &models.RefreshToken{UserID: userID, SessionID: sessionID, TokenHash: unique("hash"), ExpiresAt: time.Now().Add(time.Hour)}
+	assert.ErrorIs(t, s.RotateRefreshToken(t.Context(), second.TokenHash, fourth), database.ErrSessionRevoked)
+	stored, err = s.GetRefreshToken(t.Context(), fourth.TokenHash)
+	require.NoError(t, err)
+	assert.Nil(t, stored, "a revoked session gets no new refresh token")
+}
+
+func testTokenPurge(t *testing.T, s database.Storage) {
+	userID, _ := createUser(t, s)
+	sessionID := unique("session")
+	require.NoError(t, s.CreateSession(t.Context(), sessionID, userID))
+
+	expired := &models.RefreshToken{UserID: userID, SessionID: sessionID, TokenHash: unique("hash"), ExpiresAt: time.Now().Add(-time.Minute)}
+	require.NoError(t, s.CreateRefreshToken(t.Context(), expired))
+	live := &models.RefreshToken{UserID: userID, SessionID: sessionID, TokenHash: unique("hash"), ExpiresAt: time.Now().Add(time.Hour)}
+	require.NoError(t, s.CreateRefreshToken(t.Context(), live))
+
+	expiredJTI, liveJTI := unique("jti"), unique("jti")
+	require.NoError(t, s.RevokeAccessToken(t.Context(), expiredJTI, time.Now().Add(-time.Minute)))
+	require.NoError(t, s.RevokeAccessToken(t.Context(), liveJTI, time.Now().Add(time.Hour)))
+
+	purged, err := s.PurgeExpiredTokens(t.Context())
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, purged, int64(2))
+
+	stored, err := s.GetRefreshToken(t.Context(), expired.TokenHash)
+	require.NoError(t, err)
+	assert.Nil(t, stored)
+	stored, err = s.GetRefreshToken(t.Context(), live.TokenHash)
+	require.NoError(t, err)
+	assert.NotNil(t, stored)
+
+	revoked, err := s.IsTokenRevoked(t.Context(), expiredJTI, "")
+	require.NoError(t, err)
+	assert.False(t, revoked)
+	revoked, err = s.IsTokenRevoked(t.Context(), liveJTI, "")
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	revoked, err = s.IsTokenRevoked(t.Context(), unique("jti"), sessionID)
+	require.NoError(t, err)
+	assert.False(t, revoked, "a session with a live refresh token is kept")
+
+	revokedSession := unique("session")
+	require.NoError(t, s.CreateSession(t.Context(), revokedSession, userID))
+	revokedToken := &models.RefreshToken{UserID: userID, SessionID: revokedSession, TokenHash: unique("hash"), ExpiresAt: time.Now().Add(time.Hour)}
+	require.NoError(t, s.CreateRefreshToken(t.Context(), revokedToken))
+	require.NoError(t, s.RevokeSession(t.Context(), revokedSession))
+
+	idleSession := unique("session")
+	require.NoError(t, s.CreateSession(t.Context(), idleSession, userID))
+	idleToken := &models.RefreshToken{UserID: userID, SessionID: idleSession, TokenHash: unique("hash"), ExpiresAt: time.Now().Add(-time.Minute)}
+	require.NoError(t, s.CreateRefreshToken(t.Context(), idleToken))
+
+	_, err = s.PurgeExpiredTokens(t.Context())
+	require.NoError(t, err)
+	stored, err = s.GetRefreshToken(t.Context(), revokedToken.TokenHash)
+	require.NoError(t, err)
+	assert.Nil(t, stored, "refresh tokens of a revoked session go with it")
+	revoked, err = s.IsTokenRevoked(t.Context(), unique("jti"), revokedSession)
+	require.NoError(t, err)
+	assert.True(t, revoked, "access tokens of a purged session stay revoked")
+	revoked, err = s.IsTokenRevoked(t.Context(), unique("jti"), idleSession)
+	require.NoError(t, err)
+	assert.False(t, revoked, "a new session is spared until login has issued its refresh token")
+
+	grace := database.SessionPurgeGrace
+	database.SessionPurgeGrace = 0
+	t.Cleanup(func() { database.SessionPurgeGrace = grace })
+
+	_, err = s.PurgeExpiredTokens(t.Context())
+	require.NoError(t, err)
+	revoked, err = s.IsTokenRevoked(t.Context(), unique("jti"), idleSession)
+	require.NoError(t, err)
+	assert.True(t, revoked, "a session without unexpired refresh tokens is purged")
+	revoked, err = s.IsTokenRevoked(t.Context(), unique("jti"), sessionID)
+	require.NoError(t, err)
+	assert.False(t, revoked, "a session with a live refresh token survives any grace")
+	stored, err = s.GetRefreshToken(t.Context(), live.TokenHash)
+	require.NoError(t, err)
+	assert.NotNil(t, stored)
+}
+
+func testTransactions(t *testing.T, s database.Storage) {
+	errAbort := errors.New("abort")
+	userID, _ := createUser(t, s)
+
+	var login string
+	err := s.WithinTx(t.Context(), database.TxOptions{}, func(ctx context.Context) error {
+		login = unique("rolled-back")
+		if _, err := s.CreateUser(ctx, models.User{Login: login, PasswordHash: "hash"}); err != nil {
+			return err
+		}
+		number := unique("order")
+		if err := s.CreateOrder(ctx, &models.Order{UserID: userID, Number: number, Status: "NEW", UploadedAt: time.Now()}); err != nil {
+			return err
+		}
+		// Withdraw opens its own transaction, which must join this one.
+		if err := s.UpdateOrderFromAccrual(ctx, number, "PROCESSED", money.FromFloat(10)); err != nil {
+			return err
+		}
+		if err := s.Withdraw(ctx, &models.Withdrawal{
+			UserID: userID, OrderNumber: unique("withdrawal"), Sum: money.FromFloat(4), ProcessedAt: time.Now(),
+		}); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	require.ErrorIs(t, err, errAbort)
+
+	user, err := s.GetUserByLogin(t.Context(), login)
+	require.NoError(t, err)
+	assert.Nil(t, user, "a user created in a rolled back transaction is gone")
+	orders, err := s.GetOrdersByUser(t.Context(), userID)
+	require.NoError(t, err)
+	assert.Empty(t, orders)
+	balance, err := s.GetBalance(t.Context(), userID)
+	require.NoError(t, err)
+	assert.Zero(t, balance.Current)
+	assert.Zero(t, balance.Withdrawn)
+
+	err = s.WithinTx(t.Context(), database.TxOptions{Isolation: sql.LevelSerializable}, func(ctx context.Context) error {
+		login = unique("committed")
+		_, err := s.CreateUser(ctx, models.User{Login: login, PasswordHash: "hash"})
+		return err
+	})
+	require.NoError(t, err)
+
+	user, err = s.GetUserByLogin(t.Context(), login)
+	require.NoError(t, err)
+	assert.NotNil(t, user)
+}
+
+func pendingEvents(t *testing.T, s database.Storage, userID int) []models.OutboxEvent {
+	t.Helper()
+
+	// A shared database may hold many undelivered events.
+	events, err := s.PendingOutboxEvents(t.Context(), 1_000_000)
+	require.NoError(t, err)
+
+	var ours []models.OutboxEvent
+	for _, event := range events {
+		if event.UserID == userID {
+			ours = append(ours, event)
+		}
+	}
+	return ours
+}
+
+func eventTypes(events []models.OutboxEvent) []string {
+	var types []string
+	for _, event := range events {
+		types = append(types, event.Type)
+	}
+	return types
+}
+
+func testOutbox(t *testing.T, s database.Storage) {
+	userID, login := createUser(t, s)
+	number := createOrder(t, s, userID, "NEW", time.Now())
+	require.NoError(t, s.UpdateOrderFromAccrual(t.Context(), number, "PROCESSED", money.FromFloat(100)))
+	require.NoError(t, s.UpdateOrderFromAccrual(t.Context(), number, "PROCESSED", money.FromFloat(100)),
+		"an update that changes nothing records no event")
+	require.NoError(t, s.Withdraw(t.Context(), &models.Withdrawal{
+		UserID: userID, OrderNumber: unique("withdrawal"), Sum: money.FromFloat(30), ProcessedAt: time.Now(),
+	}))
+
+	errAbort := errors.New("abort")
+	err := s.WithinTx(t.Context(), database.TxOptions{}, func(ctx context.Context) error {
+		if err := s.CreateOrder(ctx, &models.Order{
+			UserID: userID, Number: unique("order"), Status: "NEW", UploadedAt: time.Now(),
+		}); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	require.ErrorIs(t, err, errAbort)
+
+	events := pendingEvents(t, s, userID)
+	require.Equal(t, []string{
+		database.EventUserRegistered,
+		database.EventOrderUploaded,
+		database.EventOrderUpdated,
+		database.EventWithdrawalCreated,
+	}, eventTypes(events), "events of rolled back changes are gone")
+	for i := 1; i < len(events); i++ {
+		assert.Greater(t, events[i].ID, events[i-1].ID)
+	}
+
+	var registered struct {
+		UserID int    `json:"user_id"`
+		Login  string `json:"login"`
+	}
+	require.NoError(t, json.Unmarshal(events[0].Payload, &registered))
+	assert.Equal(t, userID, registered.UserID)
+	assert.Equal(t, login, registered.Login)
+	assert.JSONEq(t, `{"number":"`+number+`","status":"PROCESSED","accrual":100}`, string(events[2].Payload))
+
+	otherID, _ := createUser(t, s)
+
+	// A failed event holds back the later events of its user only.
+	require.NoError(t, s.MarkOutboxEventFailed(t.Context(), events[1].ID, time.Now().Add(time.Hour), "boom"))
+	assert.Equal(t, []string{database.EventUserRegistered}, eventTypes(pendingEvents(t, s, userID)))
+	assert.Len(t, pendingEvents(t, s, otherID), 1)
+
+	require.NoError(t, s.MarkOutboxEventDelivered(t.Context(), events[0].ID))
+	assert.Empty(t, pendingEvents(t, s, userID))
+
+	require.NoError(t, s.MarkOutboxEventFailed(t.Context(), events[1].ID, time.Now().Add(-time.Second), "boom again"))
+	retried := pendingEvents(t, s, userID)
+	require.Len(t, retried, 3)
+	assert.Equal(t, events[1].ID, retried[0].ID)
+	assert.Equal(t, 2, retried[0].Attempts)
+	assert.Equal(t, "boom again", retried[0].LastError)
+
+	assert.ErrorIs(t, s.MarkOutboxEventDelivered(t.Context(), -1), database.ErrNotFound)
+	assert.ErrorIs(t, s.MarkOutboxEventFailed(t.Context(), -1, time.Now(), "missing"), database.ErrNotFound)
+}
+
+func pendingDeliveries(t *testing.T, s database.Storage, webhookID int64) []models.WebhookDelivery {
+	t.Helper()
+
+	deliveries, err := s.PendingWebhookDeliveries(t.Context(), 1_000_000)
+	require.NoError(t, err)
+
+	var ours []models.WebhookDelivery
+	for _, delivery := range deliveries {
+		if delivery.WebhookID == webhookID {
+			ours = append(ours, delivery)
+		}
+	}
+	return ours
+}
+
+func testWebhooks(t *testing.T, s database.Storage) {
+	ctx := t.Context()
+	userID, _ := createUser(t, s)
+	otherID, _ := createUser(t, s)
+
+	all := &models.Webhook{UserID: userID, URL: "https://example.com/all", Secret: "secret", Enabled: true}
+	withdrawals := &models.Webhook{
+		UserID: userID, URL: "https://example.com/withdrawals", Secret: "secret", Enabled: true,
+		Events: []string{database.WebhookWithdrawalCreated},
+	}
+	foreign := &models.Webhook{UserID: otherID, URL: "https://example.com/other", Secret: "secret", Enabled: true}
+	for _, webhook := range []*models.Webhook{all, withdrawals, foreign} {
+		require.NoError(t, s.CreateWebhook(ctx, webhook))
+		require.NotZero(t, webhook.ID)
+	}
+
+	got, err := s.GetWebhook(ctx, withdrawals.ID)
+	require.NoError(t, err)
+	assert.Equal(t, withdrawals.URL, got.URL)
+	assert.Equal(t, []string{database.WebhookWithdrawalCreated}, got.Events)
+	assert.True(t, got.Enabled)
+
+	list, err := s.GetWebhooksByUser(ctx, userID)
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	assert.Equal(t, all.ID, list[0].ID)
+	assert.Empty(t, list[0].Events)
+
+	_, err = s.GetWebhook(ctx, -1)
+	assert.ErrorIs(t, err, database.ErrNotFound)
+
+	// Only subscribed webhooks of the event's user get it, and only once.
+	order := models.WebhookDelivery{EventID: sequence.Add(1), EventType: database.WebhookOrderProcessed, Payload: json.RawMessage(`{"number":"1"}`)}
+	queued, err := s.EnqueueWebhookDeliveries(ctx, userID, order)
+	require.NoError(t, err)
+	assert.Equal(t, 1, queued)
+	queued, err = s.EnqueueWebhookDeliveries(ctx, userID, order)
+	require.NoError(t, err)
+	assert.Zero(t, queued)
+
+	withdrawal := models.WebhookDelivery{EventID: sequence.Add(1), EventType: database.WebhookWithdrawalCreated, Payload: json.RawMessage(`{}`)}
+	queued, err = s.EnqueueWebhookDeliveries(ctx, userID, withdrawal)
+	require.NoError(t, err)
+	assert.Equal(t, 2, queued)
+
+	pending := pendingDeliveries(t, s, all.ID)
+	require.Len(t, pending, 2)
+	assert.Equal(t, order.EventID, pending[0].EventID)
+	assert.Equal(t, database.WebhookDeliveryPending, pending[0].Status)
+	assert.JSONEq(t, `{"number":"1"}`, string(pending[0].Payload))
+	assert.Empty(t, pendingDeliveries(t, s, foreign.ID))
+
+	// A retried failure waits, a success resets the failure count.
+	now := time.Now()
+	disabled, err := s.RecordWebhookAttempt(ctx, models.WebhookAttempt{
+		DeliveryID: pending[0].ID, ResponseCode: 500, Error: "boom", AttemptedAt: now, NextAttemptAt: now.Add(time.Hour),
+	}, 2)
+	require.NoError(t, err)
+	assert.False(t, disabled)
+	require.Len(t, pendingDeliveries(t, s, all.ID), 1)
+
+	got, err = s.GetWebhook(ctx, all.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, got.ConsecutiveFailures)
+
+	_, err = s.RecordWebhookAttempt(ctx, models.WebhookAttempt{
+		DeliveryID: pending[1].ID, Succeeded: true, ResponseCode: 204, AttemptedAt: now,
+	}, 2)
+	require.NoError(t, err)
+	got, err = s.GetWebhook(ctx, all.ID)
+	require.NoError(t, err)
+	assert.Zero(t, got.ConsecutiveFailures)
+
+	_, err = s.RecordWebhookAttempt(ctx, models.WebhookAttempt{DeliveryID: pending[1].ID, Succeeded: true, AttemptedAt: now}, 2)
+	assert.ErrorIs(t, err, database.ErrNotFound, "a finished delivery takes no more attempts")
+
+	log, err := s.GetWebhookDeliveries(ctx, all.ID, 10)
+	require.NoError(t, err)
+	require.Len(t, log, 2)
+	assert.Equal(t, pending[1].ID, log[0].ID, "newest first")
+	assert.Equal(t, database.WebhookDeliverySucceeded, log[0].Status)
+	assert.NotNil(t, log[0].DeliveredAt)
+	assert.Equal(t, 1, log[0].Attempts)
+	assert.Equal(t, database.WebhookDeliveryPending, log[1].Status)
+	assert.Equal(t, 500, log[1].ResponseCode)
+	assert.Equal(t, "boom", log[1].LastError)
+	assert.NotNil(t, log[1].LastAttemptAt)
+
+	// Failures in a row disable the webhook; giving up ends the delivery.
+	pending = pendingDeliveries(t, s, withdrawals.ID)
+	require.Len(t, pending, 1)
+	disabled, err = s.RecordWebhookAttempt(ctx, models.WebhookAttempt{DeliveryID: pending[0].ID, Error: "boom", AttemptedAt: now, NextAttemptAt: now}, 2)
+	require.NoError(t, err)
+	assert.False(t, disabled)
+	disabled, err = s.RecordWebhookAttempt(ctx, models.WebhookAttempt{DeliveryID: pending[0].ID, Error: "boom", AttemptedAt: now}, 2)
+	require.NoError(t, err)
+	assert.True(t, disabled)
+
+	got, err = s.GetWebhook(ctx, withdrawals.ID)
+	require.NoError(t, err)
+	assert.False(t, got.Enabled)
+	assert.NotNil(t, got.DisabledAt)
+	assert.Equal(t, 2, got.ConsecutiveFailures)
+
+	log, err = s.GetWebhookDeliveries(ctx, withdrawals.ID, 10)
+	require.NoError(t, err)
+	require.Len(t, log, 1)
+	assert.Equal(t, database.WebhookDeliveryFailed, log[0].Status)
+	assert.Equal(t, 2, log[0].Attempts)
+
+	// A disabled webhook gets no new events.
+	queued, err = s.EnqueueWebhookDeliveries(ctx, userID, models.WebhookDelivery{
+		EventID: sequence.Add(1), EventType: database.WebhookWithdrawalCreated, Payload: json.RawMessage(`{}`),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, queued, "only the webhook subscribed to everything")
+	log, err = s.GetWebhookDeliveries(ctx, withdrawals.ID, 10)
+	require.NoError(t, err)
+	assert.Len(t, log, 1)
+
+	got.Enabled = true
+	got.ConsecutiveFailures = 0
+	got.DisabledAt = nil
+	got.URL = "https://example.com/changed"
+	got.Events = nil
+	require.NoError(t, s.UpdateWebhook(ctx, got))
+	got, err = s.GetWebhook(ctx, withdrawals.ID)
+	require.NoError(t, err)
+	assert.True(t, got.Enabled)
+	assert.Nil(t, got.DisabledAt)
+	assert.Equal(t, "https://example.com/changed", got.URL)
+	assert.Empty(t, got.Events)
+
+	require.NoError(t, s.DeleteWebhook(ctx, all.ID))
+	_, err = s.GetWebhook(ctx, all.ID)
+	assert.ErrorIs(t, err, database.ErrNotFound)
+	log, err = s.GetWebhookDeliveries(ctx, all.ID, 10)
+	require.NoError(t, err)
+	assert.Empty(t, log)
+	assert.ErrorIs(t, s.DeleteWebhook(ctx, all.ID), database.ErrNotFound)
+	assert.ErrorIs(t, s.UpdateWebhook(ctx, all), database.ErrNotFound)
+}
+
+func testStats(t *testing.T, s database.Storage) {
+	// A shared database holds rows from other subtests, so only the
+	// differences are compared.
+	stats, ok := s.(database.Stats)
+	if !ok {
+		t.Skip("storage does not implement database.Stats")
+	}
+
+	ordersBefore, err := stats.CountOrdersByStatus(t.Context())
+	require.NoError(t, err)
+	countBefore, sumBefore, err := stats.WithdrawalTotals(t.Context())
+	require.NoError(t, err)
+
+	userID, _ := createUser(t, s)
+	createOrder(t, s, userID, "NEW", time.Now())
+	createOrder(t, s, userID, "NEW", time.Now())
+	createOrder(t, s, userID, "INVALID", time.Now())
+	fund(t, s, userID, money.FromFloat(100))
+	require.NoError(t, s.Withdraw(t.Context(), &models.Withdrawal{
+		UserID: userID, OrderNumber: unique("withdrawal"), Sum: money.FromFloat(12.5), ProcessedAt: time.Now(),
+	}))
+	require.NoError(t, s.Withdraw(t.Context(), &models.Withdrawal{
+		UserID: userID, OrderNumber: unique("withdrawal"), Sum: money.FromFloat(0.5), ProcessedAt: time.Now(),
+	}))
+
+	orders, err := stats.CountOrdersByStatus(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, 2, orders["NEW"]-ordersBefore["NEW"])
+	assert.Equal(t, 1, orders["INVALID"]-ordersBefore["INVALID"])
+	assert.Equal(t, 1, orders["PROCESSED"]-ordersBefore["PROCESSED"])
+
+	count, sum, err := stats.WithdrawalTotals(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, 2, count-countBefore)
+	assert.Equal(t, money.FromFloat(13), sum-sumBefore)
+}
+
+func testConcurrentWithdrawals(t *testing.T, s database.Storage) {
+	userID, _ := createUser(t, s)
+	fund(t, s, userID, money.FromFloat(100))
+
+	const attempts = 20
+	var (
+		wg        sync.WaitGroup
+		succeeded atomic.Int32
+		errs      = make(chan error, attempts)
+	)
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := s.Withdraw(t.Context(), &models.Withdrawal{
+				UserID:      userID,
+				OrderNumber: unique("withdrawal"),
+				Sum:         money.FromFloat(10),
+				ProcessedAt: time.Now(),
+			})
+			if err == nil {
+				succeeded.Add(1)
+				return
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.ErrorIs(t, err, database.ErrInsufficientFunds)
+	}
+	assert.EqualValues(t, 10, succeeded.Load())
+
+	balance, err := s.GetBalance(t.Context(), userID)
+	require.NoError(t, err)
+	assert.Equal(t, money.Amount(0), balance.Current)
+	assert.Equal(t, money.FromFloat(100), balance.Withdrawn)
+}
+
+func testConcurrentRegistration(t *testing.T, s database.Storage) {
+	login := unique("user")
+
+	const attempts = 10
+	var (
+		wg  sync.WaitGroup
+		mu  sync.Mutex
+		ids []int
+	)
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id, err := s.CreateUser(t.Context(), models.User{Login: login, PasswordHash: "hash"})
+			if err != nil {
+				assert.ErrorIs(t, err, database.ErrUserExists)
+				return
+			}
+			mu.Lock()
+			ids = append(ids, id)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	require.Len(t, ids, 1)
+	user, err := s.GetUserByLogin(t.Context(), login)
+	require.NoError(t, err)
+	assert.Equal(t, ids[0], user.ID)
+}
+
+func reversed(values []string) []string {
+	out := slices.Clone(values)
+	slices.Reverse(out)
+	return out
+}
+
+func orderNumbers(orders []models.Order) []string {
+	numbers := make([]string, 0, len(orders))
+	for _, order := range orders {
+		numbers = append(numbers, order.Number)
+	}
+	return numbers
+}
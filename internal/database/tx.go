@@ -0,0 +1,24 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+)
+
+// TxOptions configures a unit of work. MaxRetries bounds how many times the
+// whole function is re-run after a serialization failure or deadlock; zero
+// selects the backend default.
+type TxOptions struct {
+	Isolation  sql.IsolationLevel
+	ReadOnly   bool
+	MaxRetries int
+}
+
+// Transactor runs fn as a single unit of work. Repository calls made with
+// the context passed to fn join the transaction; fn returning an error
+// rolls everything back. Calling WithinTx with a context that already
+// carries a transaction joins it instead of starting a new one, so fn may
+// be re-run and must not have side effects outside the storage.
+type Transactor interface {
+	WithinTx(ctx context.Context, opts TxOptions, fn func(ctx context.Context) error) error
+}
@@ -0,0 +1,49 @@
+package database
+
+import (
+	"context"
+	"slices"
+
+	"github.com/alisaviation/internal/gophermart/models"
+)
+
+const (
+	WebhookOrderProcessed    = "order.processed"
+	WebhookOrderInvalid      = "order.invalid"
+	WebhookWithdrawalCreated = "withdrawal.created"
+)
+
+var WebhookEventTypes = []string{
+	WebhookOrderProcessed,
+	WebhookOrderInvalid,
+	WebhookWithdrawalCreated,
+}
+
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliverySucceeded = "succeeded"
+	WebhookDeliveryFailed    = "failed"
+)
+
+type Webhooks interface {
+	CreateWebhook(ctx context.Context, webhook *models.Webhook) error
+	GetWebhook(ctx context.Context, id int64) (*models.Webhook, error)
+	GetWebhooksByUser(ctx context.Context, userID int) ([]models.Webhook, error)
+	UpdateWebhook(ctx context.Context, webhook *models.Webhook) error
+	DeleteWebhook(ctx context.Context, id int64) error
+
+	// EnqueueWebhookDeliveries skips webhooks that already have the event,
+	// so enqueuing is idempotent.
+	EnqueueWebhookDeliveries(ctx context.Context, userID int, delivery models.WebhookDelivery) (int, error)
+	// PendingWebhookDeliveries returns deliveries oldest first.
+	PendingWebhookDeliveries(ctx context.Context, limit int) ([]models.WebhookDelivery, error)
+	// RecordWebhookAttempt disables the webhook, and reports it, once it
+	// fails disableAfter times in a row; zero never disables.
+	RecordWebhookAttempt(ctx context.Context, attempt models.WebhookAttempt, disableAfter int) (bool, error)
+	// GetWebhookDeliveries returns deliveries newest first.
+	GetWebhookDeliveries(ctx context.Context, webhookID int64, limit int) ([]models.WebhookDelivery, error)
+}
+
+func Subscribed(webhook models.Webhook, eventType string) bool {
+	return len(webhook.Events) == 0 || slices.Contains(webhook.Events, eventType)
+}
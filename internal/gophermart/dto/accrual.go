@@ -1,7 +1,9 @@
 package dto
 
+import "github.com/alisaviation/pkg/money"
+
 type AccrualResponse struct {
-	Order   string  `json:"order"`
-	Status  string  `json:"status"`
-	Accrual float64 `json:"accrual,omitempty"`
+	Order   string       `json:"order"`
+	Status  string       `json:"status"`
+	Accrual money.Amount `json:"accrual,omitempty"`
 }
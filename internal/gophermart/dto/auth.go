@@ -9,3 +9,12 @@ type LoginRequest struct {
 	Login    string `json:"login" validate:"required"`
 	Password string `json:"password" validate:"required"`
 }
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+type AuthTokens struct {
+	AccessToken  string
+	RefreshToken string
+}
@@ -1,17 +1,19 @@
 package dto
 
+import "github.com/alisaviation/pkg/money"
+
 type WithdrawRequest struct {
-	Order string  `json:"order" validate:"required"`
-	Sum   float64 `json:"sum" validate:"required,gt=0"`
+	Order string       `json:"order" validate:"required"`
+	Sum   money.Amount `json:"sum" validate:"required,gt=0"`
 }
 
 type WithdrawalResponse struct {
-	Order       string  `json:"order"`
-	Sum         float64 `json:"sum"`
-	ProcessedAt string  `json:"processed_at"`
+	Order       string       `json:"order"`
+	Sum         money.Amount `json:"sum"`
+	ProcessedAt string       `json:"processed_at"`
 }
 
 type BalanceResponse struct {
-	Current   float64 `json:"current"`
-	Withdrawn float64 `json:"withdrawn"`
+	Current   money.Amount `json:"current"`
+	Withdrawn money.Amount `json:"withdrawn"`
 }
@@ -1,14 +1,39 @@
 package dto
 
-import "time"
+import (
+	"time"
+
+	"github.com/alisaviation/pkg/money"
+)
 
 type UploadOrderRequest struct {
 	OrderNumber string `validate:"required,numeric"`
 }
 
 type OrderResponse struct {
-	Number     string    `json:"number"`
-	Status     string    `json:"status"`
-	Accrual    float64   `json:"accrual,omitempty"`
-	UploadedAt time.Time `json:"uploaded_at"`
+	Number     string       `json:"number"`
+	Status     string       `json:"status"`
+	Accrual    money.Amount `json:"accrual,omitempty"`
+	UploadedAt time.Time    `json:"uploaded_at"`
+}
+
+// Per-item outcomes of a batch upload.
+const (
+	BatchOrderAccepted        = "accepted"
+	BatchOrderAlreadyUploaded = "already_uploaded"
+	BatchOrderConflict        = "conflict"
+	BatchOrderInvalid         = "invalid"
+)
+
+type BatchOrderResult struct {
+	Number string `json:"number"`
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+type OrderEventData struct {
+	Number    string       `json:"number"`
+	Status    string       `json:"status"`
+	Accrual   money.Amount `json:"accrual,omitempty"`
+	UpdatedAt time.Time    `json:"updated_at"`
 }
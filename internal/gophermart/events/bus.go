@@ -0,0 +1,192 @@
+// Package events fans out order changes to the users who own the orders.
+package events
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alisaviation/pkg/money"
+)
+
+const (
+	defaultHistorySize = 1024
+	subscriberBuffer   = 64
+)
+
+// EventID identifies an event to a reconnecting client. Seq increases by
+// one per event and starts over with every bus, so Epoch, the time the bus
+// was created, tells the IDs of an earlier process apart.
+type EventID struct {
+	Epoch int64
+	Seq   uint64
+}
+
+func (id EventID) String() string {
+	return fmt.Sprintf("%d-%d", id.Epoch, id.Seq)
+}
+
+// ParseEventID parses an ID formatted by String. A bare sequence number, as
+// sent by clients of a version without epochs, gets a zero epoch, which no
+// bus resumes from.
+func ParseEventID(s string) (EventID, error) {
+	epoch, seq, found := strings.Cut(s, "-")
+	if !found {
+		epoch, seq = "0", s
+	}
+
+	var id EventID
+	var err error
+	if id.Epoch, err = strconv.ParseInt(epoch, 10, 64); err != nil {
+		return EventID{}, fmt.Errorf("invalid event id %q", s)
+	}
+	if id.Seq, err = strconv.ParseUint(seq, 10, 64); err != nil {
+		return EventID{}, fmt.Errorf("invalid event id %q", s)
+	}
+	return id, nil
+}
+
+type OrderEvent struct {
+	ID      EventID
+	UserID  int
+	Number  string
+	Status  string
+	Accrual money.Amount
+	At      time.Time
+}
+
+// Bus is an in-process publish/subscribe hub. It keeps the most recent
+// events so that a reconnecting subscriber can catch up on what it missed.
+type Bus struct {
+	mu      sync.Mutex
+	epoch   int64
+	lastID  uint64
+	history []OrderEvent
+	next    int
+	subs    map[*Subscription]struct{}
+	closed  bool
+}
+
+func NewBus(historySize int) *Bus {
+	if historySize <= 0 {
+		historySize = defaultHistorySize
+	}
+	return &Bus{
+		epoch:   time.Now().UnixNano(),
+		history: make([]OrderEvent, 0, historySize),
+		subs:    make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscription receives the events of one user. Its channel is closed when
+// the subscription ends: on Close, when the bus is closed, or when the
+// subscriber falls so far behind that events would have to be dropped.
+type Subscription struct {
+	bus    *Bus
+	userID int
+	events chan OrderEvent
+}
+
+func (s *Subscription) Events() <-chan OrderEvent {
+	return s.events
+}
+
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	s.bus.remove(s)
+}
+
+// Publish drops events once the bus is closed.
+func (b *Bus) Publish(event OrderEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return
+	}
+
+	b.lastID++
+	event.ID = EventID{Epoch: b.epoch, Seq: b.lastID}
+	if event.At.IsZero() {
+		event.At = time.Now()
+	}
+
+	if len(b.history) < cap(b.history) {
+		b.history = append(b.history, event)
+	} else {
+		b.history[b.next] = event
+		b.next = (b.next + 1) % cap(b.history)
+	}
+
+	for sub := range b.subs {
+		if sub.userID != event.UserID {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			// The subscriber resumes from history when it reconnects.
+			b.remove(sub)
+		}
+	}
+}
+
+// Subscribe starts delivering the events of userID. With a non-zero
+// lastEventID it also returns the user's events published after it. complete
+// is false when some of those events are no longer kept, or lastEventID was
+// issued by another bus, so the subscriber should reload its state instead.
+func (b *Bus) Subscribe(userID int, lastEventID EventID) (sub *Subscription, missed []OrderEvent, complete bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub = &Subscription{
+		bus:    b,
+		userID: userID,
+		events: make(chan OrderEvent, subscriberBuffer),
+	}
+	if b.closed {
+		close(sub.events)
+		return sub, nil, true
+	}
+	b.subs[sub] = struct{}{}
+
+	if lastEventID == (EventID{}) {
+		return sub, nil, true
+	}
+	if lastEventID.Epoch != b.epoch || lastEventID.Seq > b.lastID {
+		return sub, nil, false
+	}
+
+	complete = true
+	for i := range b.history {
+		event := b.history[(b.next+i)%len(b.history)]
+		if i == 0 && event.ID.Seq > lastEventID.Seq+1 {
+			complete = false
+		}
+		if event.ID.Seq > lastEventID.Seq && event.UserID == userID {
+			missed = append(missed, event)
+		}
+	}
+	return sub, missed, complete
+}
+
+func (b *Bus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for sub := range b.subs {
+		b.remove(sub)
+	}
+}
+
+func (b *Bus) remove(sub *Subscription) {
+	if _, ok := b.subs[sub]; !ok {
+		return
+	}
+	delete(b.subs, sub)
+	close(sub.events)
+}
@@ -1,6 +1,11 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/alisaviation/pkg/money"
+)
 
 type User struct {
 	ID           int
@@ -13,20 +18,123 @@ type Order struct {
 	UserID     int
 	Number     string
 	Status     string
-	Accrual    float64
+	Accrual    money.Amount
 	UploadedAt time.Time
 }
 
 type Balance struct {
 	UserID    int
-	Current   float64
-	Withdrawn float64
+	Current   money.Amount
+	Withdrawn money.Amount
+}
+
+type BalanceMismatch struct {
+	UserID            int
+	Current           money.Amount
+	Withdrawn         money.Amount
+	ExpectedCurrent   money.Amount
+	ExpectedWithdrawn money.Amount
 }
 
 type Withdrawal struct {
 	ID          int
 	UserID      int
 	OrderNumber string
-	Sum         float64
+	Sum         money.Amount
 	ProcessedAt time.Time
 }
+
+type JournalEntry struct {
+	ID              int64
+	Kind            string
+	UserID          int
+	OrderNumber     string
+	WithdrawalID    int
+	ReversesEntryID *int64
+	Description     string
+	CreatedAt       time.Time
+	Postings        []Posting
+}
+
+type Posting struct {
+	Account string
+	UserID  int
+	Amount  money.Amount
+}
+
+type IdempotencyRecord struct {
+	UserID      int
+	Key         string
+	RequestHash string
+	Completed   bool
+	StatusCode  int
+	Headers     map[string][]string
+	Body        []byte
+	ExpiresAt   time.Time
+	// LockedUntil is when an unfinished request is presumed dead, so that a
+	// retry may take the key over.
+	LockedUntil time.Time
+}
+
+type RefreshToken struct {
+	ID             int64
+	UserID         int
+	Login          string
+	SessionID      string
+	TokenHash      string
+	ExpiresAt      time.Time
+	UsedAt         *time.Time
+	SessionRevoked bool
+}
+
+// OutboxEvent is a domain event waiting to be relayed to other systems.
+type OutboxEvent struct {
+	ID            int64
+	Type          string
+	UserID        int
+	Payload       json.RawMessage
+	CreatedAt     time.Time
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+}
+
+// A Webhook with no Events is subscribed to every event type.
+type Webhook struct {
+	ID                  int64
+	UserID              int
+	URL                 string
+	Secret              string
+	Events              []string
+	Enabled             bool
+	ConsecutiveFailures int
+	DisabledAt          *time.Time
+	CreatedAt           time.Time
+}
+
+type WebhookDelivery struct {
+	ID            int64
+	WebhookID     int64
+	EventID       int64
+	EventType     string
+	Payload       json.RawMessage
+	Status        string
+	Attempts      int
+	NextAttemptAt time.Time
+	LastAttemptAt *time.Time
+	ResponseCode  int
+	LastError     string
+	CreatedAt     time.Time
+	DeliveredAt   *time.Time
+}
+
+// WebhookAttempt is the outcome of sending a delivery once. A failed attempt
+// with a zero NextAttemptAt gives the delivery up.
+type WebhookAttempt struct {
+	DeliveryID    int64
+	Succeeded     bool
+	ResponseCode  int
+	Error         string
+	AttemptedAt   time.Time
+	NextAttemptAt time.Time
+}
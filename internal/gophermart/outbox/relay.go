@@ -0,0 +1,124 @@
+// Package outbox relays domain events from the outbox table to external
+// sinks with at-least-once delivery.
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/alisaviation/internal/database"
+	"github.com/alisaviation/pkg/logger"
+)
+
+const (
+	defaultPollInterval = time.Second
+	defaultBatchSize    = 100
+	defaultMinBackoff   = time.Second
+	defaultMaxBackoff   = 5 * time.Minute
+	deliveryTimeout     = 10 * time.Second
+)
+
+// Relay polls the outbox and hands events to the sink in ID order. When an
+// event fails, later events of the same user wait for its retry, so every
+// user's events arrive in order. Events of different users do not block each
+// other. An event may be delivered more than once, for example when the
+// process stops between delivery and acknowledgement, so consumers should
+// deduplicate by event ID. Only one relay should run against a database.
+type Relay struct {
+	Store        database.Outbox
+	Sink         Sink
+	PollInterval time.Duration
+	BatchSize    int
+	MinBackoff   time.Duration
+	MaxBackoff   time.Duration
+}
+
+func NewRelay(store database.Outbox, sink Sink, pollInterval time.Duration) *Relay {
+	if pollInterval <= 0 {
+		pollInterval = defaultPollInterval
+	}
+	return &Relay{
+		Store:        store,
+		Sink:         sink,
+		PollInterval: pollInterval,
+		BatchSize:    defaultBatchSize,
+		MinBackoff:   defaultMinBackoff,
+		MaxBackoff:   defaultMaxBackoff,
+	}
+}
+
+func (r *Relay) Run(ctx context.Context) {
+	logger.Log.Info("Starting outbox relay",
+		zap.Duration("poll_interval", r.PollInterval))
+
+	ticker := time.NewTicker(r.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.ProcessPending(ctx); err != nil {
+			logger.Log.Error("Failed to relay outbox events", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			logger.Log.Info("Outbox relay stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessPending delivers one batch of due events.
+func (r *Relay) ProcessPending(ctx context.Context) error {
+	events, err := r.Store.PendingOutboxEvents(ctx, r.BatchSize)
+	if err != nil {
+		return fmt.Errorf("failed to get pending outbox events: %w", err)
+	}
+
+	blocked := make(map[int]bool)
+	for _, event := range events {
+		if ctx.Err() != nil {
+			return nil
+		}
+		if blocked[event.UserID] {
+			continue
+		}
+
+		deliverCtx, cancel := context.WithTimeout(ctx, deliveryTimeout)
+		err := r.Sink.Deliver(deliverCtx, NewMessage(event))
+		cancel()
+
+		if err != nil {
+			blocked[event.UserID] = true
+			next := time.Now().Add(Backoff(r.MinBackoff, r.MaxBackoff, event.Attempts))
+			logger.Log.Warn("Failed to deliver outbox event",
+				zap.Int64("event_id", event.ID),
+				zap.String("type", event.Type),
+				zap.Int("attempts", event.Attempts+1),
+				zap.Time("next_attempt_at", next),
+				zap.Error(err))
+			if err := r.Store.MarkOutboxEventFailed(ctx, event.ID, next, err.Error()); err != nil {
+				return fmt.Errorf("failed to record outbox delivery failure: %w", err)
+			}
+			continue
+		}
+
+		if err := r.Store.MarkOutboxEventDelivered(ctx, event.ID); err != nil {
+			return fmt.Errorf("failed to mark outbox event delivered: %w", err)
+		}
+	}
+	return nil
+}
+
+// Backoff is the delay before retrying after attempts failures: minDelay
+// doubled with every failed attempt, up to maxDelay.
+func Backoff(minDelay, maxDelay time.Duration, attempts int) time.Duration {
+	delay := minDelay
+	for i := 0; i < attempts && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxDelay)
+}
@@ -0,0 +1,194 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alisaviation/internal/gophermart/models"
+)
+
+// Message is the wire format of an event, the same for every sink.
+type Message struct {
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"`
+	UserID    int             `json:"user_id"`
+	CreatedAt time.Time       `json:"created_at"`
+	Payload   json.RawMessage `json:"payload"`
+}
+
+func NewMessage(event models.OutboxEvent) Message {
+	return Message{
+		ID:        event.ID,
+		Type:      event.Type,
+		UserID:    event.UserID,
+		CreatedAt: event.CreatedAt,
+		Payload:   event.Payload,
+	}
+}
+
+// Sink delivers a message somewhere. A nil error means the message is
+// durably accepted and will not be offered again.
+type Sink interface {
+	Deliver(ctx context.Context, msg Message) error
+	Close() error
+}
+
+// WebhookSink POSTs every message as JSON and treats any 2xx answer as
+// delivered. The X-Event-ID header lets receivers drop duplicates.
+type WebhookSink struct {
+	URL    string
+	Client *http.Client
+}
+
+func NewWebhookSink(url string) *WebhookSink {
+	return &WebhookSink{
+		URL:    url,
+		Client: &http.Client{Timeout: deliveryTimeout},
+	}
+}
+
+func (s *WebhookSink) Deliver(ctx context.Context, msg Message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event-ID", strconv.FormatInt(msg.ID, 10))
+	req.Header.Set("X-Event-Type", msg.Type)
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *WebhookSink) Close() error {
+	return nil
+}
+
+// WriterSink writes one JSON message per line (NDJSON).
+type WriterSink struct {
+	mu   sync.Mutex
+	w    io.Writer
+	file *os.File
+}
+
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{w: w}
+}
+
+// NewFileSink appends to the NDJSON file at path, creating it if needed.
+// The file is synced after every message, so a delivered event survives a
+// crash.
+func NewFileSink(path string) (*WriterSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open outbox file: %w", err)
+	}
+	return &WriterSink{w: f, file: f}, nil
+}
+
+func (s *WriterSink) Deliver(ctx context.Context, msg Message) error {
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.w.Write(line); err != nil {
+		return err
+	}
+	if s.file != nil {
+		return s.file.Sync()
+	}
+	return nil
+}
+
+func (s *WriterSink) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	return s.file.Close()
+}
+
+// MultiSink delivers every message to all sinks. A failure in any of them
+// fails the message, which is then offered to all of them again.
+type MultiSink []Sink
+
+func (m MultiSink) Deliver(ctx context.Context, msg Message) error {
+	for _, sink := range m {
+		if err := sink.Deliver(ctx, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m MultiSink) Close() error {
+	var errs []error
+	for _, sink := range m {
+		errs = append(errs, sink.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// ParseSinks builds the sinks named in a comma-separated list: "stdout",
+// "file://" followed by a path, or an http(s) webhook URL.
+func ParseSinks(spec string) (Sink, error) {
+	var sinks MultiSink
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		switch {
+		case item == "":
+			continue
+		case item == "stdout":
+			sinks = append(sinks, NewWriterSink(os.Stdout))
+		case strings.HasPrefix(item, "file://"):
+			sink, err := NewFileSink(strings.TrimPrefix(item, "file://"))
+			if err != nil {
+				sinks.Close()
+				return nil, err
+			}
+			sinks = append(sinks, sink)
+		case strings.HasPrefix(item, "http://"), strings.HasPrefix(item, "https://"):
+			sinks = append(sinks, NewWebhookSink(item))
+		default:
+			sinks.Close()
+			return nil, fmt.Errorf("unknown outbox sink %q", item)
+		}
+	}
+
+	switch len(sinks) {
+	case 0:
+		return nil, errors.New("no outbox sinks configured")
+	case 1:
+		return sinks[0], nil
+	default:
+		return sinks, nil
+	}
+}
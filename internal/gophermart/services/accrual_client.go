@@ -3,31 +3,45 @@ package services
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
 	"time"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 
 	"github.com/alisaviation/internal/gophermart/dto"
+	"github.com/alisaviation/internal/metrics"
+	"github.com/alisaviation/internal/tracing"
 	"github.com/alisaviation/pkg/logger"
 )
 
+const defaultRetryAfter = 60 * time.Second
+
+var errTooManyRequests = errors.New("too many requests")
+
 type AccrualClient struct {
 	baseURL    string
 	client     *http.Client
+	limiter    *RateLimiter
 	retryDelay time.Duration
 	maxRetries int
 }
 
-func NewAccrualClient(baseURL string) AccrualClientInterface {
+func NewAccrualClient(baseURL string, rps int) AccrualClientInterface {
 	if !strings.HasPrefix(baseURL, "http://") && !strings.HasPrefix(baseURL, "https://") {
 		baseURL = "http://" + baseURL
 	}
 	return &AccrualClient{
 		baseURL:    baseURL,
 		client:     &http.Client{Timeout: 10 * time.Second},
+		limiter:    NewRateLimiter(rps),
 		retryDelay: 1 * time.Second,
 		maxRetries: 3,
 	}
@@ -36,70 +50,162 @@ func NewAccrualClient(baseURL string) AccrualClientInterface {
 func (c *AccrualClient) GetOrderAccrual(ctx context.Context, orderNumber string) (*dto.AccrualResponse, error) {
 	url := fmt.Sprintf("%s/api/orders/%s", c.baseURL, orderNumber)
 
+	ctx, span := tracing.Start(ctx, "AccrualClient.GetOrderAccrual", attribute.String("order.number", orderNumber))
+	defer span.End()
+
 	var lastErr error
 	for attempt := 0; attempt < c.maxRetries; attempt++ {
 		if attempt > 0 {
-			logger.Log.Info("Retrying accrual info request",
+			logger.FromContext(ctx).Info("Retrying accrual info request",
 				zap.String("order", orderNumber),
 				zap.Int("attempt", attempt))
-			time.Sleep(c.retryDelay)
+			if !errors.Is(lastErr, errTooManyRequests) {
+				if err := sleepContext(ctx, c.retryDelay); err != nil {
+					return nil, err
+				}
+			}
 		}
 
-		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-		if err != nil {
-			lastErr = fmt.Errorf("failed to create accrual info request: %w", err)
-			continue
+		if err := c.limiter.Wait(ctx); err != nil {
+			logger.FromContext(ctx).Info("Accrual request postponed by rate limiter",
+				zap.String("order", orderNumber),
+				zap.Time("paused_until", c.limiter.PausedUntil()),
+				zap.Error(err))
+			tracing.RecordError(span, err)
+			return nil, err
 		}
 
-		resp, err := c.client.Do(req)
+		accrualResp, err := c.attempt(ctx, url, orderNumber, attempt, lastErr)
 		if err != nil {
-			lastErr = fmt.Errorf("accrual info request failed: %w", err)
+			lastErr = err
 			continue
 		}
-		defer resp.Body.Close()
+		return accrualResp, nil
+	}
 
-		logger.Log.Info("Accrual info response",
-			zap.String("order", orderNumber),
-			zap.Int("status_code", resp.StatusCode))
-
-		switch resp.StatusCode {
-		case http.StatusOK:
-			var accrualResp dto.AccrualResponse
-			if err := json.NewDecoder(resp.Body).Decode(&accrualResp); err != nil {
-				lastErr = fmt.Errorf("failed to decode accrual response: %w", err)
-				continue
-			}
+	logger.FromContext(ctx).Error("All attempts to get accrual info failed",
+		zap.String("order", orderNumber),
+		zap.Error(lastErr))
+	tracing.RecordError(span, lastErr)
+	return nil, lastErr
+}
 
-			logger.Log.Info("Successfully got accrual info",
-				zap.String("order", orderNumber),
-				zap.String("status", accrualResp.Status),
-				zap.Float64("accrual", accrualResp.Accrual))
+// attempt makes one request to the accrual system in its own client span,
+// which carries the attempt number and, on retries, why the previous
+// attempt failed.
+func (c *AccrualClient) attempt(ctx context.Context, url, orderNumber string, attempt int, lastErr error) (*dto.AccrualResponse, error) {
+	attrs := []attribute.KeyValue{
+		semconv.HTTPRequestMethodGet,
+		semconv.URLFull(url),
+		attribute.String("order.number", orderNumber),
+		attribute.Int("accrual.attempt", attempt+1),
+		attribute.Int("accrual.max_attempts", c.maxRetries),
+		attribute.Bool("accrual.retry", attempt > 0),
+	}
+	if lastErr != nil {
+		attrs = append(attrs, attribute.String("accrual.retry_reason", lastErr.Error()))
+	}
+	ctx, span := tracing.Tracer().Start(ctx, "GET /api/orders/{number}",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...))
+	defer span.End()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		err = fmt.Errorf("failed to create accrual info request: %w", err)
+		tracing.RecordError(span, err)
+		return nil, err
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	if id := logger.RequestID(ctx); id != "" {
+		req.Header.Set(logger.RequestIDHeader, id)
+	}
 
-			return &accrualResp, nil
+	start := time.Now()
+	resp, err := c.client.Do(req)
+	if err != nil {
+		metrics.ObserveAccrualRequest(0, time.Since(start))
+		err = fmt.Errorf("accrual info request failed: %w", err)
+		tracing.RecordError(span, err)
+		return nil, err
+	}
 
-		case http.StatusNoContent:
-			return nil, nil
+	accrualResp, err := c.handleResponse(ctx, resp, orderNumber)
+	resp.Body.Close()
+	metrics.ObserveAccrualRequest(resp.StatusCode, time.Since(start))
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+	tracing.RecordError(span, err)
+	return accrualResp, err
+}
 
-		case http.StatusTooManyRequests:
-			retryAfter := resp.Header.Get("Retry-After")
-			logger.Log.Error("Rate limit exceeded for accrual info",
-				zap.String("order", orderNumber),
-				zap.String("retry_after", retryAfter))
-			lastErr = fmt.Errorf("rate limit exceeded for accrual info")
-			continue
+// Ping reports whether the accrual system answers. It asks about an order
+// number that cannot exist, bypassing the rate limiter, and takes any
+// response short of a server error as an answer.
+func (c *AccrualClient) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+"/api/orders/0", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
 
-		case http.StatusInternalServerError:
-			lastErr = fmt.Errorf("accrual system internal error")
-			continue
+func (c *AccrualClient) handleResponse(ctx context.Context, resp *http.Response, orderNumber string) (*dto.AccrualResponse, error) {
+	logger.FromContext(ctx).Info("Accrual info response",
+		zap.String("order", orderNumber),
+		zap.Int("status_code", resp.StatusCode))
 
-		default:
-			lastErr = fmt.Errorf("unexpected status code: %d", resp.StatusCode)
-			continue
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var accrualResp dto.AccrualResponse
+		if err := json.NewDecoder(resp.Body).Decode(&accrualResp); err != nil {
+			return nil, fmt.Errorf("failed to decode accrual response: %w", err)
+		}
+
+		logger.FromContext(ctx).Info("Successfully got accrual info",
+			zap.String("order", orderNumber),
+			zap.String("status", accrualResp.Status),
+			zap.Stringer("accrual", accrualResp.Accrual))
+
+		return &accrualResp, nil
+
+	case http.StatusNoContent:
+		return nil, nil
+
+	case http.StatusTooManyRequests:
+		retryAfter, ok := ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		if !ok {
+			retryAfter = defaultRetryAfter
 		}
+		c.limiter.Pause(retryAfter)
+		logger.FromContext(ctx).Error("Rate limit exceeded for accrual info",
+			zap.String("order", orderNumber),
+			zap.Duration("retry_after", retryAfter))
+		return nil, fmt.Errorf("rate limit exceeded for accrual info: %w", errTooManyRequests)
+
+	case http.StatusInternalServerError:
+		return nil, fmt.Errorf("accrual system internal error")
+
+	default:
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 	}
+}
 
-	logger.Log.Error("All attempts to get accrual info failed",
-		zap.String("order", orderNumber),
-		zap.Error(lastErr))
-	return nil, lastErr
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
 }
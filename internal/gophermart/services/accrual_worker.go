@@ -0,0 +1,168 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+
+	"github.com/alisaviation/internal/database"
+	"github.com/alisaviation/internal/gophermart/dto"
+	"github.com/alisaviation/internal/gophermart/events"
+	"github.com/alisaviation/internal/gophermart/models"
+	"github.com/alisaviation/internal/tracing"
+	"github.com/alisaviation/pkg/logger"
+)
+
+const (
+	defaultAccrualWorkers      = 4
+	defaultAccrualPollInterval = 1 * time.Second
+	defaultAccrualBatchSize    = 100
+	accrualRequestTimeout      = 5 * time.Second
+)
+
+type AccrualWorker struct {
+	OrderDB       database.Order
+	AccrualClient AccrualClientInterface
+	Workers       int
+	PollInterval  time.Duration
+	BatchSize     int
+	// Events, when set, is told about every order the worker updates.
+	Events OrderEventPublisher
+}
+
+func NewAccrualWorker(orderDB database.Order, accrualClient AccrualClientInterface, workers int, pollInterval time.Duration) *AccrualWorker {
+	if workers <= 0 {
+		workers = defaultAccrualWorkers
+	}
+	if pollInterval <= 0 {
+		pollInterval = defaultAccrualPollInterval
+	}
+	return &AccrualWorker{
+		OrderDB:       orderDB,
+		AccrualClient: accrualClient,
+		Workers:       workers,
+		PollInterval:  pollInterval,
+		BatchSize:     defaultAccrualBatchSize,
+	}
+}
+
+func (w *AccrualWorker) Run(ctx context.Context) {
+	logger.Log.Info("Starting accrual worker",
+		zap.Int("workers", w.Workers),
+		zap.Duration("poll_interval", w.PollInterval))
+
+	ticker := time.NewTicker(w.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := w.ProcessPending(ctx); err != nil {
+			logger.Log.Error("Failed to process pending orders", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			logger.Log.Info("Accrual worker stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *AccrualWorker) ProcessPending(ctx context.Context) error {
+	orders, err := w.OrderDB.GetPendingOrders(ctx, w.BatchSize)
+	if err != nil {
+		return fmt.Errorf("failed to get pending orders: %w", err)
+	}
+	if len(orders) == 0 {
+		return nil
+	}
+
+	jobs := make(chan models.Order)
+	var wg sync.WaitGroup
+	for i := 0; i < w.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for order := range jobs {
+				w.processOrder(ctx, order)
+			}
+		}()
+	}
+
+	for _, order := range orders {
+		select {
+		case <-ctx.Done():
+			close(jobs)
+			wg.Wait()
+			return nil
+		case jobs <- order:
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	return nil
+}
+
+func (w *AccrualWorker) processOrder(ctx context.Context, order models.Order) {
+	ctx, span := tracing.Start(ctx, "AccrualWorker.processOrder", attribute.String("order.number", order.Number))
+	defer span.End()
+
+	reqCtx, cancel := context.WithTimeout(ctx, accrualRequestTimeout)
+	defer cancel()
+
+	accrualInfo, err := w.AccrualClient.GetOrderAccrual(reqCtx, order.Number)
+	if err != nil {
+		logger.Log.Info("Failed to get accrual info",
+			zap.String("order", order.Number),
+			zap.Error(err))
+		tracing.RecordError(span, err)
+		return
+	}
+
+	if accrualInfo == nil {
+		logger.Log.Info("Order is not registered in accrual system",
+			zap.String("order", order.Number))
+		return
+	}
+
+	if _, err := applyAccrual(ctx, w.OrderDB, w.Events, order, accrualInfo); err != nil {
+		logger.Log.Error("Failed to update order from accrual",
+			zap.String("order", order.Number),
+			zap.Error(err))
+		tracing.RecordError(span, err)
+	}
+}
+
+// applyAccrual stores what the accrual system reported for order and reports
+// whether anything changed. Changes are published to events, if any.
+func applyAccrual(ctx context.Context, orderDB database.Order, publisher OrderEventPublisher, order models.Order, info *dto.AccrualResponse) (bool, error) {
+	status := orderStatusFromAccrual(info.Status)
+	if order.Status == status && order.Accrual == info.Accrual {
+		return false, nil
+	}
+	if err := orderDB.UpdateOrderFromAccrual(ctx, order.Number, status, info.Accrual); err != nil {
+		return false, err
+	}
+
+	if publisher != nil {
+		publisher.Publish(events.OrderEvent{
+			UserID:  order.UserID,
+			Number:  order.Number,
+			Status:  status,
+			Accrual: info.Accrual,
+		})
+	}
+	return true, nil
+}
+
+func orderStatusFromAccrual(status string) string {
+	if status == "REGISTERED" {
+		return "PROCESSING"
+	}
+	return status
+}
@@ -1,90 +1,233 @@
 package services
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"time"
 	"unicode/utf8"
 
+	"go.uber.org/zap"
 	"golang.org/x/crypto/bcrypt"
 
 	"github.com/alisaviation/internal/database"
-
+	"github.com/alisaviation/internal/gophermart/dto"
 	"github.com/alisaviation/internal/gophermart/models"
+	"github.com/alisaviation/internal/tracing"
+	"github.com/alisaviation/pkg/logger"
 )
 
+const defaultRefreshTokenTTL = 30 * 24 * time.Hour
+
 var (
-	ErrLoginTaken         = errors.New("login already taken")
-	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrLoginTaken          = errors.New("login already taken")
+	ErrInvalidCredentials  = errors.New("invalid credentials")
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
 )
 
 type AuthStructService struct {
 	UserRepo   database.User
+	TokenRepo  database.Token
 	JwtService JWTServiceInterface
+	RefreshTTL time.Duration
 }
 
-func NewAuthService(userRepo database.User, jwtSecret []byte) AuthService {
+func NewAuthService(userRepo database.User, tokenRepo database.Token, jwtService JWTServiceInterface, refreshTTL time.Duration) AuthService {
+	if refreshTTL <= 0 {
+		refreshTTL = defaultRefreshTokenTTL
+	}
 	return &AuthStructService{
 		UserRepo:   userRepo,
-		JwtService: NewJWTService(jwtSecret, "gophermart"),
+		TokenRepo:  tokenRepo,
+		JwtService: jwtService,
+		RefreshTTL: refreshTTL,
 	}
 }
 
-func (s *AuthStructService) Register(login, password string) (string, error) {
+func (s *AuthStructService) Register(ctx context.Context, login, password string) (*dto.AuthTokens, error) {
+	ctx, span := tracing.Start(ctx, "AuthStructService.Register")
+	defer span.End()
+
 	if password == "" {
-		return "", fmt.Errorf("password cannot be empty")
+		return nil, fmt.Errorf("password cannot be empty")
 	}
 	if !utf8.ValidString(password) {
-		return "", fmt.Errorf("password contains invalid UTF-8 sequences")
+		return nil, fmt.Errorf("password contains invalid UTF-8 sequences")
 	}
-	existingUser, err := s.UserRepo.GetUserByLogin(login)
+	existingUser, err := s.UserRepo.GetUserByLogin(ctx, login)
 	if err != nil {
-		return "", fmt.Errorf("failed to check user existence: %w", err)
+		return nil, fmt.Errorf("failed to check user existence: %w", err)
 	}
 
 	if existingUser != nil {
-		return "", ErrLoginTaken
+		return nil, ErrLoginTaken
 	}
 
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
-		return "", fmt.Errorf("password hashing failed: %w", err)
+		return nil, fmt.Errorf("password hashing failed: %w", err)
 	}
 
 	user := models.User{
 		Login:        login,
 		PasswordHash: string(hashedPassword),
 	}
-	id, err := s.UserRepo.CreateUser(user)
-	if err != nil {
-		return "", fmt.Errorf("user creation failed: %w", err)
+	id, err := s.UserRepo.CreateUser(ctx, user)
+	if errors.Is(err, database.ErrUserExists) {
+		return nil, ErrLoginTaken
 	}
-
-	token, err := s.JwtService.GenerateToken(id, user.Login)
 	if err != nil {
-		return "", fmt.Errorf("failed to generate token: %w", err)
+		return nil, fmt.Errorf("user creation failed: %w", err)
 	}
-	return token, nil
+
+	return s.startSession(ctx, id, user.Login)
 }
 
-func (s *AuthStructService) Login(login, password string) (string, error) {
+func (s *AuthStructService) Login(ctx context.Context, login, password string) (*dto.AuthTokens, error) {
+	ctx, span := tracing.Start(ctx, "AuthStructService.Login")
+	defer span.End()
+
 	if login == "" || password == "" {
-		return "", ErrInvalidCredentials
+		return nil, ErrInvalidCredentials
 	}
 
-	user, err := s.UserRepo.GetUserByLogin(login)
+	user, err := s.UserRepo.GetUserByLogin(ctx, login)
 	if err != nil {
-		return "", fmt.Errorf("failed to get user: %w", err)
+		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
 	if user == nil {
-		return "", ErrInvalidCredentials
+		return nil, ErrInvalidCredentials
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
-		return "", ErrInvalidCredentials
+		return nil, ErrInvalidCredentials
 	}
-	token, err := s.JwtService.GenerateToken(user.ID, user.Login)
+
+	return s.startSession(ctx, user.ID, user.Login)
+}
+
+func (s *AuthStructService) Refresh(ctx context.Context, refreshToken string) (*dto.AuthTokens, error) {
+	ctx, span := tracing.Start(ctx, "AuthStructService.Refresh")
+	defer span.End()
+
+	if refreshToken == "" {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	oldHash := hashRefreshToken(refreshToken)
+	stored, err := s.TokenRepo.GetRefreshToken(ctx, oldHash)
 	if err != nil {
-		return "", fmt.Errorf("failed to generate token: %w", err)
+		return nil, fmt.Errorf("failed to get refresh token: %w", err)
+	}
+	if stored == nil || stored.SessionRevoked || time.Now().After(stored.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
 	}
-	return token, nil
+	if stored.UsedAt != nil {
+		return nil, s.revokeReusedSession(ctx, stored)
+	}
+
+	raw, next, err := s.newRefreshToken(stored.UserID, stored.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.TokenRepo.RotateRefreshToken(ctx, oldHash, next); err != nil {
+		if errors.Is(err, database.ErrRefreshTokenUsed) {
+			return nil, s.revokeReusedSession(ctx, stored)
+		}
+		if errors.Is(err, database.ErrSessionRevoked) {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+
+	access, err := s.JwtService.GenerateAccessToken(stored.UserID, stored.Login, stored.SessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	return &dto.AuthTokens{AccessToken: access, RefreshToken: raw}, nil
+}
+
+func (s *AuthStructService) Logout(ctx context.Context, claims *Claims) error {
+	ctx, span := tracing.Start(ctx, "AuthStructService.Logout")
+	defer span.End()
+
+	if claims.SessionID != "" {
+		if err := s.TokenRepo.RevokeSession(ctx, claims.SessionID); err != nil {
+			return fmt.Errorf("failed to revoke session: %w", err)
+		}
+	}
+
+	// Tokens without a jti cannot be revoked one by one; revoking "" would
+	// revoke all of them.
+	if claims.ID == "" {
+		return nil
+	}
+
+	expiresAt := time.Now().Add(defaultAccessTokenTTL)
+	if claims.ExpiresAt != nil {
+		expiresAt = claims.ExpiresAt.Time
+	}
+	if err := s.TokenRepo.RevokeAccessToken(ctx, claims.ID, expiresAt); err != nil {
+		return fmt.Errorf("failed to revoke access token: %w", err)
+	}
+	return nil
+}
+
+func (s *AuthStructService) startSession(ctx context.Context, userID int, login string) (*dto.AuthTokens, error) {
+	sessionID, err := randomHex(16)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate session id: %w", err)
+	}
+	if err := s.TokenRepo.CreateSession(ctx, sessionID, userID); err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+
+	raw, refresh, err := s.newRefreshToken(userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.TokenRepo.CreateRefreshToken(ctx, refresh); err != nil {
+		return nil, fmt.Errorf("failed to store refresh token: %w", err)
+	}
+
+	access, err := s.JwtService.GenerateAccessToken(userID, login, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	return &dto.AuthTokens{AccessToken: access, RefreshToken: raw}, nil
+}
+
+func (s *AuthStructService) newRefreshToken(userID int, sessionID string) (string, *models.RefreshToken, error) {
+	raw, err := randomHex(32)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+
+	return raw, &models.RefreshToken{
+		UserID:    userID,
+		SessionID: sessionID,
+		TokenHash: hashRefreshToken(raw),
+		ExpiresAt: time.Now().Add(s.RefreshTTL),
+	}, nil
+}
+
+func (s *AuthStructService) revokeReusedSession(ctx context.Context, token *models.RefreshToken) error {
+	logger.FromContext(ctx).Warn("Refresh token reuse detected, revoking session",
+		zap.Int("userID", token.UserID),
+		zap.String("session", token.SessionID))
+
+	if err := s.TokenRepo.RevokeSession(ctx, token.SessionID); err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	return ErrRefreshTokenReused
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
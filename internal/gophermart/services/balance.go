@@ -1,6 +1,8 @@
 package services
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -11,19 +13,24 @@ import (
 	"github.com/alisaviation/internal/database"
 	"github.com/alisaviation/internal/gophermart/dto"
 	"github.com/alisaviation/internal/gophermart/models"
+	"github.com/alisaviation/internal/tracing"
 	"github.com/alisaviation/pkg/logger"
 )
 
 type BalancesService struct {
 	Balance database.Balance
+	Tx      database.Transactor
 }
 
-func NewBalanceService(balance database.Balance) BalanceService {
-	return &BalancesService{Balance: balance}
+func NewBalanceService(balance database.Balance, tx database.Transactor) BalanceService {
+	return &BalancesService{Balance: balance, Tx: tx}
 }
 
-func (s *BalancesService) GetUserBalance(userID int) (*dto.BalanceResponse, int, error) {
-	balance, err := s.Balance.GetBalance(userID)
+func (s *BalancesService) GetUserBalance(ctx context.Context, userID int) (*dto.BalanceResponse, int, error) {
+	ctx, span := tracing.Start(ctx, "BalancesService.GetUserBalance")
+	defer span.End()
+
+	balance, err := s.Balance.GetBalance(ctx, userID)
 	if err != nil {
 		return nil, http.StatusInternalServerError, fmt.Errorf("failed to get balance: %w", err)
 	}
@@ -36,24 +43,11 @@ func (s *BalancesService) GetUserBalance(userID int) (*dto.BalanceResponse, int,
 	return response, http.StatusOK, nil
 }
 
-func (s *BalancesService) CreateWithdrawal(withdrawal *models.Withdrawal) error {
-	exists, err := s.Balance.WithdrawalExists(withdrawal.OrderNumber)
-	if err != nil {
-		return fmt.Errorf("failed to check withdrawal existence: %w", err)
-	}
-	if exists {
-		return fmt.Errorf("withdrawal for order %s already exists", withdrawal.OrderNumber)
-	}
+func (s *BalancesService) GetUserWithdrawals(ctx context.Context, userID int) ([]dto.WithdrawalResponse, int, error) {
+	ctx, span := tracing.Start(ctx, "BalancesService.GetUserWithdrawals")
+	defer span.End()
 
-	if err := s.Balance.CreateWithdrawal(withdrawal); err != nil {
-		return fmt.Errorf("failed to create withdrawal: %w", err)
-	}
-
-	return nil
-}
-
-func (s *BalancesService) GetUserWithdrawals(userID int) ([]dto.WithdrawalResponse, int, error) {
-	withdrawals, err := s.Balance.GetWithdrawals(userID)
+	withdrawals, err := s.Balance.GetWithdrawals(ctx, userID)
 	if err != nil {
 		return nil, http.StatusInternalServerError, fmt.Errorf("failed to get withdrawals: %w", err)
 	}
@@ -74,11 +68,17 @@ func (s *BalancesService) GetUserWithdrawals(userID int) ([]dto.WithdrawalRespon
 	return response, http.StatusOK, nil
 }
 
-func (s *BalancesService) WithdrawalExists(orderNumber string) (bool, error) {
-	return s.Balance.WithdrawalExists(orderNumber)
+func (s *BalancesService) WithdrawalExists(ctx context.Context, orderNumber string) (bool, error) {
+	ctx, span := tracing.Start(ctx, "BalancesService.WithdrawalExists")
+	defer span.End()
+
+	return s.Balance.WithdrawalExists(ctx, orderNumber)
 }
 
-func (s *BalancesService) GetWithdrawal(req dto.WithdrawRequest, userID int) (int, *models.Withdrawal, error) {
+func (s *BalancesService) GetWithdrawal(ctx context.Context, req dto.WithdrawRequest, userID int) (int, *models.Withdrawal, error) {
+	ctx, span := tracing.Start(ctx, "BalancesService.GetWithdrawal")
+	defer span.End()
+
 	if _, err := strconv.Atoi(req.Order); err != nil {
 		return http.StatusUnprocessableEntity, nil, fmt.Errorf("invalid order number format")
 	}
@@ -87,25 +87,8 @@ func (s *BalancesService) GetWithdrawal(req dto.WithdrawRequest, userID int) (in
 		return http.StatusUnprocessableEntity, nil, fmt.Errorf("invalid order number")
 	}
 
-	exists, err := s.WithdrawalExists(req.Order)
-	if err != nil {
-		return http.StatusInternalServerError, nil, fmt.Errorf("failed to check withdrawal existence: %w", err)
-	}
-	if exists {
-		return http.StatusConflict, nil, fmt.Errorf("withdrawal for this order already exists")
-	}
-
-	currentBalance, status, err := s.GetUserBalance(userID)
-	if err != nil {
-		return status, nil, fmt.Errorf("failed to check balance: %w", err)
-	}
-
-	if currentBalance.Current < req.Sum {
-		logger.Log.Warn("Insufficient funds",
-			zap.Int("userID", userID),
-			zap.Float64("available", currentBalance.Current),
-			zap.Float64("requested", req.Sum))
-		return http.StatusPaymentRequired, nil, fmt.Errorf("insufficient funds")
+	if req.Sum <= 0 {
+		return http.StatusUnprocessableEntity, nil, fmt.Errorf("withdrawal sum must be positive")
 	}
 
 	withdrawal := &models.Withdrawal{
@@ -115,8 +98,22 @@ func (s *BalancesService) GetWithdrawal(req dto.WithdrawRequest, userID int) (in
 		ProcessedAt: time.Now(),
 	}
 
-	if err := s.CreateWithdrawal(withdrawal); err != nil {
-		return http.StatusInternalServerError, nil, fmt.Errorf("failed to register withdrawal: %w", err)
+	err := runInTx(ctx, s.Tx, withdrawTx, func(ctx context.Context) error {
+		return s.Balance.Withdraw(ctx, withdrawal)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrInsufficientFunds):
+			logger.FromContext(ctx).Warn("Insufficient funds",
+				zap.Stringer("requested", req.Sum))
+			return http.StatusPaymentRequired, nil, fmt.Errorf("insufficient funds")
+		case errors.Is(err, database.ErrWithdrawalExists):
+			return http.StatusConflict, nil, fmt.Errorf("withdrawal for this order already exists")
+		case errors.Is(err, database.ErrInvalidSum):
+			return http.StatusUnprocessableEntity, nil, fmt.Errorf("withdrawal sum must be positive")
+		default:
+			return http.StatusInternalServerError, nil, fmt.Errorf("failed to register withdrawal: %w", err)
+		}
 	}
 
 	return http.StatusOK, withdrawal, nil
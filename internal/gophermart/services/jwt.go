@@ -1,6 +1,8 @@
 package services
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"time"
@@ -8,45 +10,97 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
+const defaultAccessTokenTTL = 15 * time.Minute
+
+// JWTService signs with Keys when set and falls back to an HS256 key
+// derived from SecretKey otherwise.
 type JWTService struct {
 	SecretKey []byte
 	Issuer    string
+	AccessTTL time.Duration
+	Keys      *KeySet
 }
 
 func NewJWTService(secret []byte, issuer string) *JWTService {
 	return &JWTService{
 		SecretKey: secret,
 		Issuer:    issuer,
+		AccessTTL: defaultAccessTokenTTL,
+	}
+}
+
+func NewJWTServiceWithKeys(keys *KeySet, issuer string) *JWTService {
+	return &JWTService{
+		Issuer:    issuer,
+		AccessTTL: defaultAccessTokenTTL,
+		Keys:      keys,
 	}
 }
 
+func (s *JWTService) keySet() *KeySet {
+	if s.Keys != nil {
+		return s.Keys
+	}
+	keys, _ := NewKeySet(NewHMACKey(s.SecretKey))
+	return keys
+}
+
 type Claims struct {
-	UserID int    `json:"user_id"`
-	Login  string `json:"login"`
+	UserID    int    `json:"user_id"`
+	Login     string `json:"login"`
+	SessionID string `json:"sid,omitempty"`
 	jwt.RegisteredClaims
 }
 
 func (s *JWTService) GenerateToken(userID int, login string) (string, error) {
+	return s.GenerateAccessToken(userID, login, "")
+}
+
+func (s *JWTService) GenerateAccessToken(userID int, login, sessionID string) (string, error) {
+	ttl := s.AccessTTL
+	if ttl <= 0 {
+		ttl = defaultAccessTokenTTL
+	}
+
+	jti, err := randomHex(16)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate token id: %w", err)
+	}
+
+	now := time.Now()
 	claims := &Claims{
-		UserID: userID,
-		Login:  login,
+		UserID:    userID,
+		Login:     login,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ID:        jti,
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(now),
 			Issuer:    s.Issuer,
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(s.SecretKey)
+	key := s.keySet().Current()
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Private)
 }
 
 func (s *JWTService) ValidateToken(tokenString string) (*Claims, error) {
+	keys := s.keySet()
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		// Tokens issued before key rotation was introduced carry no kid.
+		key := keys.Current()
+		if kid, ok := token.Header["kid"].(string); ok {
+			var err error
+			if key, err = keys.Lookup(kid); err != nil {
+				return nil, err
+			}
+		}
+		if token.Method.Alg() != key.Method.Alg() {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		return s.SecretKey, nil
+		return key.Public, nil
 	})
 
 	if err != nil {
@@ -64,3 +118,11 @@ func (s *JWTService) ValidateToken(tokenString string) (*Claims, error) {
 
 	return claims, nil
 }
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,221 @@
+package services
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// SigningKey is a single key identified by kid. Verify-only keys, such as
+// the public half of a retired key pair, have a nil Private.
+type SigningKey struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private interface{}
+	Public  interface{}
+}
+
+type KeySet struct {
+	current *SigningKey
+	keys    map[string]*SigningKey
+	order   []string
+}
+
+// NewKeySet signs with current and keeps validating tokens issued with any
+// of the previous keys until they are removed from configuration.
+func NewKeySet(current *SigningKey, previous ...*SigningKey) (*KeySet, error) {
+	if current == nil || current.Private == nil {
+		return nil, errors.New("current signing key must have a private key")
+	}
+
+	ks := &KeySet{
+		current: current,
+		keys:    make(map[string]*SigningKey),
+	}
+	for _, key := range append([]*SigningKey{current}, previous...) {
+		if _, ok := ks.keys[key.ID]; ok {
+			continue
+		}
+		ks.keys[key.ID] = key
+		ks.order = append(ks.order, key.ID)
+	}
+	return ks, nil
+}
+
+func (ks *KeySet) Current() *SigningKey {
+	return ks.current
+}
+
+func (ks *KeySet) Lookup(kid string) (*SigningKey, error) {
+	key, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, kid)
+	}
+	return key, nil
+}
+
+func NewHMACKey(secret []byte) *SigningKey {
+	sum := sha256.Sum256(secret)
+	return &SigningKey{
+		ID:      hex.EncodeToString(sum[:8]),
+		Method:  jwt.SigningMethodHS256,
+		Private: secret,
+		Public:  secret,
+	}
+}
+
+func NewRSAKey(private *rsa.PrivateKey) (*SigningKey, error) {
+	key, err := newPublicKey(&private.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	key.Private = private
+	return key, nil
+}
+
+func NewEd25519Key(private ed25519.PrivateKey) (*SigningKey, error) {
+	key, err := newPublicKey(private.Public())
+	if err != nil {
+		return nil, err
+	}
+	key.Private = private
+	return key, nil
+}
+
+func newPublicKey(public crypto.PublicKey) (*SigningKey, error) {
+	var method jwt.SigningMethod
+	switch public.(type) {
+	case *rsa.PublicKey:
+		method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", public)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal public key: %w", err)
+	}
+	sum := sha256.Sum256(der)
+
+	return &SigningKey{
+		ID:     hex.EncodeToString(sum[:8]),
+		Method: method,
+		Public: public,
+	}, nil
+}
+
+// ParsePrivateKeyPEM accepts PKCS#1 RSA keys and PKCS#8 RSA or Ed25519 keys.
+func ParsePrivateKeyPEM(data []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	if block.Type == "RSA PRIVATE KEY" {
+		private, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse RSA private key: %w", err)
+		}
+		return NewRSAKey(private)
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+	switch private := parsed.(type) {
+	case *rsa.PrivateKey:
+		return NewRSAKey(private)
+	case ed25519.PrivateKey:
+		return NewEd25519Key(private)
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", parsed)
+	}
+}
+
+// ParsePublicKeyPEM accepts PKIX public keys and certificates.
+func ParsePublicKeyPEM(data []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	if block.Type == "CERTIFICATE" {
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate: %w", err)
+		}
+		return newPublicKey(cert.PublicKey)
+	}
+
+	public, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+	return newPublicKey(public)
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS publishes the asymmetric keys of the set. Shared HMAC secrets are
+// never exposed, so an HS256-only set yields an empty key list.
+func (ks *KeySet) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	for _, kid := range ks.order {
+		key := ks.keys[kid]
+		switch public := key.Public.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "RSA",
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				Kid: key.ID,
+				N:   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "OKP",
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				Kid: key.ID,
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(public),
+			})
+		}
+	}
+	return set
+}
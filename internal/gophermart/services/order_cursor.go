@@ -0,0 +1,85 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alisaviation/internal/database"
+	"github.com/alisaviation/internal/gophermart/models"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// OrderListQuery is a page request for the order history. The zero value
+// lists every order, newest first.
+type OrderListQuery struct {
+	Statuses  []string
+	From      time.Time
+	To        time.Time
+	Ascending bool
+	Cursor    string
+	Limit     int
+}
+
+// OrderPage holds one page of orders. NextCursor is empty on the last page.
+type OrderPage struct {
+	Orders     []models.Order
+	NextCursor string
+}
+
+// Cursors are opaque to clients; they only need to hand them back unchanged.
+// A cursor records the sort direction and a digest of the filters it was
+// issued for, so that reusing it with a different query fails instead of
+// skipping or repeating orders.
+func encodeOrderCursor(c *database.OrderCursor, query OrderListQuery) string {
+	raw := fmt.Sprintf("%d:%d:%s", c.UploadedAt.UnixNano(), c.ID, orderQueryDigest(query))
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeOrderCursor(cursor string, query OrderListQuery) (*database.OrderCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	parts := strings.SplitN(string(raw), ":", 3)
+	if len(parts) != 3 {
+		return nil, ErrInvalidCursor
+	}
+	n, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	orderID, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if parts[2] != orderQueryDigest(query) {
+		return nil, fmt.Errorf("%w: it was issued for a different sort or filter", ErrInvalidCursor)
+	}
+	return &database.OrderCursor{UploadedAt: time.Unix(0, n), ID: orderID}, nil
+}
+
+func orderQueryDigest(query OrderListQuery) string {
+	statuses := slices.Clone(query.Statuses)
+	slices.Sort(statuses)
+	statuses = slices.Compact(statuses)
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%t|%s|%s|%s", query.Ascending, strings.Join(statuses, ","),
+		formatCursorTime(query.From), formatCursorTime(query.To))
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+func formatCursorTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
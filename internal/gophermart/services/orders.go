@@ -11,23 +11,68 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/alisaviation/internal/database"
-	"github.com/alisaviation/internal/database/postgres"
+	"github.com/alisaviation/internal/gophermart/dto"
 	"github.com/alisaviation/internal/gophermart/models"
+	"github.com/alisaviation/internal/tracing"
 	"github.com/alisaviation/pkg/logger"
 )
 
+var ErrOrderNotFound = errors.New("order not found")
+
 type OrdersService struct {
 	OrderDB       database.Order
+	Tx            database.Transactor
 	AccrualClient AccrualClientInterface
+	Events        OrderEventPublisher
 }
 
-func NewOrderService(orderDB database.Order, accrualClient AccrualClientInterface) OrderService {
+// events may be nil when nobody listens for order changes.
+func NewOrderService(orderDB database.Order, tx database.Transactor, accrualClient AccrualClientInterface, events OrderEventPublisher) OrderService {
 	return &OrdersService{
 		OrderDB:       orderDB,
+		Tx:            tx,
 		AccrualClient: accrualClient,
+		Events:        events,
+	}
+}
+func (s *OrdersService) UploadOrder(ctx context.Context, userID int, orderNumber string) (int, error) {
+	ctx, span := tracing.Start(ctx, "OrdersService.UploadOrder")
+	defer span.End()
+
+	if status, err := checkOrderNumber(orderNumber); err != nil {
+		return status, err
+	}
+
+	status := http.StatusInternalServerError
+	err := runInTx(ctx, s.Tx, uploadOrderTx, func(ctx context.Context) error {
+		order, st, err := s.getOrderByNumber(ctx, userID, orderNumber)
+		status = st
+		if err != nil || order == nil {
+			return err
+		}
+
+		if err := s.OrderDB.CreateOrder(ctx, order); err != nil {
+			status = http.StatusInternalServerError
+			return err
+		}
+		return nil
+	})
+	if errors.Is(err, database.ErrOrderExists) {
+		// Lost a race with a concurrent upload of the same number.
+		_, status, err = s.getOrderByNumber(ctx, userID, orderNumber)
+		return status, err
+	}
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to upload order",
+			zap.String("order", orderNumber),
+			zap.Error(err))
+		return status, err
 	}
+
+	return status, nil
 }
-func (s *OrdersService) UploadOrder(userID int, orderNumber string) (int, error) {
+
+func checkOrderNumber(orderNumber string) (int, error) {
 	if _, err := strconv.Atoi(orderNumber); err != nil {
 		return http.StatusBadRequest, errors.New("order number must contain only digits")
 	}
@@ -35,34 +80,90 @@ func (s *OrdersService) UploadOrder(userID int, orderNumber string) (int, error)
 	if !ValidateOrderNumber(orderNumber) {
 		return http.StatusUnprocessableEntity, errors.New("invalid order number by Luhn algorithm")
 	}
+	return 0, nil
+}
 
-	order, status, err := s.getOrderByNumber(userID, orderNumber)
-	if err != nil {
-		logger.Log.Error("Failed to check existing order",
-			zap.String("order", orderNumber),
-			zap.Error(err))
-		return status, err
+// UploadOrders uploads a batch of order numbers with one storage call and
+// reports the outcome of each number in input order. A number repeated in
+// the batch is reported as already uploaded after its first occurrence.
+func (s *OrdersService) UploadOrders(ctx context.Context, userID int, orderNumbers []string) ([]dto.BatchOrderResult, error) {
+	ctx, span := tracing.Start(ctx, "OrdersService.UploadOrders")
+	defer span.End()
+
+	results := make([]dto.BatchOrderResult, len(orderNumbers))
+	first := make(map[string]int, len(orderNumbers))
+	var orders []models.Order
+	now := time.Now()
+
+	for i, number := range orderNumbers {
+		results[i].Number = number
+		if _, err := checkOrderNumber(number); err != nil {
+			results[i].Result = dto.BatchOrderInvalid
+			results[i].Error = err.Error()
+			continue
+		}
+		if _, ok := first[number]; ok {
+			continue
+		}
+		first[number] = i
+		orders = append(orders, models.Order{UserID: userID, Number: number, Status: "NEW", UploadedAt: now})
 	}
 
-	if order == nil && status == http.StatusOK {
-		return status, nil
+	if len(orders) > 0 {
+		inserted, err := s.OrderDB.CreateOrders(ctx, orders)
+		if err != nil {
+			logger.FromContext(ctx).Error("Failed to upload order batch",
+				zap.Int("orders", len(orders)),
+				zap.Error(err))
+			return nil, fmt.Errorf("failed to upload orders: %w", err)
+		}
+
+		for _, result := range inserted {
+			outcome, err := s.batchOutcome(ctx, userID, result)
+			if err != nil {
+				return nil, err
+			}
+			results[first[result.Number]].Result = outcome
+		}
 	}
 
-	err = s.OrderDB.CreateOrder(order)
-	if err != nil {
-		logger.Log.Error("Failed to create order",
-			zap.String("order", orderNumber),
-			zap.Error(err))
-		return http.StatusInternalServerError, err
+	for i := range results {
+		if results[i].Result != "" {
+			continue
+		}
+		results[i].Result = results[first[results[i].Number]].Result
+		if results[i].Result == dto.BatchOrderAccepted {
+			results[i].Result = dto.BatchOrderAlreadyUploaded
+		}
 	}
+	return results, nil
+}
 
-	return http.StatusAccepted, nil
+func (s *OrdersService) batchOutcome(ctx context.Context, userID int, result database.OrderInsertResult) (string, error) {
+	if result.Inserted {
+		return dto.BatchOrderAccepted, nil
+	}
+
+	ownerID := result.OwnerID
+	if ownerID == 0 {
+		// Taken by an upload that committed while the batch was inserted.
+		order, err := s.OrderDB.GetOrderByNumber(ctx, result.Number)
+		if err != nil {
+			return "", fmt.Errorf("failed to check order: %w", err)
+		}
+		ownerID = order.UserID
+	}
+
+	if ownerID == userID {
+		return dto.BatchOrderAlreadyUploaded, nil
+	}
+	return dto.BatchOrderConflict, nil
 }
 
-func (s *OrdersService) getOrderByNumber(userID int, orderNumber string) (*models.Order, int, error) {
-	existingOrder, err := s.OrderDB.GetOrderByNumber(orderNumber)
-	if err != nil && !errors.Is(err, postgres.ErrNotFound) {
-		logger.Log.Error("Failed to check existing order",
+func (s *OrdersService) getOrderByNumber(ctx context.Context, userID int, orderNumber string) (*models.Order, int, error) {
+	existingOrder, err := s.OrderDB.GetOrderByNumber(ctx, orderNumber)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		logger.FromContext(ctx).Error("Failed to check existing order",
 			zap.String("order", orderNumber),
 			zap.Error(err))
 		return nil, http.StatusInternalServerError, fmt.Errorf("failed to check order: %w", err)
@@ -83,50 +184,107 @@ func (s *OrdersService) getOrderByNumber(userID int, orderNumber string) (*model
 	}, http.StatusAccepted, nil
 }
 
-func (s *OrdersService) GetOrders(userID int) ([]models.Order, error) {
-	orders, err := s.OrderDB.GetOrdersByUser(userID)
+// GetOrder returns an order owned by userID. Another user's order is
+// reported as ErrOrderNotFound so that callers cannot probe ownership.
+//
+// With refresh the accrual system is asked for the order's state right away
+// instead of waiting for the worker. Orders in a final state are not
+// rechecked, and a failed check still returns the stored order.
+func (s *OrdersService) GetOrder(ctx context.Context, userID int, number string, refresh bool) (*models.Order, error) {
+	ctx, span := tracing.Start(ctx, "OrdersService.GetOrder")
+	defer span.End()
+
+	order, err := s.OrderDB.GetOrderByNumber(ctx, number)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, ErrOrderNotFound
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to get user orders: %w", err)
+		return nil, fmt.Errorf("failed to get order: %w", err)
+	}
+	if order.UserID != userID {
+		return nil, ErrOrderNotFound
 	}
 
-	for i, order := range orders {
-		if order.Status == "PROCESSED" || order.Status == "INVALID" {
-			continue
-		}
+	if !refresh || s.AccrualClient == nil || isFinalOrderStatus(order.Status) {
+		return order, nil
+	}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+	reqCtx, cancel := context.WithTimeout(ctx, accrualRequestTimeout)
+	defer cancel()
 
-		accrualInfo, err := s.AccrualClient.GetOrderAccrual(ctx, order.Number)
-		if err != nil {
-			logger.Log.Info("Failed to get accrual info",
-				zap.String("order", order.Number),
-				zap.Error(err))
-			continue
-		}
+	info, err := s.AccrualClient.GetOrderAccrual(reqCtx, number)
+	if err != nil {
+		logger.FromContext(ctx).Info("Failed to refresh order accrual",
+			zap.String("order", number),
+			zap.Error(err))
+		return order, nil
+	}
+	if info == nil {
+		return order, nil
+	}
 
-		if accrualInfo == nil {
-			logger.Log.Info("Заказ не найден в accrual системе",
-				zap.String("order", order.Number),
-				zap.Error(err))
-			continue
-		}
+	changed, err := applyAccrual(ctx, s.OrderDB, s.Events, *order, info)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update order: %w", err)
+	}
+	if !changed {
+		return order, nil
+	}
 
-		if order.Status != accrualInfo.Status || order.Accrual != accrualInfo.Accrual {
-			if err := s.OrderDB.UpdateOrderFromAccrual(
-				order.Number,
-				accrualInfo.Status,
-				accrualInfo.Accrual,
-			); err != nil {
-				logger.Log.Error("Failed to update order from accrual",
-					zap.String("order", order.Number),
-					zap.Error(err))
-				continue
-			}
+	order, err = s.OrderDB.GetOrderByNumber(ctx, number)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get order: %w", err)
+	}
+	return order, nil
+}
 
-			orders[i].Status = accrualInfo.Status
-			orders[i].Accrual = accrualInfo.Accrual
-		}
+func isFinalOrderStatus(status string) bool {
+	return status == "PROCESSED" || status == "INVALID"
+}
+
+func (s *OrdersService) GetOrders(ctx context.Context, userID int) ([]models.Order, error) {
+	ctx, span := tracing.Start(ctx, "OrdersService.GetOrders")
+	defer span.End()
+
+	orders, err := s.OrderDB.GetOrdersByUser(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user orders: %w", err)
 	}
 	return orders, nil
 }
+
+func (s *OrdersService) ListOrders(ctx context.Context, userID int, query OrderListQuery) (*OrderPage, error) {
+	ctx, span := tracing.Start(ctx, "OrdersService.ListOrders")
+	defer span.End()
+
+	q := database.OrderQuery{
+		UserID:    userID,
+		Statuses:  query.Statuses,
+		From:      query.From,
+		To:        query.To,
+		Ascending: query.Ascending,
+	}
+	if query.Cursor != "" {
+		after, err := decodeOrderCursor(query.Cursor, query)
+		if err != nil {
+			return nil, err
+		}
+		q.After = after
+	}
+	if query.Limit > 0 {
+		// One extra row tells whether there is a next page.
+		q.Limit = query.Limit + 1
+	}
+
+	orders, err := s.OrderDB.QueryOrders(ctx, q)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user orders: %w", err)
+	}
+
+	page := &OrderPage{Orders: orders}
+	if query.Limit > 0 && len(orders) > query.Limit {
+		page.Orders = orders[:query.Limit]
+		page.NextCursor = encodeOrderCursor(database.CursorOf(page.Orders[query.Limit-1]), query)
+	}
+	return page, nil
+}
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrRateLimited = errors.New("accrual system rate limit is in effect")
+
+type RateLimiter struct {
+	mu          sync.Mutex
+	interval    time.Duration
+	next        time.Time
+	pausedUntil time.Time
+}
+
+func NewRateLimiter(rps int) *RateLimiter {
+	l := &RateLimiter{}
+	if rps > 0 {
+		l.interval = time.Second / time.Duration(rps)
+	}
+	return l
+}
+
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		at := now
+		if l.pausedUntil.After(at) {
+			at = l.pausedUntil
+		}
+		if l.interval > 0 {
+			if l.next.After(at) {
+				at = l.next
+			}
+		}
+
+		if deadline, ok := ctx.Deadline(); ok && deadline.Before(at) {
+			l.mu.Unlock()
+			return ErrRateLimited
+		}
+
+		if !at.After(now) {
+			if l.interval > 0 {
+				l.next = now.Add(l.interval)
+			}
+			l.mu.Unlock()
+			return nil
+		}
+		l.mu.Unlock()
+
+		timer := time.NewTimer(at.Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (l *RateLimiter) Pause(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	until := time.Now().Add(d)
+	if until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+}
+
+func (l *RateLimiter) PausedUntil() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.pausedUntil
+}
+
+func ParseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	at, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if d := at.Sub(now); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
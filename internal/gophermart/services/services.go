@@ -4,29 +4,47 @@ import (
 	"context"
 
 	"github.com/alisaviation/internal/gophermart/dto"
+	"github.com/alisaviation/internal/gophermart/events"
 	"github.com/alisaviation/internal/gophermart/models"
 )
 
 type AuthService interface {
-	Register(login, password string) (string, error)
-	Login(login, password string) (string, error)
+	Register(ctx context.Context, login, password string) (*dto.AuthTokens, error)
+	Login(ctx context.Context, login, password string) (*dto.AuthTokens, error)
+	Refresh(ctx context.Context, refreshToken string) (*dto.AuthTokens, error)
+	Logout(ctx context.Context, claims *Claims) error
 }
 
 type BalanceService interface {
-	GetUserBalance(userID int) (*dto.BalanceResponse, int, error)
-	CreateWithdrawal(withdrawal *models.Withdrawal) error
-	GetUserWithdrawals(userID int) ([]dto.WithdrawalResponse, int, error)
-	WithdrawalExists(orderNumber string) (bool, error)
-	GetWithdrawal(req dto.WithdrawRequest, userID int) (int, *models.Withdrawal, error)
+	GetUserBalance(ctx context.Context, userID int) (*dto.BalanceResponse, int, error)
+	GetUserWithdrawals(ctx context.Context, userID int) ([]dto.WithdrawalResponse, int, error)
+	WithdrawalExists(ctx context.Context, orderNumber string) (bool, error)
+	GetWithdrawal(ctx context.Context, req dto.WithdrawRequest, userID int) (int, *models.Withdrawal, error)
 }
 
 type OrderService interface {
-	UploadOrder(userID int, orderNumber string) (int, error)
-	GetOrders(userID int) ([]models.Order, error)
+	UploadOrder(ctx context.Context, userID int, orderNumber string) (int, error)
+	UploadOrders(ctx context.Context, userID int, orderNumbers []string) ([]dto.BatchOrderResult, error)
+	GetOrder(ctx context.Context, userID int, number string, refresh bool) (*models.Order, error)
+	GetOrders(ctx context.Context, userID int) ([]models.Order, error)
+	ListOrders(ctx context.Context, userID int, query OrderListQuery) (*OrderPage, error)
+}
+
+type WebhookService interface {
+	CreateWebhook(ctx context.Context, userID int, req dto.WebhookRequest) (*models.Webhook, error)
+	GetWebhooks(ctx context.Context, userID int) ([]models.Webhook, error)
+	GetWebhook(ctx context.Context, userID int, id int64) (*models.Webhook, error)
+	UpdateWebhook(ctx context.Context, userID int, id int64, req dto.WebhookUpdateRequest) (*models.Webhook, error)
+	DeleteWebhook(ctx context.Context, userID int, id int64) error
+	GetWebhookDeliveries(ctx context.Context, userID int, id int64, limit int) ([]models.WebhookDelivery, error)
 }
 
 type JWTServiceInterface interface {
-	GenerateToken(userID int, login string) (string, error)
+	GenerateAccessToken(userID int, login, sessionID string) (string, error)
+}
+
+type OrderEventPublisher interface {
+	Publish(event events.OrderEvent)
 }
 
 type AccrualClientInterface interface {
@@ -0,0 +1,32 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/alisaviation/internal/database"
+)
+
+var (
+	// uploadOrderTx makes the check-then-insert of an order number
+	// serializable; a concurrent upload of the same number is retried and
+	// then sees the committed order.
+	uploadOrderTx = database.TxOptions{Isolation: sql.LevelSerializable}
+
+	// withdrawTx relies on the row lock taken on the balance, so the default
+	// isolation level is enough.
+	withdrawTx = database.TxOptions{Isolation: sql.LevelReadCommitted}
+
+	// createWebhookTx keeps concurrent creations from both passing the
+	// per-user limit.
+	createWebhookTx = database.TxOptions{Isolation: sql.LevelSerializable}
+)
+
+// runInTx runs fn inside a unit of work, or directly when the service was
+// built without a transactor.
+func runInTx(ctx context.Context, tx database.Transactor, opts database.TxOptions, fn func(ctx context.Context) error) error {
+	if tx == nil {
+		return fn(ctx)
+	}
+	return tx.WithinTx(ctx, opts, fn)
+}
@@ -0,0 +1,211 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/alisaviation/internal/database"
+	"github.com/alisaviation/internal/gophermart/dto"
+	"github.com/alisaviation/internal/gophermart/models"
+	"github.com/alisaviation/internal/tracing"
+)
+
+var (
+	ErrWebhookNotFound = errors.New("webhook not found")
+	ErrInvalidWebhook  = errors.New("invalid webhook")
+	ErrTooManyWebhooks = errors.New("too many webhooks")
+)
+
+const (
+	maxWebhooksPerUser     = 10
+	maxWebhookURLLength    = 2048
+	minWebhookSecretLength = 16
+	webhookSecretBytes     = 32
+)
+
+type WebhooksService struct {
+	WebhookDB database.Webhooks
+	Tx        database.Transactor
+}
+
+func NewWebhookService(webhookDB database.Webhooks, tx database.Transactor) WebhookService {
+	return &WebhooksService{
+		WebhookDB: webhookDB,
+		Tx:        tx,
+	}
+}
+
+// CreateWebhook registers a webhook. Without a secret a random one is
+// generated; the caller has to hand it to the user, it is not shown again.
+func (s *WebhooksService) CreateWebhook(ctx context.Context, userID int, req dto.WebhookRequest) (*models.Webhook, error) {
+	ctx, span := tracing.Start(ctx, "WebhooksService.CreateWebhook")
+	defer span.End()
+
+	webhook := &models.Webhook{
+		UserID:  userID,
+		URL:     req.URL,
+		Secret:  req.Secret,
+		Events:  req.Events,
+		Enabled: true,
+	}
+	if webhook.Secret == "" {
+		secret, err := randomHex(webhookSecretBytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate webhook secret: %w", err)
+		}
+		webhook.Secret = secret
+	}
+	if err := normalizeWebhook(webhook); err != nil {
+		return nil, err
+	}
+
+	err := runInTx(ctx, s.Tx, createWebhookTx, func(ctx context.Context) error {
+		existing, err := s.WebhookDB.GetWebhooksByUser(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if len(existing) >= maxWebhooksPerUser {
+			return ErrTooManyWebhooks
+		}
+		return s.WebhookDB.CreateWebhook(ctx, webhook)
+	})
+	if errors.Is(err, ErrTooManyWebhooks) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create webhook: %w", err)
+	}
+	return webhook, nil
+}
+
+func (s *WebhooksService) GetWebhooks(ctx context.Context, userID int) ([]models.Webhook, error) {
+	ctx, span := tracing.Start(ctx, "WebhooksService.GetWebhooks")
+	defer span.End()
+
+	webhooks, err := s.WebhookDB.GetWebhooksByUser(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhooks: %w", err)
+	}
+	return webhooks, nil
+}
+
+// GetWebhook returns a webhook owned by userID. Like orders, another user's
+// webhook is reported as not found.
+func (s *WebhooksService) GetWebhook(ctx context.Context, userID int, id int64) (*models.Webhook, error) {
+	ctx, span := tracing.Start(ctx, "WebhooksService.GetWebhook")
+	defer span.End()
+
+	webhook, err := s.WebhookDB.GetWebhook(ctx, id)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, ErrWebhookNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook: %w", err)
+	}
+	if webhook.UserID != userID {
+		return nil, ErrWebhookNotFound
+	}
+	return webhook, nil
+}
+
+// UpdateWebhook applies the fields present in req. Enabling a webhook resets
+// its failure count, which is how a webhook disabled for failing comes back.
+func (s *WebhooksService) UpdateWebhook(ctx context.Context, userID int, id int64, req dto.WebhookUpdateRequest) (*models.Webhook, error) {
+	ctx, span := tracing.Start(ctx, "WebhooksService.UpdateWebhook")
+	defer span.End()
+
+	webhook, err := s.GetWebhook(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.URL != nil {
+		webhook.URL = *req.URL
+	}
+	if req.Secret != nil {
+		webhook.Secret = *req.Secret
+	}
+	if req.Events != nil {
+		webhook.Events = *req.Events
+	}
+	if req.Enabled != nil && *req.Enabled != webhook.Enabled {
+		webhook.Enabled = *req.Enabled
+		webhook.ConsecutiveFailures = 0
+		webhook.DisabledAt = nil
+		if !webhook.Enabled {
+			now := time.Now()
+			webhook.DisabledAt = &now
+		}
+	}
+	if err := normalizeWebhook(webhook); err != nil {
+		return nil, err
+	}
+
+	err = s.WebhookDB.UpdateWebhook(ctx, webhook)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, ErrWebhookNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update webhook: %w", err)
+	}
+	return webhook, nil
+}
+
+func (s *WebhooksService) DeleteWebhook(ctx context.Context, userID int, id int64) error {
+	ctx, span := tracing.Start(ctx, "WebhooksService.DeleteWebhook")
+	defer span.End()
+
+	if _, err := s.GetWebhook(ctx, userID, id); err != nil {
+		return err
+	}
+
+	err := s.WebhookDB.DeleteWebhook(ctx, id)
+	if errors.Is(err, database.ErrNotFound) {
+		return ErrWebhookNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook: %w", err)
+	}
+	return nil
+}
+
+func (s *WebhooksService) GetWebhookDeliveries(ctx context.Context, userID int, id int64, limit int) ([]models.WebhookDelivery, error) {
+	ctx, span := tracing.Start(ctx, "WebhooksService.GetWebhookDeliveries")
+	defer span.End()
+
+	if _, err := s.GetWebhook(ctx, userID, id); err != nil {
+		return nil, err
+	}
+
+	deliveries, err := s.WebhookDB.GetWebhookDeliveries(ctx, id, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook deliveries: %w", err)
+	}
+	return deliveries, nil
+}
+
+func normalizeWebhook(webhook *models.Webhook) error {
+	if len(webhook.URL) > maxWebhookURLLength {
+		return fmt.Errorf("%w: url is too long", ErrInvalidWebhook)
+	}
+	u, err := url.Parse(webhook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: url must be an absolute http or https URL", ErrInvalidWebhook)
+	}
+
+	if len(webhook.Secret) < minWebhookSecretLength {
+		return fmt.Errorf("%w: secret must be at least %d characters", ErrInvalidWebhook, minWebhookSecretLength)
+	}
+
+	for _, event := range webhook.Events {
+		if !slices.Contains(database.WebhookEventTypes, event) {
+			return fmt.Errorf("%w: unknown event %q", ErrInvalidWebhook, event)
+		}
+	}
+	webhook.Events = slices.Compact(slices.Sorted(slices.Values(webhook.Events)))
+	return nil
+}
@@ -0,0 +1,264 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/alisaviation/internal/database"
+	"github.com/alisaviation/internal/gophermart/models"
+	"github.com/alisaviation/internal/gophermart/outbox"
+	"github.com/alisaviation/pkg/logger"
+)
+
+const (
+	HeaderEvent     = "X-Gophermart-Event"
+	HeaderEventID   = "X-Gophermart-Event-ID"
+	HeaderTimestamp = "X-Gophermart-Timestamp"
+	HeaderSignature = "X-Gophermart-Signature"
+)
+
+const (
+	defaultPollInterval = time.Second
+	defaultBatchSize    = 100
+	defaultWorkers      = 4
+	defaultMinBackoff   = 10 * time.Second
+	defaultMaxBackoff   = time.Hour
+	defaultMaxAttempts  = 10
+	defaultDisableAfter = 20
+	deliveryTimeout     = 10 * time.Second
+)
+
+// Payload is the JSON body of a webhook request. ID is the same for every
+// attempt of an event, so receivers can drop duplicates.
+type Payload struct {
+	ID   int64           `json:"id"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Sign returns the signature header value for body sent at timestamp: the
+// hex HMAC-SHA256 of "<timestamp>.<body>" keyed with the webhook secret.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sender POSTs queued deliveries. Only a 2xx answer counts as delivered;
+// failures are retried with exponential backoff until MaxAttempts, and a
+// webhook that fails DisableAfter attempts in a row is disabled until its
+// owner enables it again.
+type Sender struct {
+	Store        database.Webhooks
+	Client       *http.Client
+	Workers      int
+	PollInterval time.Duration
+	BatchSize    int
+	MinBackoff   time.Duration
+	MaxBackoff   time.Duration
+	MaxAttempts  int
+	DisableAfter int
+}
+
+func NewSender(store database.Webhooks, pollInterval time.Duration) *Sender {
+	if pollInterval <= 0 {
+		pollInterval = defaultPollInterval
+	}
+	return &Sender{
+		Store: store,
+		Client: &http.Client{
+			Timeout:   deliveryTimeout,
+			Transport: publicTransport(),
+			// A redirect is an answer like any other non-2xx one.
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		Workers:      defaultWorkers,
+		PollInterval: pollInterval,
+		BatchSize:    defaultBatchSize,
+		MinBackoff:   defaultMinBackoff,
+		MaxBackoff:   defaultMaxBackoff,
+		MaxAttempts:  defaultMaxAttempts,
+		DisableAfter: defaultDisableAfter,
+	}
+}
+
+func (s *Sender) Run(ctx context.Context) {
+	logger.Log.Info("Starting webhook sender",
+		zap.Int("workers", s.Workers),
+		zap.Duration("poll_interval", s.PollInterval))
+
+	ticker := time.NewTicker(s.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.ProcessPending(ctx); err != nil {
+			logger.Log.Error("Failed to send webhooks", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			logger.Log.Info("Webhook sender stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessPending sends one batch of due deliveries. Deliveries of a webhook
+// are sent one after another, and the rest of its batch is skipped after a
+// failure so a dead endpoint is not hit again right away.
+func (s *Sender) ProcessPending(ctx context.Context) error {
+	deliveries, err := s.Store.PendingWebhookDeliveries(ctx, s.BatchSize)
+	if err != nil {
+		return fmt.Errorf("failed to get pending webhook deliveries: %w", err)
+	}
+
+	var order []int64
+	byWebhook := make(map[int64][]models.WebhookDelivery)
+	for _, delivery := range deliveries {
+		if _, ok := byWebhook[delivery.WebhookID]; !ok {
+			order = append(order, delivery.WebhookID)
+		}
+		byWebhook[delivery.WebhookID] = append(byWebhook[delivery.WebhookID], delivery)
+	}
+
+	jobs := make(chan []models.WebhookDelivery)
+	var wg sync.WaitGroup
+	for i := 0; i < max(s.Workers, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range jobs {
+				s.processWebhook(ctx, batch)
+			}
+		}()
+	}
+
+	for _, id := range order {
+		select {
+		case <-ctx.Done():
+			close(jobs)
+			wg.Wait()
+			return nil
+		case jobs <- byWebhook[id]:
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	return nil
+}
+
+func (s *Sender) processWebhook(ctx context.Context, deliveries []models.WebhookDelivery) {
+	webhook, err := s.Store.GetWebhook(ctx, deliveries[0].WebhookID)
+	if err != nil {
+		// Deleted since the batch was read; its deliveries went with it.
+		if !errors.Is(err, database.ErrNotFound) {
+			logger.Log.Error("Failed to get webhook",
+				zap.Int64("webhook_id", deliveries[0].WebhookID),
+				zap.Error(err))
+		}
+		return
+	}
+
+	for _, delivery := range deliveries {
+		if ctx.Err() != nil || !s.send(ctx, webhook, delivery) {
+			return
+		}
+	}
+}
+
+// send makes one attempt and records it. It reports whether the webhook
+// should be tried again in this batch.
+func (s *Sender) send(ctx context.Context, webhook *models.Webhook, delivery models.WebhookDelivery) bool {
+	code, err := s.post(ctx, webhook, delivery)
+
+	attempt := models.WebhookAttempt{
+		DeliveryID:   delivery.ID,
+		Succeeded:    err == nil,
+		ResponseCode: code,
+		AttemptedAt:  time.Now(),
+	}
+	if err != nil {
+		attempt.Error = err.Error()
+		if delivery.Attempts+1 < s.MaxAttempts {
+			attempt.NextAttemptAt = attempt.AttemptedAt.Add(outbox.Backoff(s.MinBackoff, s.MaxBackoff, delivery.Attempts))
+		}
+		logger.Log.Info("Failed to send webhook",
+			zap.Int64("webhook_id", webhook.ID),
+			zap.Int64("delivery_id", delivery.ID),
+			zap.Int("attempts", delivery.Attempts+1),
+			zap.Bool("gave_up", attempt.NextAttemptAt.IsZero()),
+			zap.Error(err))
+	}
+
+	disabled, recordErr := s.Store.RecordWebhookAttempt(ctx, attempt, s.DisableAfter)
+	if recordErr != nil {
+		logger.Log.Error("Failed to record webhook attempt",
+			zap.Int64("delivery_id", delivery.ID),
+			zap.Error(recordErr))
+		return false
+	}
+	if disabled {
+		logger.Log.Warn("Webhook disabled after repeated failures",
+			zap.Int64("webhook_id", webhook.ID),
+			zap.Int("userID", webhook.UserID),
+			zap.String("url", webhook.URL))
+	}
+	return err == nil
+}
+
+func (s *Sender) post(ctx context.Context, webhook *models.Webhook, delivery models.WebhookDelivery) (int, error) {
+	body, err := json.Marshal(Payload{
+		ID:   delivery.EventID,
+		Type: delivery.EventType,
+		Data: delivery.Payload,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	reqCtx, cancel := context.WithTimeout(ctx, deliveryTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "gophermart-webhooks")
+	req.Header.Set(HeaderEvent, delivery.EventType)
+	req.Header.Set(HeaderEventID, strconv.FormatInt(delivery.EventID, 10))
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(webhook.Secret, timestamp, body))
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,71 @@
+// Package webhooks delivers domain events to the callback URLs users
+// register. Events reach it through the outbox relay, are queued per webhook
+// and sent by the Sender with retries.
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+
+	"go.uber.org/zap"
+
+	"github.com/alisaviation/internal/database"
+	"github.com/alisaviation/internal/gophermart/models"
+	"github.com/alisaviation/internal/gophermart/outbox"
+	"github.com/alisaviation/pkg/logger"
+)
+
+// EventSink is an outbox sink that queues events for the webhooks of their
+// user. The relay may offer an event again; the store ignores events a
+// webhook already has, so every webhook gets each event once.
+type EventSink struct {
+	Store database.Webhooks
+}
+
+func NewEventSink(store database.Webhooks) *EventSink {
+	return &EventSink{Store: store}
+}
+
+func (s *EventSink) Deliver(ctx context.Context, msg outbox.Message) error {
+	eventType, ok := webhookEventType(msg)
+	if !ok {
+		return nil
+	}
+
+	_, err := s.Store.EnqueueWebhookDeliveries(ctx, msg.UserID, models.WebhookDelivery{
+		EventID:   msg.ID,
+		EventType: eventType,
+		Payload:   msg.Payload,
+	})
+	return err
+}
+
+func (s *EventSink) Close() error {
+	return nil
+}
+
+// webhookEventType maps a domain event to the webhook event users subscribe
+// to. Orders only matter once they reach a final status.
+func webhookEventType(msg outbox.Message) (string, bool) {
+	switch msg.Type {
+	case database.EventOrderUpdated:
+		var order struct {
+			Status string `json:"status"`
+		}
+		if err := json.Unmarshal(msg.Payload, &order); err != nil {
+			logger.Log.Error("Failed to decode order event",
+				zap.Int64("event_id", msg.ID),
+				zap.Error(err))
+			return "", false
+		}
+		switch order.Status {
+		case "PROCESSED":
+			return database.WebhookOrderProcessed, true
+		case "INVALID":
+			return database.WebhookOrderInvalid, true
+		}
+	case database.EventWithdrawalCreated:
+		return database.WebhookWithdrawalCreated, true
+	}
+	return "", false
+}
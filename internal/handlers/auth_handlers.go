@@ -9,6 +9,7 @@ import (
 
 	"github.com/alisaviation/internal/gophermart/dto"
 	"github.com/alisaviation/internal/gophermart/services"
+	"github.com/alisaviation/internal/middleware"
 	"github.com/alisaviation/pkg/logger"
 )
 
@@ -28,19 +29,19 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := h.authService.Register(req.Login, req.Password)
+	tokens, err := h.authService.Register(r.Context(), req.Login, req.Password)
 	if err != nil {
 		switch {
 		case errors.Is(err, services.ErrLoginTaken):
 			respondWithError(w, http.StatusConflict, "Login already taken")
 		default:
-			logger.Log.Error("Registration failed", zap.Error(err))
+			logger.FromContext(r.Context()).Error("Registration failed", zap.Error(err))
 			respondWithError(w, http.StatusInternalServerError, "Registration failed")
 		}
 		return
 	}
 
-	respondWithToken(w, http.StatusOK, "User registered successfully", token)
+	respondWithTokens(w, http.StatusOK, "User registered successfully", tokens)
 }
 
 func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
@@ -50,17 +51,57 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := h.authService.Login(req.Login, req.Password)
+	tokens, err := h.authService.Login(r.Context(), req.Login, req.Password)
 	if err != nil {
 		switch {
 		case errors.Is(err, services.ErrInvalidCredentials):
 			respondWithError(w, http.StatusUnauthorized, "Invalid login or password")
 		default:
-			logger.Log.Error("Login failed", zap.Error(err))
+			logger.FromContext(r.Context()).Error("Login failed", zap.Error(err))
 			respondWithError(w, http.StatusInternalServerError, "Login failed")
 		}
 		return
 	}
 
-	respondWithToken(w, http.StatusOK, "Successfully authenticated", token)
+	respondWithTokens(w, http.StatusOK, "Successfully authenticated", tokens)
+}
+
+func (h *AuthHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+	var req dto.RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request")
+		return
+	}
+
+	tokens, err := h.authService.Refresh(r.Context(), req.RefreshToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidRefreshToken):
+			respondWithError(w, http.StatusUnauthorized, "Invalid refresh token")
+		case errors.Is(err, services.ErrRefreshTokenReused):
+			respondWithError(w, http.StatusUnauthorized, "Refresh token already used, session revoked")
+		default:
+			logger.FromContext(r.Context()).Error("Token refresh failed", zap.Error(err))
+			respondWithError(w, http.StatusInternalServerError, "Token refresh failed")
+		}
+		return
+	}
+
+	respondWithTokens(w, http.StatusOK, "Token refreshed", tokens)
+}
+
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.ClaimsKey).(*services.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	if err := h.authService.Logout(r.Context(), claims); err != nil {
+		logger.FromContext(r.Context()).Error("Logout failed", zap.Error(err))
+		respondWithError(w, http.StatusInternalServerError, "Logout failed")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
 }
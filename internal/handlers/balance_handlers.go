@@ -31,16 +31,15 @@ func (h *BalanceHandler) GetUserBalance(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	response, status, err := h.balanceService.GetUserBalance(userID)
+	response, status, err := h.balanceService.GetUserBalance(r.Context(), userID)
 	if err != nil {
-		logger.Log.Error("Failed to get user balance",
-			zap.Int("userID", userID),
+		logger.FromContext(r.Context()).Error("Failed to get user balance",
 			zap.Error(err))
 		http.Error(w, err.Error(), status)
 		return
 	}
 
-	writeJSONResponse(w, status, response, zap.Int("userID", userID))
+	writeJSONResponse(r.Context(), w, status, response)
 }
 
 func (h *BalanceHandler) Withdraw(w http.ResponseWriter, r *http.Request) {
@@ -52,23 +51,22 @@ func (h *BalanceHandler) Withdraw(w http.ResponseWriter, r *http.Request) {
 
 	var req dto.WithdrawRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		logger.Log.Error("Failed to decode withdrawal request", zap.Error(err))
+		logger.FromContext(r.Context()).Error("Failed to decode withdrawal request", zap.Error(err))
 		http.Error(w, "Invalid request format", http.StatusBadRequest)
 		return
 	}
 
-	status, _, err := h.balanceService.GetWithdrawal(req, userID)
+	status, _, err := h.balanceService.GetWithdrawal(r.Context(), req, userID)
 	if err != nil {
-		logger.Log.Error("Failed to process withdrawal",
+		logger.FromContext(r.Context()).Error("Failed to process withdrawal",
 			zap.Error(err),
-			zap.String("orderNumber", req.Order),
-			zap.Int("userID", userID))
+			zap.String("orderNumber", req.Order))
 
 		http.Error(w, err.Error(), status)
 		return
 	}
 
-	writeJSONResponse(w, status, nil, zap.Int("userID", userID), zap.String("order", req.Order), zap.Float64("sum", req.Sum))
+	writeJSONResponse(r.Context(), w, status, nil, zap.String("order", req.Order), zap.Stringer("sum", req.Sum))
 }
 
 func (h *BalanceHandler) GetWithdrawals(w http.ResponseWriter, r *http.Request) {
@@ -78,14 +76,13 @@ func (h *BalanceHandler) GetWithdrawals(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	response, status, err := h.balanceService.GetUserWithdrawals(userID)
+	response, status, err := h.balanceService.GetUserWithdrawals(r.Context(), userID)
 	if err != nil {
-		logger.Log.Error("Failed to get user withdrawals",
-			zap.Error(err),
-			zap.Int("userID", userID))
+		logger.FromContext(r.Context()).Error("Failed to get user withdrawals",
+			zap.Error(err))
 		http.Error(w, err.Error(), status)
 		return
 	}
 
-	writeJSONResponse(w, http.StatusOK, response, zap.Int("userID", userID))
+	writeJSONResponse(r.Context(), w, http.StatusOK, response)
 }
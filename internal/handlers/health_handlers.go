@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/alisaviation/internal/health"
+	"github.com/alisaviation/pkg/logger"
+)
+
+type HealthHandler struct {
+	readiness *health.Readiness
+}
+
+func NewHealthHandler(readiness *health.Readiness) *HealthHandler {
+	return &HealthHandler{
+		readiness: readiness,
+	}
+}
+
+// Live answers as long as the process serves HTTP; it checks no
+// dependencies, so a database outage does not get the process restarted.
+func (h *HealthHandler) Live(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSONResponse(r.Context(), w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// Ready reports each dependency check and answers 503 unless all required
+// ones pass and the server is not shutting down.
+func (h *HealthHandler) Ready(w http.ResponseWriter, r *http.Request) {
+	report := h.readiness.Check(r.Context())
+
+	status := http.StatusOK
+	if !report.Ready() {
+		status = http.StatusServiceUnavailable
+	}
+	if report.Status == health.StatusNotReady {
+		logger.FromContext(r.Context()).Warn("Service is not ready", zap.Any("components", report.Components))
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSONResponse(r.Context(), w, status, report)
+}
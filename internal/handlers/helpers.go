@@ -1,11 +1,13 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 
 	"go.uber.org/zap"
 
+	"github.com/alisaviation/internal/gophermart/dto"
 	"github.com/alisaviation/pkg/logger"
 )
 
@@ -15,17 +17,18 @@ func respondWithError(w http.ResponseWriter, code int, message string) {
 	json.NewEncoder(w).Encode(map[string]string{"error": message})
 }
 
-func respondWithToken(w http.ResponseWriter, code int, message, token string) {
+func respondWithTokens(w http.ResponseWriter, code int, message string, tokens *dto.AuthTokens) {
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Authorization", "Bearer "+token)
+	w.Header().Set("Authorization", "Bearer "+tokens.AccessToken)
 	w.WriteHeader(code)
 	json.NewEncoder(w).Encode(map[string]string{
-		"message": message,
-		"token":   token,
+		"message":       message,
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
 	})
 }
 
-func writeJSONResponse(w http.ResponseWriter, statusCode int, data interface{}, context ...zap.Field) {
+func writeJSONResponse(ctx context.Context, w http.ResponseWriter, statusCode int, data interface{}, fields ...zap.Field) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
 
@@ -34,8 +37,8 @@ func writeJSONResponse(w http.ResponseWriter, statusCode int, data interface{},
 	}
 
 	if err := json.NewEncoder(w).Encode(data); err != nil {
-		logger.Log.Error("Failed to encode JSON response",
-			append(context, zap.Error(err))...)
+		logger.FromContext(ctx).Error("Failed to encode JSON response",
+			append(fields, zap.Error(err))...)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 	}
 }
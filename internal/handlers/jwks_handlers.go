@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/alisaviation/internal/gophermart/services"
+)
+
+type JWKSHandler struct {
+	keys *services.KeySet
+}
+
+func NewJWKSHandler(keys *services.KeySet) *JWKSHandler {
+	return &JWKSHandler{
+		keys: keys,
+	}
+}
+
+func (h *JWKSHandler) GetJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSONResponse(r.Context(), w, http.StatusOK, h.keys.JWKS())
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/alisaviation/internal/gophermart/dto"
+	"github.com/alisaviation/internal/gophermart/events"
+	"github.com/alisaviation/internal/middleware"
+	"github.com/alisaviation/pkg/logger"
+)
+
+const (
+	defaultHeartbeatInterval = 15 * time.Second
+	sseRetryMillis           = 3000
+)
+
+type OrderEventsHandler struct {
+	bus       *events.Bus
+	heartbeat time.Duration
+}
+
+func NewOrderEventsHandler(bus *events.Bus, heartbeat time.Duration) *OrderEventsHandler {
+	if heartbeat <= 0 {
+		heartbeat = defaultHeartbeatInterval
+	}
+	return &OrderEventsHandler{
+		bus:       bus,
+		heartbeat: heartbeat,
+	}
+}
+
+// Stream sends the caller's order changes as Server-Sent Events. A client
+// that reconnects with Last-Event-ID receives the events it missed, or a
+// resync event when they are no longer available and it should reload its
+// orders. A comment line is sent every heartbeat interval to keep proxies
+// from closing an idle connection.
+func (h *OrderEventsHandler) Stream(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var lastEventID events.EventID
+	if value := r.Header.Get("Last-Event-ID"); value != "" {
+		id, err := events.ParseEventID(value)
+		if err != nil {
+			http.Error(w, "Invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+		lastEventID = id
+	}
+
+	rc := http.NewResponseController(w)
+	sub, missed, complete := h.bus.Subscribe(userID, lastEventID)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(w, "retry: %d\n\n", sseRetryMillis)
+	if !complete {
+		fmt.Fprint(w, "event: resync\ndata: {}\n\n")
+	}
+	for _, event := range missed {
+		if err := writeOrderEvent(w, event); err != nil {
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		logger.FromContext(r.Context()).Error("Streaming is not supported by the response writer", zap.Error(err))
+		return
+	}
+
+	heartbeat := time.NewTicker(h.heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-sub.Events():
+			if !ok {
+				// Shutdown or a lagging client; it reconnects with Last-Event-ID.
+				return
+			}
+			if err := writeOrderEvent(w, event); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func writeOrderEvent(w io.Writer, event events.OrderEvent) error {
+	data := dto.OrderEventData{
+		Number:    event.Number,
+		Status:    event.Status,
+		UpdatedAt: event.At,
+	}
+	if event.Status == "PROCESSED" {
+		data.Accrual = event.Accrual
+	}
+
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: order\ndata: %s\n\n", event.ID, payload)
+	return err
+}
@@ -1,12 +1,23 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"mime"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/go-chi/chi/v5"
 	"go.uber.org/zap"
 
 	"github.com/alisaviation/internal/gophermart/dto"
+	"github.com/alisaviation/internal/gophermart/models"
 	"github.com/alisaviation/internal/gophermart/services"
 	"github.com/alisaviation/internal/middleware"
 	"github.com/alisaviation/pkg/logger"
@@ -50,12 +61,11 @@ func (h *OrderHandler) UploadOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	status, err := h.orderService.UploadOrder(userID, req.OrderNumber)
+	status, err := h.orderService.UploadOrder(r.Context(), userID, req.OrderNumber)
 	if err != nil {
-		logger.Log.Error("Failed to process order",
+		logger.FromContext(r.Context()).Error("Failed to process order",
 			zap.Error(err),
-			zap.String("orderNumber", req.OrderNumber),
-			zap.Int("userID", userID))
+			zap.String("orderNumber", req.OrderNumber))
 
 		switch status {
 		case http.StatusBadRequest:
@@ -73,6 +83,76 @@ func (h *OrderHandler) UploadOrder(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(status)
 }
 
+const (
+	maxBatchOrders = 500
+	// Room for the longest numbers with quotes, separators and indentation.
+	maxBatchBodyBytes = maxBatchOrders * 64
+)
+
+// UploadOrders accepts a JSON array of order numbers or a text/plain body
+// with one number per line and reports the outcome of each number.
+func (h *OrderHandler) UploadOrders(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBatchBodyBytes))
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		http.Error(w, fmt.Sprintf("At most %d orders per batch", maxBatchOrders), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	var numbers []string
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/json":
+		if err := json.Unmarshal(body, &numbers); err != nil {
+			http.Error(w, "Body must be a JSON array of order numbers", http.StatusBadRequest)
+			return
+		}
+		for i := range numbers {
+			numbers[i] = strings.TrimSpace(numbers[i])
+		}
+	case "text/plain":
+		for _, line := range strings.Split(string(body), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				numbers = append(numbers, line)
+			}
+		}
+	default:
+		http.Error(w, "Content-Type must be application/json or text/plain", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	if len(numbers) == 0 {
+		http.Error(w, "Empty order batch", http.StatusBadRequest)
+		return
+	}
+	if len(numbers) > maxBatchOrders {
+		http.Error(w, fmt.Sprintf("At most %d orders per batch", maxBatchOrders), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	results, err := h.orderService.UploadOrders(r.Context(), userID, numbers)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSONResponse(r.Context(), w, http.StatusOK, results)
+}
+
+// GetOrders lists the user's orders newest first. Without query parameters
+// it returns the whole history; limit and cursor page through it, and
+// status, from, to and sort narrow or reorder it. The cursor of the next
+// page is returned in the X-Next-Cursor header.
 func (h *OrderHandler) GetOrders(w http.ResponseWriter, r *http.Request) {
 	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
 	if !ok {
@@ -80,30 +160,142 @@ func (h *OrderHandler) GetOrders(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	orders, err := h.orderService.GetOrders(userID)
+	query, err := parseOrderListQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := h.orderService.ListOrders(r.Context(), userID, query)
+	if errors.Is(err, services.ErrInvalidCursor) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Failed to get user orders", http.StatusInternalServerError)
 		return
 	}
 
-	if len(orders) == 0 {
+	if page.NextCursor != "" {
+		w.Header().Set("X-Next-Cursor", page.NextCursor)
+	}
+	if len(page.Orders) == 0 {
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
 
-	response := make([]dto.OrderResponse, 0, len(orders))
-	for _, order := range orders {
-		resp := dto.OrderResponse{
-			Number:     order.Number,
-			Status:     order.Status,
-			UploadedAt: order.UploadedAt,
+	response := make([]dto.OrderResponse, 0, len(page.Orders))
+	for _, order := range page.Orders {
+		response = append(response, orderResponse(order))
+	}
+	writeJSONResponse(r.Context(), w, http.StatusOK, response)
+}
+
+// GetOrder returns one of the user's orders; refresh=true checks the
+// accrual system before answering.
+func (h *OrderHandler) GetOrder(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	refresh := false
+	if value := r.URL.Query().Get("refresh"); value != "" {
+		var err error
+		if refresh, err = strconv.ParseBool(value); err != nil {
+			http.Error(w, "refresh must be true or false", http.StatusBadRequest)
+			return
+		}
+	}
+
+	number := chi.URLParam(r, "number")
+	order, err := h.orderService.GetOrder(r.Context(), userID, number, refresh)
+	if errors.Is(err, services.ErrOrderNotFound) {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.FromContext(r.Context()).Error("Failed to get order",
+			zap.Error(err),
+			zap.String("orderNumber", number))
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(r.Context(), w, http.StatusOK, orderResponse(*order))
+}
+
+func orderResponse(order models.Order) dto.OrderResponse {
+	resp := dto.OrderResponse{
+		Number:     order.Number,
+		Status:     order.Status,
+		UploadedAt: order.UploadedAt,
+	}
+
+	if order.Status == "PROCESSED" {
+		resp.Accrual = order.Accrual
+	}
+	return resp
+}
+
+const maxOrdersPageSize = 100
+
+var orderStatuses = []string{"NEW", "PROCESSING", "INVALID", "PROCESSED"}
+
+func parseOrderListQuery(values url.Values) (services.OrderListQuery, error) {
+	var query services.OrderListQuery
+
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxOrdersPageSize {
+			return query, fmt.Errorf("limit must be between 1 and %d", maxOrdersPageSize)
 		}
+		query.Limit = n
+	}
+	query.Cursor = values.Get("cursor")
 
-		if order.Status == "PROCESSED" {
-			resp.Accrual = order.Accrual
+	// status may be repeated or comma separated.
+	for _, value := range values["status"] {
+		for _, status := range strings.Split(value, ",") {
+			status = strings.ToUpper(strings.TrimSpace(status))
+			if !slices.Contains(orderStatuses, status) {
+				return query, fmt.Errorf("unknown status %q", status)
+			}
+			query.Statuses = append(query.Statuses, status)
 		}
+	}
 
-		response = append(response, resp)
+	var err error
+	if query.From, err = parseTimeParam(values, "from"); err != nil {
+		return query, err
+	}
+	if query.To, err = parseTimeParam(values, "to"); err != nil {
+		return query, err
+	}
+	if !query.From.IsZero() && !query.To.IsZero() && !query.From.Before(query.To) {
+		return query, errors.New("from must be before to")
+	}
+
+	switch values.Get("sort") {
+	case "", "desc":
+	case "asc":
+		query.Ascending = true
+	default:
+		return query, errors.New("sort must be asc or desc")
+	}
+
+	return query, nil
+}
+
+func parseTimeParam(values url.Values, name string) (time.Time, error) {
+	value := values.Get(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be an RFC 3339 timestamp", name)
 	}
-	writeJSONResponse(w, http.StatusOK, response, zap.Int("userID", userID))
+	return t, nil
 }
@@ -0,0 +1,250 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+
+	"github.com/alisaviation/internal/database"
+	"github.com/alisaviation/internal/gophermart/dto"
+	"github.com/alisaviation/internal/gophermart/models"
+	"github.com/alisaviation/internal/gophermart/services"
+	"github.com/alisaviation/internal/middleware"
+	"github.com/alisaviation/pkg/logger"
+)
+
+const (
+	defaultDeliveriesPageSize = 50
+	maxDeliveriesPageSize     = 100
+)
+
+type WebhookHandler struct {
+	webhookService services.WebhookService
+}
+
+func NewWebhookHandler(webhookService services.WebhookService) *WebhookHandler {
+	return &WebhookHandler{
+		webhookService: webhookService,
+	}
+}
+
+func (h *WebhookHandler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req dto.WebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	webhook, err := h.webhookService.CreateWebhook(r.Context(), userID, req)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	resp := webhookResponse(*webhook)
+	resp.Secret = webhook.Secret
+	writeJSONResponse(r.Context(), w, http.StatusCreated, resp)
+}
+
+func (h *WebhookHandler) GetWebhooks(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	webhooks, err := h.webhookService.GetWebhooks(r.Context(), userID)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	if len(webhooks) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	response := make([]dto.WebhookResponse, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		response = append(response, webhookResponse(webhook))
+	}
+	writeJSONResponse(r.Context(), w, http.StatusOK, response)
+}
+
+func (h *WebhookHandler) GetWebhook(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, ok := webhookID(r)
+	if !ok {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+
+	webhook, err := h.webhookService.GetWebhook(r.Context(), userID, id)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	writeJSONResponse(r.Context(), w, http.StatusOK, webhookResponse(*webhook))
+}
+
+func (h *WebhookHandler) UpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, ok := webhookID(r)
+	if !ok {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+
+	var req dto.WebhookUpdateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	webhook, err := h.webhookService.UpdateWebhook(r.Context(), userID, id, req)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	resp := webhookResponse(*webhook)
+	if req.Secret != nil {
+		resp.Secret = webhook.Secret
+	}
+	writeJSONResponse(r.Context(), w, http.StatusOK, resp)
+}
+
+func (h *WebhookHandler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, ok := webhookID(r)
+	if !ok {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+
+	if err := h.webhookService.DeleteWebhook(r.Context(), userID, id); err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *WebhookHandler) GetDeliveries(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, ok := webhookID(r)
+	if !ok {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+
+	limit := defaultDeliveriesPageSize
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxDeliveriesPageSize {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxDeliveriesPageSize), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	deliveries, err := h.webhookService.GetWebhookDeliveries(r.Context(), userID, id, limit)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	if len(deliveries) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	response := make([]dto.WebhookDeliveryResponse, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		response = append(response, deliveryResponse(delivery))
+	}
+	writeJSONResponse(r.Context(), w, http.StatusOK, response)
+}
+
+func (h *WebhookHandler) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, services.ErrWebhookNotFound):
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+	case errors.Is(err, services.ErrInvalidWebhook):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	case errors.Is(err, services.ErrTooManyWebhooks):
+		http.Error(w, "Webhook limit reached", http.StatusConflict)
+	default:
+		logger.FromContext(r.Context()).Error("Webhook request failed",
+			zap.Error(err))
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func webhookID(r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	return id, err == nil && id > 0
+}
+
+func webhookResponse(webhook models.Webhook) dto.WebhookResponse {
+	events := webhook.Events
+	if len(events) == 0 {
+		events = database.WebhookEventTypes
+	}
+	return dto.WebhookResponse{
+		ID:                  webhook.ID,
+		URL:                 webhook.URL,
+		Events:              events,
+		Enabled:             webhook.Enabled,
+		ConsecutiveFailures: webhook.ConsecutiveFailures,
+		DisabledAt:          webhook.DisabledAt,
+		CreatedAt:           webhook.CreatedAt,
+	}
+}
+
+func deliveryResponse(delivery models.WebhookDelivery) dto.WebhookDeliveryResponse {
+	resp := dto.WebhookDeliveryResponse{
+		ID:            delivery.ID,
+		EventID:       delivery.EventID,
+		EventType:     delivery.EventType,
+		Status:        delivery.Status,
+		Attempts:      delivery.Attempts,
+		ResponseCode:  delivery.ResponseCode,
+		Error:         delivery.LastError,
+		CreatedAt:     delivery.CreatedAt,
+		LastAttemptAt: delivery.LastAttemptAt,
+		DeliveredAt:   delivery.DeliveredAt,
+	}
+	if delivery.Status == database.WebhookDeliveryPending {
+		resp.NextAttemptAt = &delivery.NextAttemptAt
+	}
+	return resp
+}
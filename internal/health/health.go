@@ -0,0 +1,140 @@
+// Package health answers the liveness and readiness probes of the
+// orchestrator. Readiness runs a set of named dependency checks and reports
+// each one alongside the overall verdict.
+package health
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	StatusReady        = "ready"
+	StatusNotReady     = "not_ready"
+	StatusShuttingDown = "shutting_down"
+
+	ComponentUp   = "up"
+	ComponentDown = "down"
+
+	defaultCheckTimeout = 2 * time.Second
+)
+
+// Check reports whether a dependency is usable; a nil error means it is.
+type Check func(ctx context.Context) error
+
+type ComponentStatus struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Optional bool   `json:"optional,omitempty"`
+}
+
+type Report struct {
+	Status     string                     `json:"status"`
+	Components map[string]ComponentStatus `json:"components,omitempty"`
+}
+
+func (r Report) Ready() bool {
+	return r.Status == StatusReady
+}
+
+type component struct {
+	name     string
+	check    Check
+	optional bool
+}
+
+// Readiness is ready when every required check passes and shutdown has not
+// begun. Optional checks are reported but do not affect the verdict.
+type Readiness struct {
+	// Timeout bounds each check run; checks run concurrently.
+	Timeout time.Duration
+
+	components   []component
+	shuttingDown atomic.Bool
+}
+
+func NewReadiness() *Readiness {
+	return &Readiness{Timeout: defaultCheckTimeout}
+}
+
+// Add registers a check that must pass for the service to be ready. It is
+// not safe to call once probes are being served.
+func (r *Readiness) Add(name string, check Check) {
+	r.components = append(r.components, component{name: name, check: check})
+}
+
+// AddOptional registers a check that is only reported.
+func (r *Readiness) AddOptional(name string, check Check) {
+	r.components = append(r.components, component{name: name, check: check, optional: true})
+}
+
+// ShutDown makes every later report not ready without running the checks,
+// so traffic is drained while in-flight requests finish.
+func (r *Readiness) ShutDown() {
+	r.shuttingDown.Store(true)
+}
+
+func (r *Readiness) Check(ctx context.Context) Report {
+	if r.shuttingDown.Load() {
+		return Report{Status: StatusShuttingDown}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	statuses := make([]ComponentStatus, len(r.components))
+	var wg sync.WaitGroup
+	for i, c := range r.components {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses[i] = ComponentStatus{Status: ComponentUp, Optional: c.optional}
+			if err := c.check(ctx); err != nil {
+				statuses[i].Status = ComponentDown
+				statuses[i].Error = err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusReady, Components: make(map[string]ComponentStatus, len(r.components))}
+	for i, c := range r.components {
+		report.Components[c.name] = statuses[i]
+		if statuses[i].Status == ComponentDown && !c.optional {
+			report.Status = StatusNotReady
+		}
+	}
+	// Shutdown may have begun while the checks ran.
+	if r.shuttingDown.Load() {
+		report.Status = StatusShuttingDown
+	}
+	return report
+}
+
+// Cached runs check at most once per ttl and answers with the last result
+// in between, for dependencies too costly to probe on every request. A run
+// cut short by the caller's context is not remembered.
+func Cached(check Check, ttl time.Duration) Check {
+	var (
+		mu        sync.Mutex
+		checkedAt time.Time
+		lastErr   error
+	)
+	return func(ctx context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if !checkedAt.IsZero() && time.Since(checkedAt) < ttl {
+			return lastErr
+		}
+		err := check(ctx)
+		if ctx.Err() != nil {
+			return err
+		}
+		lastErr = err
+		checkedAt = time.Now()
+		return lastErr
+	}
+}
@@ -0,0 +1,139 @@
+// Package metrics holds the Prometheus collectors of the service. They are
+// registered on Registry, which the admin server exposes at /metrics.
+package metrics
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+
+	"github.com/alisaviation/internal/database"
+	"github.com/alisaviation/pkg/logger"
+)
+
+const namespace = "gophermart"
+
+// AccrualError is the outcome of an accrual request that got no response.
+const AccrualError = "error"
+
+var Registry = prometheus.NewRegistry()
+
+var (
+	HTTPRequests = promauto.With(Registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "HTTP requests by method, chi route pattern and status code.",
+	}, []string{"method", "route", "status"})
+
+	HTTPDuration = promauto.With(Registry).NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "HTTP request latency by method, chi route pattern and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	AccrualRequests = promauto.With(Registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "accrual",
+		Name:      "requests_total",
+		Help:      "Accrual system requests by outcome: the response status code or \"error\".",
+	}, []string{"outcome"})
+
+	AccrualDuration = promauto.With(Registry).NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "accrual",
+		Name:      "request_duration_seconds",
+		Help:      "Accrual system request latency by outcome.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"outcome"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// ObserveAccrualRequest records one accrual request attempt; code is the
+// response status, or zero when the request failed without one.
+func ObserveAccrualRequest(code int, duration time.Duration) {
+	outcome := AccrualError
+	if code != 0 {
+		outcome = strconv.Itoa(code)
+	}
+	AccrualRequests.WithLabelValues(outcome).Inc()
+	AccrualDuration.WithLabelValues(outcome).Observe(duration.Seconds())
+}
+
+var (
+	ordersDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "orders"),
+		"Orders by status.",
+		[]string{"status"}, nil,
+	)
+	withdrawalsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "withdrawals"),
+		"Withdrawals made by all users.",
+		nil, nil,
+	)
+	withdrawnDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "withdrawn_points"),
+		"Points withdrawn by all users.",
+		nil, nil,
+	)
+)
+
+// StorageCollector reports order and withdrawal figures read from storage
+// at scrape time, so every instance sees the same numbers.
+type StorageCollector struct {
+	stats   database.Stats
+	timeout time.Duration
+}
+
+func NewStorageCollector(stats database.Stats, timeout time.Duration) *StorageCollector {
+	return &StorageCollector{stats: stats, timeout: timeout}
+}
+
+func (c *StorageCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- ordersDesc
+	ch <- withdrawalsDesc
+	ch <- withdrawnDesc
+}
+
+func (c *StorageCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := c.scrapeContext()
+	defer cancel()
+
+	counts, err := c.stats.CountOrdersByStatus(ctx)
+	if err != nil {
+		logger.Log.Error("Failed to count orders for metrics", zap.Error(err))
+	}
+	for status, n := range counts {
+		ch <- prometheus.MustNewConstMetric(ordersDesc, prometheus.GaugeValue, float64(n), status)
+	}
+
+	count, sum, err := c.stats.WithdrawalTotals(ctx)
+	if err != nil {
+		logger.Log.Error("Failed to total withdrawals for metrics", zap.Error(err))
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(withdrawalsDesc, prometheus.GaugeValue, float64(count))
+	ch <- prometheus.MustNewConstMetric(withdrawnDesc, prometheus.GaugeValue, sum.Float64())
+}
+
+// scrapeContext treats a non-positive timeout as none, the same way storage
+// queries do.
+func (c *StorageCollector) scrapeContext() (context.Context, context.CancelFunc) {
+	if c.timeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), c.timeout)
+}
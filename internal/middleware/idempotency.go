@@ -0,0 +1,195 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/alisaviation/internal/database"
+	"github.com/alisaviation/internal/gophermart/models"
+	"github.com/alisaviation/pkg/logger"
+)
+
+const (
+	IdempotencyKeyHeader     = "Idempotency-Key"
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength  = 255
+	// The body is buffered to be hashed, before any handler limit applies.
+	maxIdempotentBodyBytes = 1 << 20
+	// idempotencyLockTimeout bounds how long a request may hold its key
+	// unfinished. It is well above any storage timeout, so that only a
+	// request whose process died loses the key to a retry.
+	idempotencyLockTimeout = time.Minute
+)
+
+func IdempotencyMiddleware(store database.Idempotency, ttl time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxIdempotencyKeyLength {
+				http.Error(w, "Idempotency-Key is too long", http.StatusBadRequest)
+				return
+			}
+
+			userID, ok := r.Context().Value(UserIDKey).(int)
+			if !ok {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBodyBytes))
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				http.Error(w, "Request body is too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			if err != nil {
+				http.Error(w, "Bad request", http.StatusBadRequest)
+				return
+			}
+			r.Body.Close()
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			now := time.Now()
+			record := &models.IdempotencyRecord{
+				UserID:      userID,
+				Key:         key,
+				RequestHash: hashRequest(r, body),
+				ExpiresAt:   now.Add(ttl),
+				LockedUntil: now.Add(idempotencyLockTimeout),
+			}
+
+			existing, reserved, err := store.ReserveIdempotencyKey(r.Context(), record)
+			if err != nil {
+				logger.FromContext(r.Context()).Error("Failed to reserve idempotency key",
+					zap.Error(err))
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+
+			if !reserved {
+				switch {
+				case existing.RequestHash != record.RequestHash:
+					http.Error(w, "Idempotency-Key was already used with a different request", http.StatusUnprocessableEntity)
+				case !existing.Completed:
+					http.Error(w, "Request with this Idempotency-Key is still in progress", http.StatusConflict)
+				default:
+					replayResponse(w, existing)
+				}
+				return
+			}
+
+			// The outcome must be stored even if the client has already gone.
+			storeCtx := context.WithoutCancel(r.Context())
+
+			rec := &recordingWriter{ResponseWriter: w, statusCode: http.StatusOK}
+			defer func() {
+				if p := recover(); p != nil {
+					releaseIdempotencyKey(storeCtx, store, userID, key)
+					panic(p)
+				}
+			}()
+
+			next.ServeHTTP(rec, r)
+
+			if rec.statusCode >= http.StatusInternalServerError {
+				releaseIdempotencyKey(storeCtx, store, userID, key)
+				return
+			}
+
+			record.Completed = true
+			record.StatusCode = rec.statusCode
+			record.Headers = storableHeaders(rec.header)
+			record.Body = rec.body.Bytes()
+			if err := store.CompleteIdempotencyKey(storeCtx, record); err != nil {
+				logger.FromContext(storeCtx).Error("Failed to store idempotent response",
+					zap.Error(err))
+			}
+		})
+	}
+}
+
+func hashRequest(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method))
+	h.Write([]byte{0})
+	h.Write([]byte(r.URL.Path))
+	h.Write([]byte{0})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func replayResponse(w http.ResponseWriter, record *models.IdempotencyRecord) {
+	for k, values := range record.Headers {
+		if k == requestIDHeaderKey {
+			continue
+		}
+		for _, v := range values {
+			w.Header().Add(k, v)
+		}
+	}
+	w.Header().Set(IdempotentReplayedHeader, "true")
+	w.WriteHeader(record.StatusCode)
+	w.Write(record.Body)
+}
+
+func releaseIdempotencyKey(ctx context.Context, store database.Idempotency, userID int, key string) {
+	if err := store.ReleaseIdempotencyKey(ctx, userID, key); err != nil {
+		logger.FromContext(ctx).Error("Failed to release idempotency key",
+			zap.Error(err))
+	}
+}
+
+var requestIDHeaderKey = http.CanonicalHeaderKey(logger.RequestIDHeader)
+
+// storableHeaders drops headers that belong to the original exchange; a
+// replay gets its own request ID, which replayResponse also keeps for
+// records stored before it was dropped.
+func storableHeaders(header http.Header) map[string][]string {
+	stored := make(map[string][]string, len(header))
+	for k, v := range header {
+		switch k {
+		case "Content-Encoding", "Content-Length", "Date", requestIDHeaderKey:
+			continue
+		}
+		stored[k] = append([]string(nil), v...)
+	}
+	return stored
+}
+
+type recordingWriter struct {
+	http.ResponseWriter
+	statusCode  int
+	wroteHeader bool
+	header      http.Header
+	body        bytes.Buffer
+}
+
+func (rw *recordingWriter) WriteHeader(code int) {
+	if rw.wroteHeader {
+		return
+	}
+	rw.wroteHeader = true
+	rw.statusCode = code
+	rw.header = rw.ResponseWriter.Header().Clone()
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *recordingWriter) Write(b []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	rw.body.Write(b)
+	return rw.ResponseWriter.Write(b)
+}
@@ -3,12 +3,15 @@ package middleware
 import (
 	"compress/gzip"
 	"context"
-	"fmt"
 	"io"
 	"net/http"
 	"strings"
 
+	"go.uber.org/zap"
+
+	"github.com/alisaviation/internal/database"
 	"github.com/alisaviation/internal/gophermart/services"
+	"github.com/alisaviation/pkg/logger"
 )
 
 type contextKey string
@@ -16,9 +19,10 @@ type contextKey string
 const (
 	UserIDKey contextKey = "userID"
 	UserLogin contextKey = "userLogin"
+	ClaimsKey contextKey = "claims"
 )
 
-func AuthMiddleware(jwtService *services.JWTService) func(http.Handler) http.Handler {
+func AuthMiddleware(jwtService *services.JWTService, tokens database.Token) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			authHeader := r.Header.Get("Authorization")
@@ -29,7 +33,8 @@ func AuthMiddleware(jwtService *services.JWTService) func(http.Handler) http.Han
 
 			tokenParts := strings.Split(authHeader, " ")
 			if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
-				fmt.Printf("Invalid token parts: %v\n", tokenParts)
+				logger.FromContext(r.Context()).Debug("Invalid authorization format",
+					zap.Int("parts", len(tokenParts)))
 				http.Error(w, "Invalid authorization format", http.StatusUnauthorized)
 				return
 			}
@@ -40,8 +45,20 @@ func AuthMiddleware(jwtService *services.JWTService) func(http.Handler) http.Han
 				return
 			}
 
+			revoked, err := tokens.IsTokenRevoked(r.Context(), claims.ID, claims.SessionID)
+			if err != nil {
+				http.Error(w, "Failed to check token", http.StatusInternalServerError)
+				return
+			}
+			if revoked {
+				http.Error(w, "Token revoked", http.StatusUnauthorized)
+				return
+			}
+
 			ctx := context.WithValue(r.Context(), UserIDKey, claims.UserID)
 			ctx = context.WithValue(ctx, UserLogin, claims.Login)
+			ctx = context.WithValue(ctx, ClaimsKey, claims)
+			ctx = logger.WithUserID(ctx, claims.UserID)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
@@ -78,3 +95,18 @@ type gzipWriter struct {
 func (g gzipWriter) Write(b []byte) (int, error) {
 	return g.Writer.Write(b)
 }
+
+// FlushError pushes compressed data to the client, which streaming responses
+// need after every message.
+func (g gzipWriter) FlushError() error {
+	if gz, ok := g.Writer.(*gzip.Writer); ok {
+		if err := gz.Flush(); err != nil {
+			return err
+		}
+	}
+	return http.NewResponseController(g.ResponseWriter).Flush()
+}
+
+func (g gzipWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+
+	"github.com/alisaviation/internal/database"
+	"github.com/alisaviation/internal/metrics"
+	"github.com/alisaviation/pkg/logger"
+)
+
+// registerMetrics adds the collectors that depend on the storage chosen at
+// startup: connection pool stats and, where supported, order and withdrawal
+// figures.
+func (s *ServerApp) registerMetrics() {
+	if s.db != nil {
+		if err := metrics.Registry.Register(collectors.NewDBStatsCollector(s.db, "gophermart")); err != nil {
+			logger.Log.Error("Failed to register database metrics", zap.Error(err))
+		}
+	}
+
+	if stats, ok := s.storage.(database.Stats); ok {
+		if err := metrics.Registry.Register(metrics.NewStorageCollector(stats, s.config.QueryTimeout)); err != nil {
+			logger.Log.Error("Failed to register storage metrics", zap.Error(err))
+		}
+	}
+}
+
+// startAdminServer serves /metrics on its own address so it stays off the
+// public listener. An empty AdminAddress disables it.
+func (s *ServerApp) startAdminServer() {
+	if s.config.AdminAddress == "" {
+		return
+	}
+
+	r := chi.NewRouter()
+	r.Handle("/metrics", promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{}))
+
+	s.mu.Lock()
+	s.adminServer = &http.Server{
+		Addr:    s.config.AdminAddress,
+		Handler: r,
+	}
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		logger.Log.Info("Starting admin server", zap.String("address", s.config.AdminAddress))
+		if err := s.adminServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Log.Error("Admin server failed", zap.Error(err))
+		}
+	}()
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/alisaviation/internal/database/postgres"
+	"github.com/alisaviation/internal/health"
+)
+
+type pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// newReadiness checks the database and its schema, which the service cannot
+// work without, and reports on the accrual system, whose outage only delays
+// accruals. The schema and accrual checks are cached for HealthCacheTTL.
+func (s *ServerApp) newReadiness() *health.Readiness {
+	readiness := health.NewReadiness()
+
+	if s.db != nil {
+		readiness.Add("database", s.db.PingContext)
+		readiness.Add("migrations", health.Cached(s.checkMigrations, s.config.HealthCacheTTL))
+	}
+
+	if accrual, ok := s.accrualClient.(pinger); ok {
+		readiness.AddOptional("accrual", health.Cached(accrual.Ping, s.config.HealthCacheTTL))
+	}
+	return readiness
+}
+
+func (s *ServerApp) checkMigrations(ctx context.Context) error {
+	status, err := postgres.SchemaStatus(ctx, s.db)
+	if err != nil {
+		return err
+	}
+	switch {
+	case status.Dirty:
+		return fmt.Errorf("migration %d failed halfway", status.Version)
+	case status.Pending():
+		return fmt.Errorf("schema version %d is behind %d", status.Version, status.Latest)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/alisaviation/internal/config"
+	"github.com/alisaviation/internal/gophermart/services"
+)
+
+const defaultJWTSecret = "secret"
+
+// loadSigningKeys builds the JWT key set from configuration. The current key
+// signs new tokens; previous secrets and public keys only validate tokens
+// that were issued before a rotation.
+func loadSigningKeys(conf config.Server) (*services.KeySet, error) {
+	var previous []*services.SigningKey
+	for _, secret := range conf.JWTPreviousSecrets {
+		previous = append(previous, services.NewHMACKey([]byte(secret)))
+	}
+	for _, path := range conf.JWTPreviousKeyFiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read previous JWT key %s: %w", path, err)
+		}
+		key, err := services.ParsePublicKeyPEM(data)
+		if err != nil {
+			// A retired private key is accepted as well.
+			if key, err = services.ParsePrivateKeyPEM(data); err != nil {
+				return nil, fmt.Errorf("failed to parse previous JWT key %s: %w", path, err)
+			}
+			key.Private = nil
+		}
+		previous = append(previous, key)
+	}
+
+	current, err := loadCurrentKey(conf)
+	if err != nil {
+		return nil, err
+	}
+	return services.NewKeySet(current, previous...)
+}
+
+func loadCurrentKey(conf config.Server) (*services.SigningKey, error) {
+	switch strings.ToUpper(conf.JWTAlgorithm) {
+	case "", strings.ToUpper(services.AlgorithmHS256):
+		secret := []byte(conf.JWTSecret)
+		if conf.JWTSecretFile != "" {
+			data, err := os.ReadFile(conf.JWTSecretFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read JWT secret file: %w", err)
+			}
+			secret = bytes.TrimSpace(data)
+		}
+		if len(secret) == 0 {
+			return nil, fmt.Errorf("JWT secret is empty")
+		}
+		return services.NewHMACKey(secret), nil
+
+	case strings.ToUpper(services.AlgorithmRS256), strings.ToUpper(services.AlgorithmEdDSA):
+		if conf.JWTPrivateKeyFile == "" {
+			return nil, fmt.Errorf("JWT private key file is required for %s", conf.JWTAlgorithm)
+		}
+		data, err := os.ReadFile(conf.JWTPrivateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JWT private key: %w", err)
+		}
+		key, err := services.ParsePrivateKeyPEM(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse JWT private key: %w", err)
+		}
+		if !strings.EqualFold(key.Method.Alg(), conf.JWTAlgorithm) {
+			return nil, fmt.Errorf("JWT private key is %s, not %s", key.Method.Alg(), conf.JWTAlgorithm)
+		}
+		return key, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported JWT algorithm %q", conf.JWTAlgorithm)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -15,10 +16,16 @@ import (
 	"github.com/go-chi/chi/v5"
 
 	"github.com/alisaviation/internal/database"
+	"github.com/alisaviation/internal/database/memory"
 	"github.com/alisaviation/internal/database/postgres"
+	"github.com/alisaviation/internal/gophermart/events"
+	"github.com/alisaviation/internal/gophermart/outbox"
 	"github.com/alisaviation/internal/gophermart/services"
+	"github.com/alisaviation/internal/gophermart/webhooks"
 	"github.com/alisaviation/internal/handlers"
+	"github.com/alisaviation/internal/health"
 	"github.com/alisaviation/internal/middleware"
+	"github.com/alisaviation/internal/tracing"
 
 	"go.uber.org/zap"
 
@@ -29,12 +36,18 @@ import (
 type ServerApp struct {
 	config         config.Server
 	httpServer     *http.Server
+	adminServer    *http.Server
 	shutdownSignal chan struct{}
 	db             *sql.DB
 	storage        database.Storage
+	accrualClient  services.AccrualClientInterface
+	accrualWorker  *services.AccrualWorker
+	events         *events.Bus
 	wg             sync.WaitGroup
 	mu             sync.RWMutex
-	jwtSecret      []byte
+	jwtKeys        *services.KeySet
+	stopTracing    func(context.Context) error
+	readiness      *health.Readiness
 	ctx            context.Context
 	cancel         context.CancelFunc
 }
@@ -44,7 +57,7 @@ func NewServerApp(conf config.Server) *ServerApp {
 	return &ServerApp{
 		config:         conf,
 		shutdownSignal: make(chan struct{}),
-		jwtSecret:      []byte(conf.JWTSecret),
+		events:         events.NewBus(0),
 		ctx:            ctx,
 		cancel:         cancel,
 	}
@@ -54,11 +67,42 @@ func (s *ServerApp) Run() error {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	keys, err := loadSigningKeys(s.config)
+	if err != nil {
+		return fmt.Errorf("JWT key initialization failed: %w", err)
+	}
+	s.jwtKeys = keys
+	if keys.Current().Method.Alg() == services.AlgorithmHS256 && s.config.JWTSecretFile == "" && s.config.JWTSecret == defaultJWTSecret {
+		logger.Log.Warn("Using the default JWT secret, set JWT_SECRET or JWT_SECRET_FILE")
+	}
+
+	stopTracing, err := tracing.Setup(ctx, s.config.TraceExporter, s.config.OTLPEndpoint)
+	if err != nil {
+		return fmt.Errorf("tracing initialization failed: %w", err)
+	}
+	s.stopTracing = stopTracing
+
 	storage, err := s.initDB(ctx)
 	if err != nil {
 		return fmt.Errorf("database initialization failed: %w", err)
 	}
 	s.storage = storage
+	s.reconcileBalances(ctx)
+
+	s.accrualClient = services.NewAccrualClient(s.config.AccrualSystemAddress, s.config.AccrualRPS)
+
+	if err := s.startOutboxRelay(); err != nil {
+		return fmt.Errorf("outbox relay initialization failed: %w", err)
+	}
+
+	s.startWebhookSender()
+	s.startAccrualWorker()
+	s.startPurger("idempotency keys", s.storage.PurgeExpiredIdempotencyKeys)
+	s.startPurger("tokens", s.storage.PurgeExpiredTokens)
+
+	s.registerMetrics()
+	s.startAdminServer()
+	s.readiness = s.newReadiness()
 
 	serverErr := make(chan error, 1)
 	go func() {
@@ -96,6 +140,9 @@ func (s *ServerApp) startHTTPServer() error {
 	r := chi.NewRouter()
 
 	r.Use(
+		middleware.RequestIDMiddleware,
+		middleware.TracingMiddleware,
+		middleware.MetricsMiddleware,
 		logger.RequestResponseLogger,
 		middleware.GzipMiddleware,
 	)
@@ -120,31 +167,159 @@ func (s *ServerApp) startHTTPServer() error {
 	return nil
 }
 
+func (s *ServerApp) reconcileBalances(ctx context.Context) {
+	reconciler, ok := s.storage.(database.BalanceReconciler)
+	if !ok {
+		return
+	}
+
+	mismatches, err := reconciler.ReconcileBalances(ctx)
+	if err != nil {
+		logger.Log.Error("Balance reconciliation failed", zap.Error(err))
+		return
+	}
+
+	for _, m := range mismatches {
+		logger.Log.Warn("Balance mismatch",
+			zap.Int("userID", m.UserID),
+			zap.Stringer("current", m.Current),
+			zap.Stringer("expected_current", m.ExpectedCurrent),
+			zap.Stringer("withdrawn", m.Withdrawn),
+			zap.Stringer("expected_withdrawn", m.ExpectedWithdrawn))
+	}
+}
+
+func (s *ServerApp) startAccrualWorker() {
+	s.accrualWorker = services.NewAccrualWorker(
+		s.storage,
+		s.accrualClient,
+		s.config.AccrualWorkers,
+		s.config.AccrualPollInterval,
+	)
+	s.accrualWorker.Events = s.events
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.accrualWorker.Run(s.ctx)
+	}()
+}
+
+// startOutboxRelay relays outbox events to user webhooks and to the
+// configured sinks, if any.
+func (s *ServerApp) startOutboxRelay() error {
+	sinks := outbox.MultiSink{webhooks.NewEventSink(s.storage)}
+	if s.config.OutboxSinks != "" {
+		configured, err := outbox.ParseSinks(s.config.OutboxSinks)
+		if err != nil {
+			return err
+		}
+		sinks = append(sinks, configured)
+	}
+	relay := outbox.NewRelay(s.storage, sinks, s.config.OutboxPollInterval)
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer sinks.Close()
+		relay.Run(s.ctx)
+	}()
+	return nil
+}
+
+func (s *ServerApp) startWebhookSender() {
+	sender := webhooks.NewSender(s.storage, s.config.WebhookPollInterval)
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		sender.Run(s.ctx)
+	}()
+}
+
+// startPurger periodically deletes the expired rows purge is responsible for.
+func (s *ServerApp) startPurger(name string, purge func(context.Context) (int64, error)) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(s.config.PurgeInterval)
+		defer ticker.Stop()
+
+		for {
+			purged, err := purge(s.ctx)
+			switch {
+			case err != nil && s.ctx.Err() == nil:
+				logger.Log.Error("Failed to purge expired rows", zap.String("kind", name), zap.Error(err))
+			case purged > 0:
+				logger.Log.Info("Purged expired rows", zap.String("kind", name), zap.Int64("count", purged))
+			}
+
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
 func (s *ServerApp) registerRoutes(r *chi.Mux) {
-	jwtService := services.NewJWTService(s.jwtSecret, "gophermart")
-	authService := services.NewAuthService(s.storage, s.jwtSecret)
-	accrualClient := services.NewAccrualClient(s.config.AccrualSystemAddress)
-	orderService := services.NewOrderService(s.storage, accrualClient)
-	balanceService := services.NewBalanceService(s.storage)
+	jwtService := services.NewJWTServiceWithKeys(s.jwtKeys, "gophermart")
+	jwtService.AccessTTL = s.config.AccessTokenTTL
+	authService := services.NewAuthService(s.storage, s.storage, jwtService, s.config.RefreshTokenTTL)
+	orderService := services.NewOrderService(s.storage, s.storage, s.accrualClient, s.events)
+	balanceService := services.NewBalanceService(s.storage, s.storage)
+	webhookService := services.NewWebhookService(s.storage, s.storage)
 
 	authHandler := handlers.NewAuthHandler(authService)
 	orderHandler := handlers.NewOrderHandler(orderService)
 	balanceHandler := handlers.NewBalanceHandler(balanceService, orderService)
-
+	jwksHandler := handlers.NewJWKSHandler(s.jwtKeys)
+	orderEventsHandler := handlers.NewOrderEventsHandler(s.events, 0)
+	webhookHandler := handlers.NewWebhookHandler(webhookService)
+	healthHandler := handlers.NewHealthHandler(s.readiness)
+
+	r.Get("/healthz", healthHandler.Live)
+	r.Get("/readyz", healthHandler.Ready)
+	r.Get("/.well-known/jwks.json", jwksHandler.GetJWKS)
 	r.Post("/api/user/register", authHandler.Register)
 	r.Post("/api/user/login", authHandler.Login)
+	r.Post("/api/user/token/refresh", authHandler.Refresh)
 	r.Group(func(r chi.Router) {
-		r.Use(middleware.AuthMiddleware(jwtService))
+		r.Use(middleware.AuthMiddleware(jwtService, s.storage))
+
+		r.Post("/api/user/logout", authHandler.Logout)
 
-		r.Post("/api/user/orders", orderHandler.UploadOrder)
+		idempotent := r.With(middleware.IdempotencyMiddleware(s.storage, s.config.IdempotencyTTL))
+
+		idempotent.Post("/api/user/orders", orderHandler.UploadOrder)
+		idempotent.Post("/api/user/orders/batch", orderHandler.UploadOrders)
 		r.Get("/api/user/orders", orderHandler.GetOrders)
+		r.Get("/api/user/orders/events", orderEventsHandler.Stream)
+		r.Get("/api/user/orders/{number}", orderHandler.GetOrder)
 		r.Get("/api/user/balance", balanceHandler.GetUserBalance)
-		r.Post("/api/user/balance/withdraw", balanceHandler.Withdraw)
+		idempotent.Post("/api/user/balance/withdraw", balanceHandler.Withdraw)
 		r.Get("/api/user/withdrawals", balanceHandler.GetWithdrawals)
+
+		r.Post("/api/user/webhooks", webhookHandler.CreateWebhook)
+		r.Get("/api/user/webhooks", webhookHandler.GetWebhooks)
+		r.Get("/api/user/webhooks/{id}", webhookHandler.GetWebhook)
+		r.Patch("/api/user/webhooks/{id}", webhookHandler.UpdateWebhook)
+		r.Delete("/api/user/webhooks/{id}", webhookHandler.DeleteWebhook)
+		r.Get("/api/user/webhooks/{id}/deliveries", webhookHandler.GetDeliveries)
 	})
 }
 
 func (s *ServerApp) shutdown(ctx context.Context) {
+	if s.readiness != nil {
+		s.readiness.ShutDown()
+	}
+
+	// Event streams never finish on their own, so end them before waiting
+	// for active requests.
+	s.events.Close()
+
 	if s.httpServer != nil {
 		shutdownCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
 		defer cancel()
@@ -154,16 +329,19 @@ func (s *ServerApp) shutdown(ctx context.Context) {
 		}
 	}
 
-	if s.cancel != nil {
-		s.cancel()
-	}
+	if s.adminServer != nil {
+		shutdownCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
 
-	if s.db != nil {
-		if err := s.db.Close(); err != nil {
-			logger.Log.Error("Failed to close database connection", zap.Error(err))
+		if err := s.adminServer.Shutdown(shutdownCtx); err != nil {
+			logger.Log.Error("Admin server shutdown failed", zap.Error(err))
 		}
 	}
 
+	if s.cancel != nil {
+		s.cancel()
+	}
+
 	select {
 	case <-time.After(5 * time.Second):
 		logger.Log.Warn("Shutdown timed out")
@@ -173,9 +351,39 @@ func (s *ServerApp) shutdown(ctx context.Context) {
 		return ch
 	}():
 	}
+
+	if s.db != nil {
+		if err := s.db.Close(); err != nil {
+			logger.Log.Error("Failed to close database connection", zap.Error(err))
+		}
+	}
+
+	// Flush spans last so those of requests and workers that just finished
+	// are exported.
+	if s.stopTracing != nil {
+		if err := s.stopTracing(ctx); err != nil {
+			logger.Log.Error("Failed to flush traces", zap.Error(err))
+		}
+	}
+}
+
+// checkSchemaVersion warns when auto-migration is off and the schema lags
+// behind the binary; `gophermart migrate up` fixes it.
+func (s *ServerApp) checkSchemaVersion(ctx context.Context, db *sql.DB) {
+	status, err := postgres.SchemaStatus(ctx, db)
+	if err != nil {
+		logger.Log.Warn("Failed to check schema version", zap.Error(err))
+		return
+	}
+	if status.Dirty || status.Pending() {
+		logger.Log.Warn("Database schema is not up to date, run `gophermart migrate up`",
+			zap.Uint("version", status.Version),
+			zap.Uint("latest", status.Latest),
+			zap.Bool("dirty", status.Dirty))
+	}
 }
 
-func (s *ServerApp) initDB(ctx context.Context) (*postgres.PostgresStorage, error) {
+func (s *ServerApp) initDB(ctx context.Context) (database.Storage, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -183,6 +391,11 @@ func (s *ServerApp) initDB(ctx context.Context) (*postgres.PostgresStorage, erro
 		return nil, errors.New("database URI not configured")
 	}
 
+	if strings.HasPrefix(s.config.DatabaseURI, memory.DSNPrefix) {
+		logger.Log.Warn("Using in-memory storage, data will be lost on shutdown")
+		return memory.NewMemoryStorage(), nil
+	}
+
 	logger.Log.Info("Connecting to db", zap.String("DB URI", s.config.DatabaseURI))
 
 	db, err := sql.Open("postgres", s.config.DatabaseURI)
@@ -197,7 +410,17 @@ func (s *ServerApp) initDB(ctx context.Context) (*postgres.PostgresStorage, erro
 		return nil, err
 	}
 
-	storage, err := postgres.NewPostgresDatabase(db)
+	if s.config.AutoMigrate {
+		if err := postgres.Migrate(ctx, db); err != nil {
+			logger.Log.Error("Failed to migrate database", zap.Error(err))
+			db.Close()
+			return nil, err
+		}
+	} else {
+		s.checkSchemaVersion(ctx, db)
+	}
+
+	storage, err := postgres.NewPostgresDatabase(db, s.config.QueryTimeout)
 	if err != nil {
 		logger.Log.Fatal("Failed to create Postgres storage", zap.Error(err))
 		db.Close()
@@ -0,0 +1,117 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/alisaviation/internal/gophermart/services"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		value  string
+		want   time.Duration
+		wantOk bool
+	}{
+		{name: "seconds", value: "60", want: 60 * time.Second, wantOk: true},
+		{name: "zero seconds", value: "0", want: 0, wantOk: true},
+		{name: "http date", value: "Mon, 01 Jan 2024 12:00:30 GMT", want: 30 * time.Second, wantOk: true},
+		{name: "http date in the past", value: "Mon, 01 Jan 2024 11:59:00 GMT", want: 0, wantOk: true},
+		{name: "empty", value: "", wantOk: false},
+		{name: "negative", value: "-5", wantOk: false},
+		{name: "garbage", value: "soon", wantOk: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := services.ParseRetryAfter(tt.value, now)
+			assert.Equal(t, tt.wantOk, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestAccrualClient_RetryAfterPausesAllRequests(t *testing.T) {
+	var calls atomic.Int32
+	var mu sync.Mutex
+	var callTimes []time.Time
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		callTimes = append(callTimes, time.Now())
+		mu.Unlock()
+
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"order":"123","status":"PROCESSED","accrual":10}`))
+	}))
+	defer srv.Close()
+
+	client := services.NewAccrualClient(srv.URL, 0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.GetOrderAccrual(context.Background(), "123")
+			assert.NoError(t, err)
+			if assert.NotNil(t, resp) {
+				assert.Equal(t, "PROCESSED", resp.Status)
+			}
+		}()
+		time.Sleep(50 * time.Millisecond)
+	}
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, callTimes, 4)
+	for _, at := range callTimes[1:] {
+		assert.GreaterOrEqual(t, at.Sub(callTimes[0]), 990*time.Millisecond)
+	}
+}
+
+func TestAccrualClient_RateLimitedContextFailsFast(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	client := services.NewAccrualClient(srv.URL, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	_, err := client.GetOrderAccrual(ctx, "123")
+	assert.True(t, errors.Is(err, services.ErrRateLimited))
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestRateLimiter_RequestsPerSecond(t *testing.T) {
+	limiter := services.NewRateLimiter(20)
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		require.NoError(t, limiter.Wait(context.Background()))
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+}
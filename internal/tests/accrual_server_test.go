@@ -0,0 +1,98 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/alisaviation/internal/accrual"
+	"github.com/alisaviation/internal/gophermart/dto"
+	"github.com/alisaviation/pkg/money"
+)
+
+func doAccrualRequest(t *testing.T, h http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAccrualServer_OrderLifecycle(t *testing.T) {
+	storage := accrual.NewStorage()
+	h := accrual.NewRouter(storage, accrual.Config{})
+
+	rec := doAccrualRequest(t, h, http.MethodPost, "/api/goods", `{"match":"Bork","reward":10,"reward_type":"%"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	rec = doAccrualRequest(t, h, http.MethodPost, "/api/goods", `{"match":"Bork","reward":5,"reward_type":"pt"}`)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	rec = doAccrualRequest(t, h, http.MethodPost, "/api/goods", `{"match":"Bork","reward":5,"reward_type":"x"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = doAccrualRequest(t, h, http.MethodGet, "/api/orders/79927398713", "")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	rec = doAccrualRequest(t, h, http.MethodPost, "/api/orders",
+		`{"order":"79927398713","goods":[{"description":"Чайник Bork","price":7000}]}`)
+	require.Equal(t, http.StatusAccepted, rec.Code)
+
+	rec = doAccrualRequest(t, h, http.MethodPost, "/api/orders",
+		`{"order":"79927398713","goods":[]}`)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	rec = doAccrualRequest(t, h, http.MethodPost, "/api/orders",
+		`{"order":"4561261212345467","goods":[{"description":"Стиральная машинка LG","price":47399.99}]}`)
+	require.Equal(t, http.StatusAccepted, rec.Code)
+
+	rec = doAccrualRequest(t, h, http.MethodPost, "/api/orders", `{"order":"12345","goods":[]}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	expectStatus := func(number, status string, accrualSum float64) {
+		t.Helper()
+		rec := doAccrualRequest(t, h, http.MethodGet, "/api/orders/"+number, "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		var resp dto.AccrualResponse
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+		assert.Equal(t, number, resp.Order)
+		assert.Equal(t, status, resp.Status)
+		assert.Equal(t, money.FromFloat(accrualSum), resp.Accrual)
+	}
+
+	expectStatus("79927398713", accrual.StatusRegistered, 0)
+
+	storage.Advance()
+	expectStatus("79927398713", accrual.StatusProcessing, 0)
+
+	storage.Advance()
+	expectStatus("79927398713", accrual.StatusProcessed, 700)
+	expectStatus("4561261212345467", accrual.StatusInvalid, 0)
+}
+
+func TestAccrualServer_Throttling(t *testing.T) {
+	h := accrual.NewRouter(accrual.NewStorage(), accrual.Config{RequestsPerMinute: 2})
+
+	for i := 0; i < 2; i++ {
+		rec := doAccrualRequest(t, h, http.MethodGet, "/api/orders/79927398713", "")
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+	}
+
+	rec := doAccrualRequest(t, h, http.MethodGet, "/api/orders/79927398713", "")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+	assert.Equal(t, "No more than 2 requests per minute allowed", rec.Body.String())
+}
+
+func TestAccrualServer_FailureInjection(t *testing.T) {
+	h := accrual.NewRouter(accrual.NewStorage(), accrual.Config{FailureRate: 1})
+
+	rec := doAccrualRequest(t, h, http.MethodGet, "/api/orders/79927398713", "")
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+}
@@ -0,0 +1,166 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/alisaviation/internal/database"
+	"github.com/alisaviation/internal/gophermart/dto"
+	"github.com/alisaviation/internal/gophermart/models"
+	"github.com/alisaviation/internal/gophermart/services"
+	"github.com/alisaviation/internal/tests/mocks"
+	"github.com/alisaviation/pkg/money"
+)
+
+func TestAccrualWorker_ProcessPending(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name          string
+		mockSetup     func(*mocks.MockOrderDB, *mocks.MockAccrualClient)
+		expectedError error
+	}{
+		{
+			name: "no pending orders",
+			mockSetup: func(mdb *mocks.MockOrderDB, mac *mocks.MockAccrualClient) {
+				mdb.On("GetPendingOrders", 100).Return([]models.Order{}, nil)
+			},
+		},
+		{
+			name: "storage error",
+			mockSetup: func(mdb *mocks.MockOrderDB, mac *mocks.MockAccrualClient) {
+				mdb.On("GetPendingOrders", 100).Return(nil, errors.New("database error"))
+			},
+			expectedError: errors.New("failed to get pending orders: database error"),
+		},
+		{
+			name: "processed order is persisted",
+			mockSetup: func(mdb *mocks.MockOrderDB, mac *mocks.MockAccrualClient) {
+				mdb.On("GetPendingOrders", 100).Return([]models.Order{
+					{UserID: 1, Number: "123", Status: "NEW", UploadedAt: now},
+				}, nil)
+				mac.On("GetOrderAccrual", mock.Anything, "123").
+					Return(&dto.AccrualResponse{Order: "123", Status: "PROCESSED", Accrual: money.FromFloat(50)}, nil)
+				mdb.On("UpdateOrderFromAccrual", "123", "PROCESSED", money.FromFloat(50)).Return(nil)
+			},
+		},
+		{
+			name: "registered order moves to processing",
+			mockSetup: func(mdb *mocks.MockOrderDB, mac *mocks.MockAccrualClient) {
+				mdb.On("GetPendingOrders", 100).Return([]models.Order{
+					{UserID: 1, Number: "123", Status: "NEW", UploadedAt: now},
+				}, nil)
+				mac.On("GetOrderAccrual", mock.Anything, "123").
+					Return(&dto.AccrualResponse{Order: "123", Status: "REGISTERED"}, nil)
+				mdb.On("UpdateOrderFromAccrual", "123", "PROCESSING", money.Amount(0)).Return(nil)
+			},
+		},
+		{
+			name: "unchanged order is not updated",
+			mockSetup: func(mdb *mocks.MockOrderDB, mac *mocks.MockAccrualClient) {
+				mdb.On("GetPendingOrders", 100).Return([]models.Order{
+					{UserID: 1, Number: "123", Status: "PROCESSING", UploadedAt: now},
+				}, nil)
+				mac.On("GetOrderAccrual", mock.Anything, "123").
+					Return(&dto.AccrualResponse{Order: "123", Status: "PROCESSING"}, nil)
+			},
+		},
+		{
+			name: "unknown and failing orders are skipped",
+			mockSetup: func(mdb *mocks.MockOrderDB, mac *mocks.MockAccrualClient) {
+				mdb.On("GetPendingOrders", 100).Return([]models.Order{
+					{UserID: 1, Number: "123", Status: "NEW", UploadedAt: now},
+					{UserID: 1, Number: "456", Status: "NEW", UploadedAt: now},
+					{UserID: 2, Number: "789", Status: "NEW", UploadedAt: now},
+				}, nil)
+				mac.On("GetOrderAccrual", mock.Anything, "123").Return(nil, nil)
+				mac.On("GetOrderAccrual", mock.Anything, "456").Return(nil, errors.New("accrual system internal error"))
+				mac.On("GetOrderAccrual", mock.Anything, "789").
+					Return(&dto.AccrualResponse{Order: "789", Status: "INVALID"}, nil)
+				mdb.On("UpdateOrderFromAccrual", "789", "INVALID", money.Amount(0)).Return(nil)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockOrderDB := new(mocks.MockOrderDB)
+			mockAccrualClient := new(mocks.MockAccrualClient)
+			tt.mockSetup(mockOrderDB, mockAccrualClient)
+
+			worker := services.NewAccrualWorker(mockOrderDB, mockAccrualClient, 2, time.Second)
+			err := worker.ProcessPending(context.Background())
+
+			if tt.expectedError != nil {
+				assert.EqualError(t, err, tt.expectedError.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+
+			mockOrderDB.AssertExpectations(t)
+			mockAccrualClient.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAccrualWorker_RunStopsOnCancel(t *testing.T) {
+	mockOrderDB := new(mocks.MockOrderDB)
+	mockAccrualClient := new(mocks.MockAccrualClient)
+	mockOrderDB.On("GetPendingOrders", 100).Return([]models.Order{}, nil)
+
+	worker := services.NewAccrualWorker(mockOrderDB, mockAccrualClient, 1, 10*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		worker.Run(ctx)
+		close(done)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("worker did not stop after context cancellation")
+	}
+
+	mockOrderDB.AssertCalled(t, "GetPendingOrders", 100)
+}
+
+// ctxOrderDB fails like a real database driver once the caller's context
+// is done.
+type ctxOrderDB struct {
+	*mocks.MockOrderDB
+}
+
+func (db ctxOrderDB) GetPendingOrders(ctx context.Context, limit int) ([]models.Order, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return db.MockOrderDB.GetPendingOrders(ctx, limit)
+}
+
+func TestAccrualWorker_PropagatesContextToStorage(t *testing.T) {
+	worker := services.NewAccrualWorker(ctxOrderDB{new(mocks.MockOrderDB)}, new(mocks.MockAccrualClient), 1, time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := worker.ProcessPending(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestPollBackoff(t *testing.T) {
+	assert.Equal(t, time.Second, database.PollBackoff(0))
+	assert.Equal(t, 2*time.Second, database.PollBackoff(1))
+	assert.Equal(t, 64*time.Second, database.PollBackoff(6))
+	assert.Equal(t, database.PollBackoffMax, database.PollBackoff(9))
+	assert.Equal(t, database.PollBackoffMax, database.PollBackoff(1000))
+}
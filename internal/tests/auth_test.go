@@ -1,23 +1,33 @@
 package tests
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
 
 	"github.com/stretchr/testify/mock"
 
+	"github.com/alisaviation/internal/database"
+	"github.com/alisaviation/internal/database/memory"
 	"github.com/alisaviation/internal/gophermart/models"
 	"github.com/alisaviation/internal/gophermart/services"
+	"github.com/alisaviation/internal/middleware"
 	"github.com/alisaviation/internal/tests/mocks"
 )
 
 func Test_authService_Register(t *testing.T) {
 	tests := []struct {
 		name        string
-		setupMock   func(*mocks.MockUserRepository, *mocks.MockJWTService)
+		setupMock   func(*mocks.MockUserRepository, *mocks.MockTokenRepository, *mocks.MockJWTService)
 		login       string
 		password    string
 		want        string
@@ -26,10 +36,12 @@ func Test_authService_Register(t *testing.T) {
 	}{
 		{
 			name: "successful registration",
-			setupMock: func(mur *mocks.MockUserRepository, mjwt *mocks.MockJWTService) {
+			setupMock: func(mur *mocks.MockUserRepository, mtr *mocks.MockTokenRepository, mjwt *mocks.MockJWTService) {
 				mur.On("GetUserByLogin", "validuser").Return((*models.User)(nil), nil)
 				mur.On("CreateUser", mock.AnythingOfType("models.User")).Return(1, nil)
-				mjwt.On("GenerateToken", 1, "validuser").Return("generated.jwt.token", nil)
+				mtr.On("CreateSession", mock.AnythingOfType("string"), 1).Return(nil)
+				mtr.On("CreateRefreshToken", mock.AnythingOfType("*models.RefreshToken")).Return(nil)
+				mjwt.On("GenerateAccessToken", 1, "validuser", mock.AnythingOfType("string")).Return("generated.jwt.token", nil)
 			},
 			login:    "validuser",
 			password: "securepassword123",
@@ -38,7 +50,7 @@ func Test_authService_Register(t *testing.T) {
 		},
 		{
 			name: "login already taken",
-			setupMock: func(mur *mocks.MockUserRepository, mjwt *mocks.MockJWTService) {
+			setupMock: func(mur *mocks.MockUserRepository, mtr *mocks.MockTokenRepository, mjwt *mocks.MockJWTService) {
 				mur.On("GetUserByLogin", "existinguser").Return(&models.User{Login: "existinguser"}, nil)
 			},
 			login:       "existinguser",
@@ -49,7 +61,7 @@ func Test_authService_Register(t *testing.T) {
 		},
 		{
 			name: "database error on user check",
-			setupMock: func(mur *mocks.MockUserRepository, mjwt *mocks.MockJWTService) {
+			setupMock: func(mur *mocks.MockUserRepository, mtr *mocks.MockTokenRepository, mjwt *mocks.MockJWTService) {
 				mur.On("GetUserByLogin", "anyuser").Return((*models.User)(nil), fmt.Errorf("database connection failed"))
 			},
 			login:    "anyuser",
@@ -59,7 +71,7 @@ func Test_authService_Register(t *testing.T) {
 		},
 		{
 			name: "password hashing failed",
-			setupMock: func(mur *mocks.MockUserRepository, mjwt *mocks.MockJWTService) {
+			setupMock: func(mur *mocks.MockUserRepository, mtr *mocks.MockTokenRepository, mjwt *mocks.MockJWTService) {
 			},
 			login:    "validuser",
 			password: string([]byte{0xff}),
@@ -68,7 +80,7 @@ func Test_authService_Register(t *testing.T) {
 		},
 		{
 			name: "empty password",
-			setupMock: func(mur *mocks.MockUserRepository, mjwt *mocks.MockJWTService) {
+			setupMock: func(mur *mocks.MockUserRepository, mtr *mocks.MockTokenRepository, mjwt *mocks.MockJWTService) {
 			},
 			login:    "validuser",
 			password: "",
@@ -77,7 +89,7 @@ func Test_authService_Register(t *testing.T) {
 		},
 		{
 			name: "user creation failed",
-			setupMock: func(mur *mocks.MockUserRepository, mjwt *mocks.MockJWTService) {
+			setupMock: func(mur *mocks.MockUserRepository, mtr *mocks.MockTokenRepository, mjwt *mocks.MockJWTService) {
 				mur.On("GetUserByLogin", "validuser").Return((*models.User)(nil), nil)
 				mur.On("CreateUser", mock.AnythingOfType("models.User")).Return(0, fmt.Errorf("creation failed"))
 			},
@@ -88,10 +100,12 @@ func Test_authService_Register(t *testing.T) {
 		},
 		{
 			name: "token generation failed",
-			setupMock: func(mur *mocks.MockUserRepository, mjwt *mocks.MockJWTService) {
+			setupMock: func(mur *mocks.MockUserRepository, mtr *mocks.MockTokenRepository, mjwt *mocks.MockJWTService) {
 				mur.On("GetUserByLogin", "validuser").Return((*models.User)(nil), nil)
 				mur.On("CreateUser", mock.AnythingOfType("models.User")).Return(1, nil)
-				mjwt.On("GenerateToken", 1, "validuser").Return("", fmt.Errorf("token error"))
+				mtr.On("CreateSession", mock.AnythingOfType("string"), 1).Return(nil)
+				mtr.On("CreateRefreshToken", mock.AnythingOfType("*models.RefreshToken")).Return(nil)
+				mjwt.On("GenerateAccessToken", 1, "validuser", mock.AnythingOfType("string")).Return("", fmt.Errorf("token error"))
 			},
 			login:    "validuser",
 			password: "goodpassword",
@@ -103,17 +117,20 @@ func Test_authService_Register(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			mockUserRepo := &mocks.MockUserRepository{}
+			mockTokenRepo := &mocks.MockTokenRepository{}
 			mockJWT := &mocks.MockJWTService{}
 
 			if tt.setupMock != nil {
-				tt.setupMock(mockUserRepo, mockJWT)
+				tt.setupMock(mockUserRepo, mockTokenRepo, mockJWT)
 			}
 
 			s := &services.AuthStructService{
 				UserRepo:   mockUserRepo,
+				TokenRepo:  mockTokenRepo,
 				JwtService: mockJWT,
+				RefreshTTL: time.Hour,
 			}
-			got, err := s.Register(tt.login, tt.password)
+			got, err := s.Register(context.Background(), tt.login, tt.password)
 
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Register() error = %v, wantErr %v", err, tt.wantErr)
@@ -124,11 +141,12 @@ func Test_authService_Register(t *testing.T) {
 				t.Errorf("Register() expected error = %v, got %v", tt.expectedErr, err)
 			}
 
-			if !tt.wantErr && got != tt.want {
-				t.Errorf("Register() got = %v, want %v", got, tt.want)
+			if !tt.wantErr && (got.AccessToken != tt.want || got.RefreshToken == "") {
+				t.Errorf("Register() got = %+v, want access token %v", got, tt.want)
 			}
 
 			mockUserRepo.AssertExpectations(t)
+			mockTokenRepo.AssertExpectations(t)
 			mockJWT.AssertExpectations(t)
 		})
 	}
@@ -139,7 +157,7 @@ func Test_authService_Login(t *testing.T) {
 
 	tests := []struct {
 		name        string
-		setupMock   func(*mocks.MockUserRepository, *mocks.MockJWTService)
+		setupMock   func(*mocks.MockUserRepository, *mocks.MockTokenRepository, *mocks.MockJWTService)
 		login       string
 		password    string
 		want        string
@@ -148,13 +166,15 @@ func Test_authService_Login(t *testing.T) {
 	}{
 		{
 			name: "successful login",
-			setupMock: func(mur *mocks.MockUserRepository, mjwt *mocks.MockJWTService) {
+			setupMock: func(mur *mocks.MockUserRepository, mtr *mocks.MockTokenRepository, mjwt *mocks.MockJWTService) {
 				mur.On("GetUserByLogin", "validuser").Return(&models.User{
 					ID:           1,
 					Login:        "validuser",
 					PasswordHash: string(hashedPassword),
 				}, nil)
-				mjwt.On("GenerateToken", 1, "validuser").Return("generated.jwt.token", nil)
+				mtr.On("CreateSession", mock.AnythingOfType("string"), 1).Return(nil)
+				mtr.On("CreateRefreshToken", mock.AnythingOfType("*models.RefreshToken")).Return(nil)
+				mjwt.On("GenerateAccessToken", 1, "validuser", mock.AnythingOfType("string")).Return("generated.jwt.token", nil)
 			},
 			login:    "validuser",
 			password: "correctpassword",
@@ -163,7 +183,7 @@ func Test_authService_Login(t *testing.T) {
 		},
 		{
 			name: "invalid credentials - wrong password",
-			setupMock: func(mur *mocks.MockUserRepository, mjwt *mocks.MockJWTService) {
+			setupMock: func(mur *mocks.MockUserRepository, mtr *mocks.MockTokenRepository, mjwt *mocks.MockJWTService) {
 				mur.On("GetUserByLogin", "validuser").Return(&models.User{
 					Login:        "validuser",
 					PasswordHash: string(hashedPassword),
@@ -177,7 +197,7 @@ func Test_authService_Login(t *testing.T) {
 		},
 		{
 			name: "invalid credentials - user not found",
-			setupMock: func(mur *mocks.MockUserRepository, mjwt *mocks.MockJWTService) {
+			setupMock: func(mur *mocks.MockUserRepository, mtr *mocks.MockTokenRepository, mjwt *mocks.MockJWTService) {
 				mur.On("GetUserByLogin", "nonexistent").Return((*models.User)(nil), nil)
 			},
 			login:       "nonexistent",
@@ -188,7 +208,7 @@ func Test_authService_Login(t *testing.T) {
 		},
 		{
 			name: "empty login",
-			setupMock: func(mur *mocks.MockUserRepository, mjwt *mocks.MockJWTService) {
+			setupMock: func(mur *mocks.MockUserRepository, mtr *mocks.MockTokenRepository, mjwt *mocks.MockJWTService) {
 			},
 			login:       "",
 			password:    "anypassword",
@@ -198,7 +218,7 @@ func Test_authService_Login(t *testing.T) {
 		},
 		{
 			name: "empty password",
-			setupMock: func(mur *mocks.MockUserRepository, mjwt *mocks.MockJWTService) {
+			setupMock: func(mur *mocks.MockUserRepository, mtr *mocks.MockTokenRepository, mjwt *mocks.MockJWTService) {
 			},
 			login:       "validuser",
 			password:    "",
@@ -211,17 +231,20 @@ func Test_authService_Login(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			mockUserRepo := &mocks.MockUserRepository{}
+			mockTokenRepo := &mocks.MockTokenRepository{}
 			mockJWT := &mocks.MockJWTService{}
 
 			if tt.setupMock != nil {
-				tt.setupMock(mockUserRepo, mockJWT)
+				tt.setupMock(mockUserRepo, mockTokenRepo, mockJWT)
 			}
 
 			s := &services.AuthStructService{
 				UserRepo:   mockUserRepo,
+				TokenRepo:  mockTokenRepo,
 				JwtService: mockJWT,
+				RefreshTTL: time.Hour,
 			}
-			got, err := s.Login(tt.login, tt.password)
+			got, err := s.Login(context.Background(), tt.login, tt.password)
 
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Login() error = %v, wantErr %v", err, tt.wantErr)
@@ -232,12 +255,276 @@ func Test_authService_Login(t *testing.T) {
 				t.Errorf("Login() expected error = %v, got %v", tt.expectedErr, err)
 			}
 
-			if !tt.wantErr && got != tt.want {
-				t.Errorf("Login() = %v, want %v", got, tt.want)
+			if !tt.wantErr && (got.AccessToken != tt.want || got.RefreshToken == "") {
+				t.Errorf("Login() = %+v, want access token %v", got, tt.want)
 			}
 
 			mockUserRepo.AssertExpectations(t)
+			mockTokenRepo.AssertExpectations(t)
 			mockJWT.AssertExpectations(t)
 		})
 	}
 }
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func Test_authService_Refresh(t *testing.T) {
+	usedAt := time.Now().Add(-time.Minute)
+	validToken := func() *models.RefreshToken {
+		return &models.RefreshToken{
+			ID:        1,
+			UserID:    1,
+			Login:     "validuser",
+			SessionID: "session-1",
+			TokenHash: hashToken("refresh-1"),
+			ExpiresAt: time.Now().Add(time.Hour),
+		}
+	}
+
+	tests := []struct {
+		name        string
+		setupMock   func(*mocks.MockTokenRepository, *mocks.MockJWTService)
+		token       string
+		want        string
+		wantErr     bool
+		expectedErr error
+	}{
+		{
+			name: "successful rotation",
+			setupMock: func(mtr *mocks.MockTokenRepository, mjwt *mocks.MockJWTService) {
+				mtr.On("GetRefreshToken", hashToken("refresh-1")).Return(validToken(), nil)
+				mtr.On("RotateRefreshToken", hashToken("refresh-1"), mock.MatchedBy(func(next *models.RefreshToken) bool {
+					return next.SessionID == "session-1" && next.UserID == 1 && next.TokenHash != hashToken("refresh-1")
+				})).Return(nil)
+				mjwt.On("GenerateAccessToken", 1, "validuser", "session-1").Return("new.jwt.token", nil)
+			},
+			token: "refresh-1",
+			want:  "new.jwt.token",
+		},
+		{
+			name:        "empty token",
+			setupMock:   func(mtr *mocks.MockTokenRepository, mjwt *mocks.MockJWTService) {},
+			token:       "",
+			wantErr:     true,
+			expectedErr: services.ErrInvalidRefreshToken,
+		},
+		{
+			name: "unknown token",
+			setupMock: func(mtr *mocks.MockTokenRepository, mjwt *mocks.MockJWTService) {
+				mtr.On("GetRefreshToken", hashToken("unknown")).Return(nil, nil)
+			},
+			token:       "unknown",
+			wantErr:     true,
+			expectedErr: services.ErrInvalidRefreshToken,
+		},
+		{
+			name: "expired token",
+			setupMock: func(mtr *mocks.MockTokenRepository, mjwt *mocks.MockJWTService) {
+				token := validToken()
+				token.ExpiresAt = time.Now().Add(-time.Second)
+				mtr.On("GetRefreshToken", hashToken("refresh-1")).Return(token, nil)
+			},
+			token:       "refresh-1",
+			wantErr:     true,
+			expectedErr: services.ErrInvalidRefreshToken,
+		},
+		{
+			name: "revoked session",
+			setupMock: func(mtr *mocks.MockTokenRepository, mjwt *mocks.MockJWTService) {
+				token := validToken()
+				token.SessionRevoked = true
+				mtr.On("GetRefreshToken", hashToken("refresh-1")).Return(token, nil)
+			},
+			token:       "refresh-1",
+			wantErr:     true,
+			expectedErr: services.ErrInvalidRefreshToken,
+		},
+		{
+			name: "reused token revokes session",
+			setupMock: func(mtr *mocks.MockTokenRepository, mjwt *mocks.MockJWTService) {
+				token := validToken()
+				token.UsedAt = &usedAt
+				mtr.On("GetRefreshToken", hashToken("refresh-1")).Return(token, nil)
+				mtr.On("RevokeSession", "session-1").Return(nil)
+			},
+			token:       "refresh-1",
+			wantErr:     true,
+			expectedErr: services.ErrRefreshTokenReused,
+		},
+		{
+			name: "concurrent reuse revokes session",
+			setupMock: func(mtr *mocks.MockTokenRepository, mjwt *mocks.MockJWTService) {
+				mtr.On("GetRefreshToken", hashToken("refresh-1")).Return(validToken(), nil)
+				mtr.On("RotateRefreshToken", hashToken("refresh-1"), mock.AnythingOfType("*models.RefreshToken")).
+					Return(database.ErrRefreshTokenUsed)
+				mtr.On("RevokeSession", "session-1").Return(nil)
+			},
+			token:       "refresh-1",
+			wantErr:     true,
+			expectedErr: services.ErrRefreshTokenReused,
+		},
+		{
+			name: "logout during rotation",
+			setupMock: func(mtr *mocks.MockTokenRepository, mjwt *mocks.MockJWTService) {
+				mtr.On("GetRefreshToken", hashToken("refresh-1")).Return(validToken(), nil)
+				mtr.On("RotateRefreshToken", hashToken("refresh-1"), mock.AnythingOfType("*models.RefreshToken")).
+					Return(database.ErrSessionRevoked)
+			},
+			token:       "refresh-1",
+			wantErr:     true,
+			expectedErr: services.ErrInvalidRefreshToken,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockTokenRepo := &mocks.MockTokenRepository{}
+			mockJWT := &mocks.MockJWTService{}
+			tt.setupMock(mockTokenRepo, mockJWT)
+
+			s := &services.AuthStructService{
+				TokenRepo:  mockTokenRepo,
+				JwtService: mockJWT,
+				RefreshTTL: time.Hour,
+			}
+			got, err := s.Refresh(context.Background(), tt.token)
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Refresh() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr && !errors.Is(err, tt.expectedErr) {
+				t.Errorf("Refresh() expected error = %v, got %v", tt.expectedErr, err)
+			}
+			if !tt.wantErr && (got.AccessToken != tt.want || got.RefreshToken == "" || got.RefreshToken == tt.token) {
+				t.Errorf("Refresh() = %+v, want access token %v and a new refresh token", got, tt.want)
+			}
+
+			mockTokenRepo.AssertExpectations(t)
+			mockJWT.AssertExpectations(t)
+		})
+	}
+}
+
+func Test_authService_Logout(t *testing.T) {
+	expiresAt := time.Now().Add(10 * time.Minute).Truncate(time.Second)
+	claims := &services.Claims{
+		UserID:    1,
+		Login:     "validuser",
+		SessionID: "session-1",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "jti-1",
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+
+	mockTokenRepo := &mocks.MockTokenRepository{}
+	mockTokenRepo.On("RevokeSession", "session-1").Return(nil)
+	mockTokenRepo.On("RevokeAccessToken", "jti-1", expiresAt).Return(nil)
+
+	s := &services.AuthStructService{TokenRepo: mockTokenRepo}
+	if err := s.Logout(context.Background(), claims); err != nil {
+		t.Fatalf("Logout() error = %v", err)
+	}
+
+	mockTokenRepo.AssertExpectations(t)
+}
+
+func Test_authService_LogoutWithoutJTI(t *testing.T) {
+	storage := memory.NewMemoryStorage()
+	jwtService := services.NewJWTService([]byte("test_secret_key"), "test_issuer")
+	authService := services.NewAuthService(storage, storage, jwtService, time.Hour)
+
+	// Tokens issued before refresh tokens existed carry no jti or session.
+	legacyToken := func(userID int, login string) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &services.Claims{
+			UserID: userID,
+			Login:  login,
+			RegisteredClaims: jwt.RegisteredClaims{
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+				Issuer:    "test_issuer",
+			},
+		}).SignedString([]byte("test_secret_key"))
+		if err != nil {
+			t.Fatalf("SignedString() error = %v", err)
+		}
+		return token
+	}
+	alice, bob := legacyToken(1, "alice"), legacyToken(2, "bob")
+
+	claims, err := jwtService.ValidateToken(alice)
+	if err != nil {
+		t.Fatalf("ValidateToken() error = %v", err)
+	}
+	if err := authService.Logout(context.Background(), claims); err != nil {
+		t.Fatalf("Logout() error = %v", err)
+	}
+
+	handler := middleware.AuthMiddleware(jwtService, storage)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/api/user/orders", nil)
+	req.Header.Set("Authorization", "Bearer "+bob)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("another user's token: status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	// Without a jti there is nothing to revoke.
+	mockTokenRepo := &mocks.MockTokenRepository{}
+	s := &services.AuthStructService{TokenRepo: mockTokenRepo}
+	if err := s.Logout(context.Background(), claims); err != nil {
+		t.Fatalf("Logout() error = %v", err)
+	}
+	mockTokenRepo.AssertNotCalled(t, "RevokeAccessToken", mock.Anything, mock.Anything)
+}
+
+func TestAuthMiddleware_RejectsRevokedTokens(t *testing.T) {
+	jwtService := services.NewJWTService([]byte("test_secret_key"), "test_issuer")
+	token, err := jwtService.GenerateAccessToken(1, "validuser", "session-1")
+	if err != nil {
+		t.Fatalf("GenerateAccessToken() error = %v", err)
+	}
+	claims, err := jwtService.ValidateToken(token)
+	if err != nil {
+		t.Fatalf("ValidateToken() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		revoked    bool
+		wantStatus int
+	}{
+		{name: "active token", revoked: false, wantStatus: http.StatusOK},
+		{name: "revoked token", revoked: true, wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockTokenRepo := &mocks.MockTokenRepository{}
+			mockTokenRepo.On("IsTokenRevoked", claims.ID, "session-1").Return(tt.revoked, nil)
+
+			handler := middleware.AuthMiddleware(jwtService, mockTokenRepo)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if _, ok := r.Context().Value(middleware.ClaimsKey).(*services.Claims); !ok {
+					t.Error("claims missing from context")
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/api/user/orders", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			mockTokenRepo.AssertExpectations(t)
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package tests
 
 import (
+	"context"
 	"errors"
 	"net/http"
 	"reflect"
@@ -10,98 +11,14 @@ import (
 
 	"github.com/stretchr/testify/mock"
 
+	"github.com/alisaviation/internal/database"
 	"github.com/alisaviation/internal/gophermart/dto"
 	"github.com/alisaviation/internal/gophermart/models"
 	"github.com/alisaviation/internal/gophermart/services"
 	"github.com/alisaviation/internal/tests/mocks"
+	"github.com/alisaviation/pkg/money"
 )
 
-func TestBalancesService_CreateWithdrawal(t *testing.T) {
-	tests := []struct {
-		name        string
-		setupMock   func(*mocks.MockBalance)
-		args        *models.Withdrawal
-		wantErr     bool
-		errContains string
-	}{
-		{
-			name: "successful withdrawal creation",
-			setupMock: func(mb *mocks.MockBalance) {
-				mb.On("WithdrawalExists", "123").Return(false, nil)
-				mb.On("CreateWithdrawal", &models.Withdrawal{
-					UserID:      1,
-					OrderNumber: "123",
-					Sum:         100.5,
-				}).Return(nil)
-			},
-			args: &models.Withdrawal{
-				UserID:      1,
-				OrderNumber: "123",
-				Sum:         100.5,
-			},
-			wantErr: false,
-		},
-		{
-			name: "withdrawal already exists",
-			setupMock: func(mb *mocks.MockBalance) {
-				mb.On("WithdrawalExists", "123").Return(true, nil)
-			},
-			args: &models.Withdrawal{
-				OrderNumber: "123",
-			},
-			wantErr:     true,
-			errContains: "withdrawal for order 123 already exists",
-		},
-		{
-			name: "error checking withdrawal existence",
-			setupMock: func(mb *mocks.MockBalance) {
-				mb.On("WithdrawalExists", "123").Return(false, errors.New("database error"))
-			},
-			args: &models.Withdrawal{
-				OrderNumber: "123",
-			},
-			wantErr:     true,
-			errContains: "failed to check withdrawal existence",
-		},
-		{
-			name: "error creating withdrawal",
-			setupMock: func(mb *mocks.MockBalance) {
-				mb.On("WithdrawalExists", "123").Return(false, nil)
-				mb.On("CreateWithdrawal", mock.Anything).Return(errors.New("database error"))
-			},
-			args: &models.Withdrawal{
-				OrderNumber: "123",
-			},
-			wantErr:     true,
-			errContains: "failed to create withdrawal",
-		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			mockBalance := new(mocks.MockBalance)
-			tt.setupMock(mockBalance)
-
-			s := &services.BalancesService{
-				Balance: mockBalance,
-			}
-
-			err := s.CreateWithdrawal(tt.args)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("CreateWithdrawal() error = %v, wantErr %v", err, tt.wantErr)
-			}
-
-			if tt.wantErr && tt.errContains != "" && err != nil {
-				if !contains(err.Error(), tt.errContains) {
-					t.Errorf("CreateWithdrawal() error = %v, should contain %v", err, tt.errContains)
-				}
-			}
-
-			mockBalance.AssertExpectations(t)
-		})
-	}
-}
-
 func TestBalancesService_GetUserBalance(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -116,14 +33,14 @@ func TestBalancesService_GetUserBalance(t *testing.T) {
 			setupMock: func(mb *mocks.MockBalance) {
 				mb.On("GetBalance", 1).Return(&models.Balance{
 					UserID:    1,
-					Current:   500.75,
-					Withdrawn: 100.25,
+					Current:   money.FromFloat(500.75),
+					Withdrawn: money.FromFloat(100.25),
 				}, nil)
 			},
 			userID: 1,
 			want: &dto.BalanceResponse{
-				Current:   500.75,
-				Withdrawn: 100.25,
+				Current:   money.FromFloat(500.75),
+				Withdrawn: money.FromFloat(100.25),
 			},
 			wantStatus: http.StatusOK,
 			wantErr:    false,
@@ -149,7 +66,7 @@ func TestBalancesService_GetUserBalance(t *testing.T) {
 				Balance: mockBalance,
 			}
 
-			got, status, err := s.GetUserBalance(tt.userID)
+			got, status, err := s.GetUserBalance(context.Background(), tt.userID)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("GetUserBalance() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -265,13 +182,13 @@ func TestBalancesService_GetUserWithdrawals(t *testing.T) {
 					{
 						UserID:      1,
 						OrderNumber: "123",
-						Sum:         100.5,
+						Sum:         money.FromFloat(100.5),
 						ProcessedAt: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
 					},
 					{
 						UserID:      1,
 						OrderNumber: "456",
-						Sum:         200.75,
+						Sum:         money.FromFloat(200.75),
 						ProcessedAt: time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC),
 					},
 				}, nil)
@@ -280,12 +197,12 @@ func TestBalancesService_GetUserWithdrawals(t *testing.T) {
 			want: []dto.WithdrawalResponse{
 				{
 					Order:       "123",
-					Sum:         100.5,
+					Sum:         money.FromFloat(100.5),
 					ProcessedAt: "2023-01-01T00:00:00Z",
 				},
 				{
 					Order:       "456",
-					Sum:         200.75,
+					Sum:         money.FromFloat(200.75),
 					ProcessedAt: "2023-01-02T00:00:00Z",
 				},
 			},
@@ -323,7 +240,7 @@ func TestBalancesService_GetUserWithdrawals(t *testing.T) {
 				Balance: mockBalance,
 			}
 
-			got, status, err := s.GetUserWithdrawals(tt.userID)
+			got, status, err := s.GetUserWithdrawals(context.Background(), tt.userID)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("GetUserWithdrawals() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -388,7 +305,7 @@ func TestBalancesService_WithdrawalExists(t *testing.T) {
 				Balance: mockBalance,
 			}
 
-			got, err := s.WithdrawalExists(tt.orderNumber)
+			got, err := s.WithdrawalExists(context.Background(), tt.orderNumber)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("WithdrawalExists() error = %v, wantErr %v", err, tt.wantErr)
 			}
@@ -402,6 +319,110 @@ func TestBalancesService_WithdrawalExists(t *testing.T) {
 	}
 }
 
+func TestBalancesService_GetWithdrawal(t *testing.T) {
+	tests := []struct {
+		name        string
+		setupMock   func(*mocks.MockBalance)
+		req         dto.WithdrawRequest
+		wantStatus  int
+		wantErr     bool
+		errContains string
+	}{
+		{
+			name: "successful withdrawal",
+			setupMock: func(mb *mocks.MockBalance) {
+				mb.On("Withdraw", mock.MatchedBy(func(w *models.Withdrawal) bool {
+					return w.UserID == 1 && w.OrderNumber == "2377225624" && w.Sum == money.FromFloat(751)
+				})).Return(nil)
+			},
+			req:        dto.WithdrawRequest{Order: "2377225624", Sum: money.FromFloat(751)},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:        "invalid order number",
+			setupMock:   func(mb *mocks.MockBalance) {},
+			req:         dto.WithdrawRequest{Order: "2377225625", Sum: money.FromFloat(751)},
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantErr:     true,
+			errContains: "invalid order number",
+		},
+		{
+			name:        "negative sum",
+			setupMock:   func(mb *mocks.MockBalance) {},
+			req:         dto.WithdrawRequest{Order: "2377225624", Sum: money.FromFloat(-1000)},
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantErr:     true,
+			errContains: "must be positive",
+		},
+		{
+			name:        "zero sum",
+			setupMock:   func(mb *mocks.MockBalance) {},
+			req:         dto.WithdrawRequest{Order: "2377225624"},
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantErr:     true,
+			errContains: "must be positive",
+		},
+		{
+			name: "insufficient funds",
+			setupMock: func(mb *mocks.MockBalance) {
+				mb.On("Withdraw", mock.Anything).Return(database.ErrInsufficientFunds)
+			},
+			req:         dto.WithdrawRequest{Order: "2377225624", Sum: money.FromFloat(751)},
+			wantStatus:  http.StatusPaymentRequired,
+			wantErr:     true,
+			errContains: "insufficient funds",
+		},
+		{
+			name: "withdrawal already exists",
+			setupMock: func(mb *mocks.MockBalance) {
+				mb.On("Withdraw", mock.Anything).Return(database.ErrWithdrawalExists)
+			},
+			req:         dto.WithdrawRequest{Order: "2377225624", Sum: money.FromFloat(751)},
+			wantStatus:  http.StatusConflict,
+			wantErr:     true,
+			errContains: "already exists",
+		},
+		{
+			name: "storage error",
+			setupMock: func(mb *mocks.MockBalance) {
+				mb.On("Withdraw", mock.Anything).Return(errors.New("database error"))
+			},
+			req:         dto.WithdrawRequest{Order: "2377225624", Sum: money.FromFloat(751)},
+			wantStatus:  http.StatusInternalServerError,
+			wantErr:     true,
+			errContains: "failed to register withdrawal",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockBalance := new(mocks.MockBalance)
+			tt.setupMock(mockBalance)
+
+			s := &services.BalancesService{
+				Balance: mockBalance,
+			}
+
+			status, _, err := s.GetWithdrawal(context.Background(), tt.req, 1)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetWithdrawal() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if status != tt.wantStatus {
+				t.Errorf("GetWithdrawal() status = %d, want %d", status, tt.wantStatus)
+			}
+
+			if tt.wantErr && tt.errContains != "" && err != nil {
+				if !contains(err.Error(), tt.errContains) {
+					t.Errorf("GetWithdrawal() error = %v, should contain %v", err, tt.errContains)
+				}
+			}
+
+			mockBalance.AssertExpectations(t)
+		})
+	}
+}
+
 func contains(s, substr string) bool {
 	return strings.Contains(s, substr)
 }
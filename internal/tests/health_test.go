@@ -0,0 +1,165 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/alisaviation/internal/gophermart/services"
+	"github.com/alisaviation/internal/handlers"
+	"github.com/alisaviation/internal/health"
+)
+
+func checkUp(context.Context) error { return nil }
+
+func checkDown(context.Context) error { return errors.New("connection refused") }
+
+func getReadiness(t *testing.T, handler *handlers.HealthHandler) (int, health.Report) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	handler.Ready(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	var report health.Report
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	return rec.Code, report
+}
+
+func TestHealthHandler_Live(t *testing.T) {
+	readiness := health.NewReadiness()
+	readiness.Add("database", checkDown)
+	handler := handlers.NewHealthHandler(readiness)
+
+	rec := httptest.NewRecorder()
+	handler.Live(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code, "liveness ignores dependencies")
+	assert.JSONEq(t, `{"status":"ok"}`, rec.Body.String())
+}
+
+func TestHealthHandler_Ready(t *testing.T) {
+	t.Run("all up", func(t *testing.T) {
+		readiness := health.NewReadiness()
+		readiness.Add("database", checkUp)
+		readiness.AddOptional("accrual", checkUp)
+
+		code, report := getReadiness(t, handlers.NewHealthHandler(readiness))
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, health.StatusReady, report.Status)
+		assert.Equal(t, health.ComponentStatus{Status: health.ComponentUp}, report.Components["database"])
+		assert.Equal(t, health.ComponentStatus{Status: health.ComponentUp, Optional: true}, report.Components["accrual"])
+	})
+
+	t.Run("required component down", func(t *testing.T) {
+		readiness := health.NewReadiness()
+		readiness.Add("database", checkDown)
+		readiness.Add("migrations", checkUp)
+
+		code, report := getReadiness(t, handlers.NewHealthHandler(readiness))
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Equal(t, health.StatusNotReady, report.Status)
+		assert.Equal(t, health.ComponentDown, report.Components["database"].Status)
+		assert.Equal(t, "connection refused", report.Components["database"].Error)
+		assert.Equal(t, health.ComponentUp, report.Components["migrations"].Status)
+	})
+
+	t.Run("optional component down", func(t *testing.T) {
+		readiness := health.NewReadiness()
+		readiness.Add("database", checkUp)
+		readiness.AddOptional("accrual", checkDown)
+
+		code, report := getReadiness(t, handlers.NewHealthHandler(readiness))
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, health.StatusReady, report.Status)
+		assert.Equal(t, health.ComponentDown, report.Components["accrual"].Status)
+	})
+
+	t.Run("check timeout", func(t *testing.T) {
+		readiness := health.NewReadiness()
+		readiness.Timeout = 10 * time.Millisecond
+		readiness.Add("database", func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		})
+
+		code, report := getReadiness(t, handlers.NewHealthHandler(readiness))
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Equal(t, context.DeadlineExceeded.Error(), report.Components["database"].Error)
+	})
+
+	t.Run("shutting down", func(t *testing.T) {
+		var calls atomic.Int32
+		readiness := health.NewReadiness()
+		readiness.Add("database", func(context.Context) error {
+			calls.Add(1)
+			return nil
+		})
+		handler := handlers.NewHealthHandler(readiness)
+
+		code, _ := getReadiness(t, handler)
+		require.Equal(t, http.StatusOK, code)
+
+		readiness.ShutDown()
+		code, report := getReadiness(t, handler)
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Equal(t, health.StatusShuttingDown, report.Status)
+		assert.Empty(t, report.Components)
+		assert.Equal(t, int32(1), calls.Load(), "checks are skipped once shutdown began")
+	})
+}
+
+func TestHealthCached(t *testing.T) {
+	var calls atomic.Int32
+	result := errors.New("unreachable")
+	check := health.Cached(func(context.Context) error {
+		calls.Add(1)
+		return result
+	}, 50*time.Millisecond)
+
+	assert.Equal(t, result, check(t.Context()))
+	result = nil
+	assert.Error(t, check(t.Context()), "the cached failure is served within the TTL")
+	assert.Equal(t, int32(1), calls.Load())
+
+	time.Sleep(60 * time.Millisecond)
+	assert.NoError(t, check(t.Context()))
+	assert.Equal(t, int32(2), calls.Load())
+
+	cancelled := health.Cached(func(ctx context.Context) error {
+		calls.Add(1)
+		return ctx.Err()
+	}, time.Hour)
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	assert.ErrorIs(t, cancelled(ctx), context.Canceled)
+	assert.NoError(t, cancelled(t.Context()), "a cancelled run is not cached")
+	assert.Equal(t, int32(4), calls.Load())
+}
+
+func TestAccrualClient_Ping(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusNoContent)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(status.Load()))
+	}))
+
+	client, isPinger := services.NewAccrualClient(srv.URL, 0).(interface{ Ping(context.Context) error })
+	require.True(t, isPinger)
+
+	assert.NoError(t, client.Ping(t.Context()))
+
+	status.Store(http.StatusTooManyRequests)
+	assert.NoError(t, client.Ping(t.Context()), "a rate-limited answer is still an answer")
+
+	status.Store(http.StatusInternalServerError)
+	assert.Error(t, client.Ping(t.Context()))
+
+	srv.Close()
+	assert.Error(t, client.Ping(t.Context()))
+}
@@ -0,0 +1,231 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/alisaviation/internal/database"
+	"github.com/alisaviation/internal/database/memory"
+	"github.com/alisaviation/internal/gophermart/models"
+	"github.com/alisaviation/internal/middleware"
+	"github.com/alisaviation/internal/tests/mocks"
+	"github.com/alisaviation/pkg/logger"
+)
+
+func sameRequestHash(stored *models.IdempotencyRecord) func(mock.Arguments) {
+	return func(args mock.Arguments) {
+		stored.RequestHash = args.Get(0).(*models.IdempotencyRecord).RequestHash
+	}
+}
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	const body = `{"order":"2377225624","sum":751}`
+
+	tests := []struct {
+		name            string
+		key             string
+		handlerStatus   int
+		setupMock       func(*mocks.MockIdempotencyStore)
+		wantStatus      int
+		wantBody        string
+		wantHandlerCall bool
+		wantReplayed    bool
+	}{
+		{
+			name:            "no key passes through",
+			handlerStatus:   http.StatusOK,
+			setupMock:       func(ms *mocks.MockIdempotencyStore) {},
+			wantStatus:      http.StatusOK,
+			wantBody:        `{"ok":true}`,
+			wantHandlerCall: true,
+		},
+		{
+			name:          "first request is recorded",
+			key:           "k1",
+			handlerStatus: http.StatusOK,
+			setupMock: func(ms *mocks.MockIdempotencyStore) {
+				ms.On("ReserveIdempotencyKey", mock.MatchedBy(func(r *models.IdempotencyRecord) bool {
+					return r.UserID == 1 && r.Key == "k1" && r.RequestHash != "" &&
+						r.LockedUntil.After(time.Now()) && r.LockedUntil.Before(r.ExpiresAt)
+				})).Return(nil, true, nil)
+				ms.On("CompleteIdempotencyKey", mock.MatchedBy(func(r *models.IdempotencyRecord) bool {
+					return r.Completed && r.StatusCode == http.StatusOK && string(r.Body) == `{"ok":true}` &&
+						r.Headers["Content-Type"][0] == "application/json" && r.Headers["X-Request-Id"] == nil
+				})).Return(nil)
+			},
+			wantStatus:      http.StatusOK,
+			wantBody:        `{"ok":true}`,
+			wantHandlerCall: true,
+		},
+		{
+			name: "retry replays stored response",
+			key:  "k1",
+			setupMock: func(ms *mocks.MockIdempotencyStore) {
+				stored := &models.IdempotencyRecord{
+					UserID:     1,
+					Key:        "k1",
+					Completed:  true,
+					StatusCode: http.StatusPaymentRequired,
+					Headers:    map[string][]string{"Content-Type": {"text/plain"}, "X-Request-Id": {"original"}},
+					Body:       []byte("insufficient funds\n"),
+				}
+				ms.On("ReserveIdempotencyKey", mock.Anything).
+					Run(sameRequestHash(stored)).
+					Return(stored, false, nil)
+			},
+			wantStatus:   http.StatusPaymentRequired,
+			wantBody:     "insufficient funds\n",
+			wantReplayed: true,
+		},
+		{
+			name: "key reused with different body",
+			key:  "k1",
+			setupMock: func(ms *mocks.MockIdempotencyStore) {
+				ms.On("ReserveIdempotencyKey", mock.Anything).Return(&models.IdempotencyRecord{
+					RequestHash: "other",
+					Completed:   true,
+				}, false, nil)
+			},
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name: "concurrent request in progress",
+			key:  "k1",
+			setupMock: func(ms *mocks.MockIdempotencyStore) {
+				stored := &models.IdempotencyRecord{}
+				ms.On("ReserveIdempotencyKey", mock.Anything).
+					Run(sameRequestHash(stored)).
+					Return(stored, false, nil)
+			},
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name:          "server error releases key",
+			key:           "k1",
+			handlerStatus: http.StatusInternalServerError,
+			setupMock: func(ms *mocks.MockIdempotencyStore) {
+				ms.On("ReserveIdempotencyKey", mock.Anything).Return(nil, true, nil)
+				ms.On("ReleaseIdempotencyKey", 1, "k1").Return(nil)
+			},
+			wantStatus:      http.StatusInternalServerError,
+			wantBody:        `{"ok":true}`,
+			wantHandlerCall: true,
+		},
+		{
+			name: "storage error",
+			key:  "k1",
+			setupMock: func(ms *mocks.MockIdempotencyStore) {
+				ms.On("ReserveIdempotencyKey", mock.Anything).Return(nil, false, errors.New("database error"))
+			},
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := new(mocks.MockIdempotencyStore)
+
+			tt.setupMock(store)
+
+			called := false
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.handlerStatus)
+				w.Write([]byte(`{"ok":true}`))
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/api/user/balance/withdraw", strings.NewReader(body))
+			if tt.key != "" {
+				req.Header.Set(middleware.IdempotencyKeyHeader, tt.key)
+			}
+			req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, 1))
+			rec := httptest.NewRecorder()
+
+			middleware.RequestIDMiddleware(middleware.IdempotencyMiddleware(store, time.Hour)(handler)).ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			assert.Equal(t, tt.wantHandlerCall, called)
+			if tt.wantBody != "" {
+				assert.Equal(t, tt.wantBody, rec.Body.String())
+			}
+			if tt.wantReplayed {
+				assert.Equal(t, "true", rec.Header().Get(middleware.IdempotentReplayedHeader))
+			}
+			assert.Len(t, rec.Header().Values(logger.RequestIDHeader), 1, "a replay carries only its own request ID")
+			assert.NotEqual(t, "original", rec.Header().Get(logger.RequestIDHeader))
+
+			store.AssertExpectations(t)
+		})
+	}
+}
+
+func TestIdempotencyMiddleware_RejectsOversizedBody(t *testing.T) {
+	store := new(mocks.MockIdempotencyStore)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler must not be called")
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/user/orders/batch", strings.NewReader(strings.Repeat(" ", 2<<20)))
+	req.Header.Set(middleware.IdempotencyKeyHeader, "k1")
+	req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, 1))
+	rec := httptest.NewRecorder()
+
+	middleware.IdempotencyMiddleware(store, time.Hour)(handler).ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	store.AssertNotCalled(t, "ReserveIdempotencyKey", mock.Anything)
+}
+
+func TestIdempotencyMiddleware_RetryTakesOverAbandonedKey(t *testing.T) {
+	storage := memory.NewMemoryStorage()
+	userID, err := storage.CreateUser(t.Context(), models.User{Login: "crashed", PasswordHash: "hash"})
+	require.NoError(t, err)
+
+	serve := func(store database.Idempotency, key string, handler http.HandlerFunc) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/user/orders", strings.NewReader("79927398713"))
+		req.Header.Set(middleware.IdempotencyKeyHeader, key)
+		req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, userID))
+		rec := httptest.NewRecorder()
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			middleware.IdempotencyMiddleware(store, time.Hour)(handler).ServeHTTP(rec, req)
+		}()
+		<-done
+		return rec.Code
+	}
+	// crash stops the request where a killed process would, leaving its key
+	// reserved and unfinished.
+	crash := func(w http.ResponseWriter, r *http.Request) { runtime.Goexit() }
+	accept := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusAccepted) }
+
+	serve(backdatedLocks{storage}, "k1", crash)
+	assert.Equal(t, http.StatusAccepted, serve(storage, "k1", accept), "a retry after the lock ran out proceeds")
+	assert.Equal(t, http.StatusAccepted, serve(storage, "k1", crash), "and its outcome is replayed")
+
+	serve(storage, "k2", crash)
+	assert.Equal(t, http.StatusConflict, serve(storage, "k2", accept), "a retry within the lock is still refused")
+}
+
+// backdatedLocks reserves keys whose lock has already run out, as if the
+// request had been running for longer than the lock timeout.
+type backdatedLocks struct {
+	database.Idempotency
+}
+
+func (s backdatedLocks) ReserveIdempotencyKey(ctx context.Context, record *models.IdempotencyRecord) (*models.IdempotencyRecord, bool, error) {
+	record.LockedUntil = time.Now().Add(-time.Second)
+	return s.Idempotency.ReserveIdempotencyKey(ctx, record)
+}
@@ -1,9 +1,16 @@
 package tests
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
 	"testing"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/alisaviation/internal/gophermart/services"
 )
@@ -200,3 +207,96 @@ func TestJWTService_ValidateToken(t *testing.T) {
 		})
 	}
 }
+
+func TestJWTService_KeyRotation(t *testing.T) {
+	oldKey := services.NewHMACKey([]byte("old_secret"))
+	newKey := services.NewHMACKey([]byte("new_secret"))
+
+	oldKeys, err := services.NewKeySet(oldKey)
+	require.NoError(t, err)
+	oldToken, err := services.NewJWTServiceWithKeys(oldKeys, "test_issuer").GenerateToken(1, "testuser")
+	require.NoError(t, err)
+
+	rotated, err := services.NewKeySet(newKey, oldKey)
+	require.NoError(t, err)
+	rotatedService := services.NewJWTServiceWithKeys(rotated, "test_issuer")
+
+	claims, err := rotatedService.ValidateToken(oldToken)
+	require.NoError(t, err)
+	assert.Equal(t, 1, claims.UserID)
+
+	newToken, err := rotatedService.GenerateToken(2, "other")
+	require.NoError(t, err)
+	parsed, _, err := jwt.NewParser().ParseUnverified(newToken, &services.Claims{})
+	require.NoError(t, err)
+	assert.Equal(t, newKey.ID, parsed.Header["kid"])
+
+	retired, err := services.NewKeySet(newKey)
+	require.NoError(t, err)
+	_, err = services.NewJWTServiceWithKeys(retired, "test_issuer").ValidateToken(oldToken)
+	assert.ErrorIs(t, err, services.ErrUnknownKey)
+}
+
+func TestJWTService_AsymmetricKeys(t *testing.T) {
+	rsaPrivate, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	_, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		private interface{}
+		alg     string
+		kty     string
+	}{
+		{name: "RS256", private: rsaPrivate, alg: services.AlgorithmRS256, kty: "RSA"},
+		{name: "EdDSA", private: edPrivate, alg: services.AlgorithmEdDSA, kty: "OKP"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			der, err := x509.MarshalPKCS8PrivateKey(tt.private)
+			require.NoError(t, err)
+			key, err := services.ParsePrivateKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+			require.NoError(t, err)
+			assert.Equal(t, tt.alg, key.Method.Alg())
+
+			keys, err := services.NewKeySet(key)
+			require.NoError(t, err)
+			s := services.NewJWTServiceWithKeys(keys, "test_issuer")
+
+			token, err := s.GenerateToken(1, "testuser")
+			require.NoError(t, err)
+			claims, err := s.ValidateToken(token)
+			require.NoError(t, err)
+			assert.Equal(t, "testuser", claims.Login)
+
+			publicDER, err := x509.MarshalPKIXPublicKey(key.Public)
+			require.NoError(t, err)
+			verifier, err := services.ParsePublicKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}))
+			require.NoError(t, err)
+			assert.Equal(t, key.ID, verifier.ID)
+
+			jwks := keys.JWKS()
+			require.Len(t, jwks.Keys, 1)
+			assert.Equal(t, tt.kty, jwks.Keys[0].Kty)
+			assert.Equal(t, tt.alg, jwks.Keys[0].Alg)
+			assert.Equal(t, key.ID, jwks.Keys[0].Kid)
+		})
+	}
+}
+
+func TestJWTService_RejectsAlgorithmMismatch(t *testing.T) {
+	hmacKey := services.NewHMACKey([]byte("test_secret_key"))
+	keys, err := services.NewKeySet(hmacKey)
+	require.NoError(t, err)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS512, &services.Claims{UserID: 1})
+	token.Header["kid"] = hmacKey.ID
+	signed, err := token.SignedString([]byte("test_secret_key"))
+	require.NoError(t, err)
+
+	_, err = services.NewJWTServiceWithKeys(keys, "").ValidateToken(signed)
+	assert.Error(t, err)
+	assert.Empty(t, keys.JWKS().Keys)
+}
@@ -0,0 +1,150 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/alisaviation/internal/database"
+	"github.com/alisaviation/internal/gophermart/models"
+	"github.com/alisaviation/pkg/money"
+)
+
+func TestLedger_EntriesAreBalanced(t *testing.T) {
+	now := time.Now()
+	withdrawal := &models.Withdrawal{ID: 7, UserID: 1, OrderNumber: "2377225624", Sum: money.FromFloat(42.5), ProcessedAt: now}
+
+	tests := []struct {
+		name          string
+		entry         models.JournalEntry
+		wantKind      string
+		wantCurrent   money.Amount
+		wantWithdrawn money.Amount
+	}{
+		{
+			name:        "accrual credit",
+			entry:       database.NewAccrualEntry(1, "79927398713", money.FromFloat(500), now),
+			wantKind:    database.EntryAccrual,
+			wantCurrent: money.FromFloat(500),
+		},
+		{
+			name:        "negative accrual is a reversal",
+			entry:       database.NewAccrualEntry(1, "79927398713", money.FromFloat(-10), now),
+			wantKind:    database.EntryReversal,
+			wantCurrent: money.FromFloat(-10),
+		},
+		{
+			name:          "withdrawal debit",
+			entry:         database.NewWithdrawalEntry(withdrawal),
+			wantKind:      database.EntryWithdrawal,
+			wantCurrent:   money.FromFloat(-42.5),
+			wantWithdrawn: money.FromFloat(42.5),
+		},
+		{
+			name:          "withdrawal reversal",
+			entry:         database.NewReversalEntry(database.NewWithdrawalEntry(withdrawal), "refund", now),
+			wantKind:      database.EntryReversal,
+			wantCurrent:   money.FromFloat(42.5),
+			wantWithdrawn: money.FromFloat(-42.5),
+		},
+		{
+			name:        "manual adjustment",
+			entry:       database.NewAdjustmentEntry(1, money.FromFloat(15), "goodwill", now),
+			wantKind:    database.EntryAdjustment,
+			wantCurrent: money.FromFloat(15),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.NoError(t, database.ValidateEntry(tt.entry))
+			assert.Equal(t, tt.wantKind, tt.entry.Kind)
+
+			current, withdrawn := database.UserDeltas(tt.entry)
+			assert.Equal(t, tt.wantCurrent, current)
+			assert.Equal(t, tt.wantWithdrawn, withdrawn)
+		})
+	}
+}
+
+func TestLedger_ValidateEntryRejectsUnbalanced(t *testing.T) {
+	entry := models.JournalEntry{
+		Kind:   database.EntryAdjustment,
+		UserID: 1,
+		Postings: []models.Posting{
+			{Account: database.AccountUserPoints, UserID: 1, Amount: money.FromFloat(10)},
+			{Account: database.AccountAdjustments, Amount: money.FromFloat(-9.99)},
+		},
+	}
+	assert.True(t, errors.Is(database.ValidateEntry(entry), database.ErrUnbalancedEntry))
+
+	entry.Postings = entry.Postings[:1]
+	assert.True(t, errors.Is(database.ValidateEntry(entry), database.ErrUnbalancedEntry))
+}
+
+func TestPostgres_LedgerReversalsAndAdjustments(t *testing.T) {
+	storage := openTestPostgres(t)
+	ctx := context.Background()
+
+	suffix := time.Now().UnixNano()
+	userID, err := storage.CreateUser(ctx, models.User{
+		Login:        fmt.Sprintf("ledger-%d", suffix),
+		PasswordHash: "hash",
+	})
+	require.NoError(t, err)
+
+	orderNumber := fmt.Sprintf("%d", suffix)
+	require.NoError(t, storage.CreateOrder(ctx, &models.Order{
+		UserID:     userID,
+		Number:     orderNumber,
+		Status:     "NEW",
+		UploadedAt: time.Now(),
+	}))
+	require.NoError(t, storage.UpdateOrderFromAccrual(ctx, orderNumber, "PROCESSED", money.FromFloat(100)))
+	require.NoError(t, storage.Withdraw(ctx, &models.Withdrawal{
+		UserID:      userID,
+		OrderNumber: orderNumber + "-w",
+		Sum:         money.FromFloat(40),
+		ProcessedAt: time.Now(),
+	}))
+
+	journal, err := storage.GetJournal(ctx, userID)
+	require.NoError(t, err)
+	require.Len(t, journal, 2)
+	assert.Equal(t, database.EntryAccrual, journal[0].Kind)
+	assert.Equal(t, database.EntryWithdrawal, journal[1].Kind)
+
+	reversal, err := storage.ReverseEntry(ctx, journal[1].ID, "order cancelled")
+	require.NoError(t, err)
+	require.NotNil(t, reversal.ReversesEntryID)
+	assert.Equal(t, journal[1].ID, *reversal.ReversesEntryID)
+
+	_, err = storage.ReverseEntry(ctx, journal[1].ID, "twice")
+	assert.True(t, errors.Is(err, database.ErrAlreadyReversed))
+
+	withdrawals, err := storage.GetWithdrawals(ctx, userID)
+	require.NoError(t, err)
+	assert.Empty(t, withdrawals)
+
+	_, err = storage.RecordAdjustment(ctx, userID, money.FromFloat(-150), "too much")
+	assert.True(t, errors.Is(err, database.ErrInsufficientFunds))
+
+	_, err = storage.RecordAdjustment(ctx, userID, money.FromFloat(-25.5), "correction")
+	require.NoError(t, err)
+
+	balance, err := storage.GetBalance(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, money.FromFloat(74.5), balance.Current)
+	assert.Equal(t, money.Amount(0), balance.Withdrawn)
+
+	mismatches, err := storage.ReconcileBalances(ctx)
+	require.NoError(t, err)
+	for _, m := range mismatches {
+		assert.NotEqual(t, userID, m.UserID, "balance mismatch: %+v", m)
+	}
+}
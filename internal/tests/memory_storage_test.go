@@ -0,0 +1,88 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/alisaviation/internal/database/memory"
+	"github.com/alisaviation/internal/gophermart/services"
+	"github.com/alisaviation/internal/handlers"
+	"github.com/alisaviation/internal/middleware"
+	"github.com/alisaviation/pkg/money"
+)
+
+func TestMemoryStorage_EndToEnd(t *testing.T) {
+	storage := memory.NewMemoryStorage()
+	ctx := context.Background()
+	jwtService := services.NewJWTService([]byte("test_secret_key"), "gophermart")
+	orderService := services.NewOrderService(storage, storage, nil, nil)
+	authHandler := handlers.NewAuthHandler(services.NewAuthService(storage, storage, jwtService, time.Hour))
+	orderHandler := handlers.NewOrderHandler(orderService)
+	balanceHandler := handlers.NewBalanceHandler(services.NewBalanceService(storage, storage), orderService)
+
+	r := chi.NewRouter()
+	r.Post("/api/user/register", authHandler.Register)
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.AuthMiddleware(jwtService, storage))
+		r.Post("/api/user/orders", orderHandler.UploadOrder)
+		r.Get("/api/user/orders", orderHandler.GetOrders)
+		r.Get("/api/user/balance", balanceHandler.GetUserBalance)
+		r.Post("/api/user/balance/withdraw", balanceHandler.Withdraw)
+	})
+
+	do := func(method, path, token, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+	register := func(login string) string {
+		rec := do(http.MethodPost, "/api/user/register", "", "application/json",
+			`{"login":"`+login+`","password":"password"}`)
+		require.Equal(t, http.StatusOK, rec.Code)
+		return strings.TrimPrefix(rec.Header().Get("Authorization"), "Bearer ")
+	}
+
+	alice := register("alice")
+	bob := register("bob")
+	assert.Equal(t, http.StatusConflict,
+		do(http.MethodPost, "/api/user/register", "", "application/json", `{"login":"alice","password":"password"}`).Code)
+
+	assert.Equal(t, http.StatusAccepted, do(http.MethodPost, "/api/user/orders", alice, "text/plain", "79927398713").Code)
+	assert.Equal(t, http.StatusOK, do(http.MethodPost, "/api/user/orders", alice, "text/plain", "79927398713").Code)
+	assert.Equal(t, http.StatusConflict, do(http.MethodPost, "/api/user/orders", bob, "text/plain", "79927398713").Code)
+
+	require.NoError(t, storage.UpdateOrderFromAccrual(ctx, "79927398713", "PROCESSED", money.FromFloat(500)))
+
+	assert.Equal(t, http.StatusPaymentRequired, do(http.MethodPost, "/api/user/balance/withdraw", alice, "application/json",
+		`{"order":"2377225624","sum":751}`).Code)
+	assert.Equal(t, http.StatusPaymentRequired, do(http.MethodPost, "/api/user/balance/withdraw", bob, "application/json",
+		`{"order":"2377225624","sum":1}`).Code)
+	assert.Equal(t, http.StatusOK, do(http.MethodPost, "/api/user/balance/withdraw", alice, "application/json",
+		`{"order":"2377225624","sum":200.5}`).Code)
+
+	rec := do(http.MethodGet, "/api/user/balance", alice, "", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var balance struct {
+		Current   float64 `json:"current"`
+		Withdrawn float64 `json:"withdrawn"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &balance))
+	assert.Equal(t, 299.5, balance.Current)
+	assert.Equal(t, 200.5, balance.Withdrawn)
+}
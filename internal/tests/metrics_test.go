@@ -0,0 +1,139 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/alisaviation/internal/database"
+	"github.com/alisaviation/internal/database/memory"
+	"github.com/alisaviation/internal/gophermart/models"
+	"github.com/alisaviation/internal/gophermart/services"
+	"github.com/alisaviation/internal/metrics"
+	"github.com/alisaviation/internal/middleware"
+	"github.com/alisaviation/pkg/money"
+)
+
+func TestMetricsMiddleware_LabelsByRoutePattern(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(middleware.MetricsMiddleware)
+	r.Get("/api/user/orders/{number}", func(w http.ResponseWriter, r *http.Request) {
+		if chi.URLParam(r, "number") == "missing" {
+			http.Error(w, "Order not found", http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+
+	ok := metrics.HTTPRequests.WithLabelValues(http.MethodGet, "/api/user/orders/{number}", "200")
+	notFound := metrics.HTTPRequests.WithLabelValues(http.MethodGet, "/api/user/orders/{number}", "404")
+	unmatched := metrics.HTTPRequests.WithLabelValues(http.MethodGet, "unmatched", "404")
+	okBefore, notFoundBefore, unmatchedBefore := testutil.ToFloat64(ok), testutil.ToFloat64(notFound), testutil.ToFloat64(unmatched)
+
+	for _, path := range []string{"/api/user/orders/12345678903", "/api/user/orders/79927398713", "/api/user/orders/missing", "/nowhere"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(ok)-okBefore)
+	assert.Equal(t, 1.0, testutil.ToFloat64(notFound)-notFoundBefore)
+	assert.Equal(t, 1.0, testutil.ToFloat64(unmatched)-unmatchedBefore)
+
+	n, err := testutil.GatherAndCount(metrics.Registry, "gophermart_http_request_duration_seconds")
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, n, 3, "one histogram per method, route and status")
+}
+
+func TestAccrualClient_RecordsOutcomes(t *testing.T) {
+	statuses := map[string]int{
+		"processed": http.StatusOK,
+		"unknown":   http.StatusNoContent,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		number := strings.TrimPrefix(r.URL.Path, "/api/orders/")
+		w.WriteHeader(statuses[number])
+		if statuses[number] == http.StatusOK {
+			w.Write([]byte(`{"order":"processed","status":"PROCESSED","accrual":10}`))
+		}
+	}))
+
+	outcome := func(name string) float64 {
+		return testutil.ToFloat64(metrics.AccrualRequests.WithLabelValues(name))
+	}
+	before := map[string]float64{}
+	for _, name := range []string{"200", "204", metrics.AccrualError} {
+		before[name] = outcome(name)
+	}
+
+	client := services.NewAccrualClient(srv.URL, 0)
+	_, err := client.GetOrderAccrual(t.Context(), "processed")
+	require.NoError(t, err)
+	_, err = client.GetOrderAccrual(t.Context(), "unknown")
+	require.NoError(t, err)
+
+	srv.Close()
+	_, err = client.GetOrderAccrual(t.Context(), "gone")
+	require.Error(t, err)
+
+	assert.Equal(t, 1.0, outcome("200")-before["200"])
+	assert.Equal(t, 1.0, outcome("204")-before["204"])
+	assert.Equal(t, 3.0, outcome(metrics.AccrualError)-before[metrics.AccrualError], "one per attempt")
+}
+
+func TestStorageCollector(t *testing.T) {
+	storage := memory.NewMemoryStorage()
+	userID, err := storage.CreateUser(t.Context(), models.User{Login: "collector", PasswordHash: "hash"})
+	require.NoError(t, err)
+
+	for number, status := range map[string]string{"1": "NEW", "2": "NEW", "3": "INVALID"} {
+		require.NoError(t, storage.CreateOrder(t.Context(), &models.Order{UserID: userID, Number: number, Status: status}))
+	}
+	require.NoError(t, storage.CreateOrder(t.Context(), &models.Order{UserID: userID, Number: "4", Status: "NEW"}))
+	require.NoError(t, storage.UpdateOrderFromAccrual(t.Context(), "4", "PROCESSED", money.FromFloat(50)))
+	require.NoError(t, storage.Withdraw(t.Context(), &models.Withdrawal{UserID: userID, OrderNumber: "5", Sum: money.FromFloat(20.5)}))
+
+	expected := `
+# HELP gophermart_orders Orders by status.
+# TYPE gophermart_orders gauge
+gophermart_orders{status="INVALID"} 1
+gophermart_orders{status="NEW"} 2
+gophermart_orders{status="PROCESSED"} 1
+# HELP gophermart_withdrawals Withdrawals made by all users.
+# TYPE gophermart_withdrawals gauge
+gophermart_withdrawals 1
+# HELP gophermart_withdrawn_points Points withdrawn by all users.
+# TYPE gophermart_withdrawn_points gauge
+gophermart_withdrawn_points 20.5
+`
+	collector := metrics.NewStorageCollector(storage, time.Second)
+	assert.NoError(t, testutil.CollectAndCompare(collector, strings.NewReader(expected)))
+
+	collector = metrics.NewStorageCollector(ctxStats{storage}, 0)
+	assert.NoError(t, testutil.CollectAndCompare(collector, strings.NewReader(expected)), "zero timeout means no timeout")
+}
+
+// ctxStats fails like a database would once the context is done.
+type ctxStats struct {
+	database.Stats
+}
+
+func (s ctxStats) CountOrdersByStatus(ctx context.Context) (map[string]int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.Stats.CountOrdersByStatus(ctx)
+}
+
+func (s ctxStats) WithdrawalTotals(ctx context.Context) (int, money.Amount, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, 0, err
+	}
+	return s.Stats.WithdrawalTotals(ctx)
+}
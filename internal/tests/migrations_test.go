@@ -0,0 +1,105 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/alisaviation/internal/database/postgres"
+)
+
+func TestMigrations_AreEmbedded(t *testing.T) {
+	// Run from a directory without the sources, as a deployed binary would.
+	t.Chdir(t.TempDir())
+
+	latest, err := postgres.LatestMigration()
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, latest, uint(7))
+}
+
+func TestPostgres_MigrateStatus(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URI")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URI is not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	require.NoError(t, postgres.Migrate(t.Context(), db))
+	require.NoError(t, postgres.Migrate(t.Context(), db), "migrating an up to date schema is a no-op")
+
+	m, err := postgres.NewMigrator(t.Context(), db)
+	require.NoError(t, err)
+	defer m.Close()
+
+	status, err := m.Status()
+	require.NoError(t, err)
+	assert.True(t, status.Applied)
+	assert.False(t, status.Dirty)
+	assert.False(t, status.Pending())
+	assert.Equal(t, status.Latest, status.Version)
+
+	direct, err := postgres.SchemaStatus(t.Context(), db)
+	require.NoError(t, err)
+	assert.Equal(t, status, direct)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	_, err = postgres.SchemaStatus(ctx, db)
+	assert.Error(t, err, "the status read honours the caller's context")
+
+	require.NoError(t, db.PingContext(t.Context()), "the migrator leaves the pool open")
+}
+
+func TestPostgres_WithdrawalsSumCheckToleratesLegacyRows(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URI")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URI is not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	require.NoError(t, postgres.Migrate(t.Context(), db))
+
+	m, err := postgres.NewMigrator(t.Context(), db)
+	require.NoError(t, err)
+	defer m.Close()
+
+	const sumCheckVersion = 11
+	status, err := m.Status()
+	require.NoError(t, err)
+	require.NoError(t, m.Down(int(status.Latest)-sumCheckVersion+1))
+
+	var userID, withdrawalID int
+	require.NoError(t, db.QueryRowContext(t.Context(),
+		`INSERT INTO users (login, password_hash) VALUES ($1, 'x') RETURNING id`,
+		"legacy-withdrawal-"+t.Name()+time.Now().Format(time.RFC3339Nano)).Scan(&userID))
+	require.NoError(t, db.QueryRowContext(t.Context(),
+		`INSERT INTO withdrawals (user_id, order_number, sum) VALUES ($1, $2, -1) RETURNING id`,
+		userID, "legacy-"+time.Now().Format(time.RFC3339Nano)).Scan(&withdrawalID))
+
+	require.NoError(t, m.Up(), "a legacy non-positive withdrawal must not block the migration")
+
+	var validated bool
+	require.NoError(t, db.QueryRowContext(t.Context(),
+		`SELECT convalidated FROM pg_constraint WHERE conname = 'withdrawals_sum_positive'`).Scan(&validated))
+	assert.False(t, validated)
+
+	_, err = db.ExecContext(t.Context(),
+		`INSERT INTO withdrawals (user_id, order_number, sum) VALUES ($1, $2, 0)`,
+		userID, "new-"+time.Now().Format(time.RFC3339Nano))
+	assert.Error(t, err, "new rows are still checked")
+
+	_, err = db.ExecContext(t.Context(), `DELETE FROM withdrawals WHERE id = $1`, withdrawalID)
+	require.NoError(t, err)
+	_, err = db.ExecContext(t.Context(), `ALTER TABLE withdrawals VALIDATE CONSTRAINT withdrawals_sum_positive`)
+	require.NoError(t, err, "the documented cleanup step validates the constraint")
+}
@@ -1,6 +1,8 @@
 package mocks
 
 import (
+	"context"
+
 	"github.com/stretchr/testify/mock"
 
 	"github.com/alisaviation/internal/gophermart/models"
@@ -10,7 +12,7 @@ type MockBalance struct {
 	mock.Mock
 }
 
-func (m *MockBalance) GetBalance(userID int) (*models.Balance, error) {
+func (m *MockBalance) GetBalance(ctx context.Context, userID int) (*models.Balance, error) {
 	args := m.Called(userID)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -18,12 +20,12 @@ func (m *MockBalance) GetBalance(userID int) (*models.Balance, error) {
 	return args.Get(0).(*models.Balance), args.Error(1)
 }
 
-func (m *MockBalance) CreateWithdrawal(withdrawal *models.Withdrawal) error {
+func (m *MockBalance) Withdraw(ctx context.Context, withdrawal *models.Withdrawal) error {
 	args := m.Called(withdrawal)
 	return args.Error(0)
 }
 
-func (m *MockBalance) GetWithdrawals(userID int) ([]models.Withdrawal, error) {
+func (m *MockBalance) GetWithdrawals(ctx context.Context, userID int) ([]models.Withdrawal, error) {
 	args := m.Called(userID)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -31,7 +33,7 @@ func (m *MockBalance) GetWithdrawals(userID int) ([]models.Withdrawal, error) {
 	return args.Get(0).([]models.Withdrawal), args.Error(1)
 }
 
-func (m *MockBalance) WithdrawalExists(orderNumber string) (bool, error) {
+func (m *MockBalance) WithdrawalExists(ctx context.Context, orderNumber string) (bool, error) {
 	args := m.Called(orderNumber)
 	return args.Bool(0), args.Error(1)
 }
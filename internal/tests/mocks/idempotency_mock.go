@@ -0,0 +1,36 @@
+package mocks
+
+import (
+	"context"
+
+	"github.com/stretchr/testify/mock"
+
+	"github.com/alisaviation/internal/gophermart/models"
+)
+
+type MockIdempotencyStore struct {
+	mock.Mock
+}
+
+func (m *MockIdempotencyStore) ReserveIdempotencyKey(ctx context.Context, record *models.IdempotencyRecord) (*models.IdempotencyRecord, bool, error) {
+	args := m.Called(record)
+	if args.Get(0) == nil {
+		return nil, args.Bool(1), args.Error(2)
+	}
+	return args.Get(0).(*models.IdempotencyRecord), args.Bool(1), args.Error(2)
+}
+
+func (m *MockIdempotencyStore) CompleteIdempotencyKey(ctx context.Context, record *models.IdempotencyRecord) error {
+	args := m.Called(record)
+	return args.Error(0)
+}
+
+func (m *MockIdempotencyStore) ReleaseIdempotencyKey(ctx context.Context, userID int, key string) error {
+	args := m.Called(userID, key)
+	return args.Error(0)
+}
+
+func (m *MockIdempotencyStore) PurgeExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	args := m.Called()
+	return args.Get(0).(int64), args.Error(1)
+}
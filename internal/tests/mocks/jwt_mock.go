@@ -8,7 +8,7 @@ type MockJWTService struct {
 	mock.Mock
 }
 
-func (m *MockJWTService) GenerateToken(userID int, login string) (string, error) {
-	args := m.Called(userID, login)
+func (m *MockJWTService) GenerateAccessToken(userID int, login, sessionID string) (string, error) {
+	args := m.Called(userID, login, sessionID)
 	return args.String(0), args.Error(1)
 }
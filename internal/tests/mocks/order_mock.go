@@ -1,16 +1,20 @@
 package mocks
 
 import (
+	"context"
+
 	"github.com/stretchr/testify/mock"
 
+	"github.com/alisaviation/internal/database"
 	"github.com/alisaviation/internal/gophermart/models"
+	"github.com/alisaviation/pkg/money"
 )
 
 type MockOrderDB struct {
 	mock.Mock
 }
 
-func (m *MockOrderDB) GetOrdersByUser(userID int) ([]models.Order, error) {
+func (m *MockOrderDB) GetOrdersByUser(ctx context.Context, userID int) ([]models.Order, error) {
 	args := m.Called(userID)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -18,7 +22,15 @@ func (m *MockOrderDB) GetOrdersByUser(userID int) ([]models.Order, error) {
 	return args.Get(0).([]models.Order), args.Error(1)
 }
 
-func (m *MockOrderDB) GetOrderByNumber(number string) (*models.Order, error) {
+func (m *MockOrderDB) GetPendingOrders(ctx context.Context, limit int) ([]models.Order, error) {
+	args := m.Called(limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.Order), args.Error(1)
+}
+
+func (m *MockOrderDB) GetOrderByNumber(ctx context.Context, number string) (*models.Order, error) {
 	args := m.Called(number)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -26,12 +38,28 @@ func (m *MockOrderDB) GetOrderByNumber(number string) (*models.Order, error) {
 	return args.Get(0).(*models.Order), args.Error(1)
 }
 
-func (m *MockOrderDB) CreateOrder(order *models.Order) error {
+func (m *MockOrderDB) CreateOrder(ctx context.Context, order *models.Order) error {
 	args := m.Called(order)
 	return args.Error(0)
 }
 
-func (m *MockOrderDB) UpdateOrderFromAccrual(number string, status string, accrual float64) error {
+func (m *MockOrderDB) UpdateOrderFromAccrual(ctx context.Context, number string, status string, accrual money.Amount) error {
 	args := m.Called(number, status, accrual)
 	return args.Error(0)
 }
+
+func (m *MockOrderDB) QueryOrders(ctx context.Context, query database.OrderQuery) ([]models.Order, error) {
+	args := m.Called(query)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.Order), args.Error(1)
+}
+
+func (m *MockOrderDB) CreateOrders(ctx context.Context, orders []models.Order) ([]database.OrderInsertResult, error) {
+	args := m.Called(orders)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]database.OrderInsertResult), args.Error(1)
+}
@@ -0,0 +1,57 @@
+package mocks
+
+import (
+	"context"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+
+	"github.com/alisaviation/internal/gophermart/models"
+)
+
+type MockTokenRepository struct {
+	mock.Mock
+}
+
+func (m *MockTokenRepository) CreateSession(ctx context.Context, sessionID string, userID int) error {
+	args := m.Called(sessionID, userID)
+	return args.Error(0)
+}
+
+func (m *MockTokenRepository) RevokeSession(ctx context.Context, sessionID string) error {
+	args := m.Called(sessionID)
+	return args.Error(0)
+}
+
+func (m *MockTokenRepository) CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	args := m.Called(token)
+	return args.Error(0)
+}
+
+func (m *MockTokenRepository) GetRefreshToken(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	args := m.Called(tokenHash)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.RefreshToken), args.Error(1)
+}
+
+func (m *MockTokenRepository) RotateRefreshToken(ctx context.Context, oldHash string, next *models.RefreshToken) error {
+	args := m.Called(oldHash, next)
+	return args.Error(0)
+}
+
+func (m *MockTokenRepository) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	args := m.Called(jti, expiresAt)
+	return args.Error(0)
+}
+
+func (m *MockTokenRepository) IsTokenRevoked(ctx context.Context, jti, sessionID string) (bool, error) {
+	args := m.Called(jti, sessionID)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockTokenRepository) PurgeExpiredTokens(ctx context.Context) (int64, error) {
+	args := m.Called()
+	return args.Get(0).(int64), args.Error(1)
+}
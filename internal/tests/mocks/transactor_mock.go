@@ -0,0 +1,23 @@
+package mocks
+
+import (
+	"context"
+
+	"github.com/stretchr/testify/mock"
+
+	"github.com/alisaviation/internal/database"
+)
+
+// MockTransactor runs the unit of work inline after recording the options,
+// or fails without running it when an error is configured.
+type MockTransactor struct {
+	mock.Mock
+}
+
+func (m *MockTransactor) WithinTx(ctx context.Context, opts database.TxOptions, fn func(ctx context.Context) error) error {
+	args := m.Called(opts)
+	if err := args.Error(0); err != nil {
+		return err
+	}
+	return fn(ctx)
+}
@@ -1,6 +1,8 @@
 package mocks
 
 import (
+	"context"
+
 	"github.com/stretchr/testify/mock"
 
 	"github.com/alisaviation/internal/gophermart/models"
@@ -10,7 +12,7 @@ type MockUserRepository struct {
 	mock.Mock
 }
 
-func (m *MockUserRepository) GetUserByLogin(login string) (*models.User, error) {
+func (m *MockUserRepository) GetUserByLogin(ctx context.Context, login string) (*models.User, error) {
 	args := m.Called(login)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -18,7 +20,7 @@ func (m *MockUserRepository) GetUserByLogin(login string) (*models.User, error)
 	return args.Get(0).(*models.User), args.Error(1)
 }
 
-func (m *MockUserRepository) CreateUser(user models.User) (int, error) {
+func (m *MockUserRepository) CreateUser(ctx context.Context, user models.User) (int, error) {
 	args := m.Called(user)
 	return args.Int(0), args.Error(1)
 }
@@ -0,0 +1,116 @@
+package tests
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/alisaviation/internal/gophermart/dto"
+	"github.com/alisaviation/pkg/money"
+)
+
+func TestMoney_Parse(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    money.Amount
+		wantErr bool
+	}{
+		{name: "integer", value: "500", want: 50000},
+		{name: "one decimal", value: "500.5", want: 50050},
+		{name: "two decimals", value: "729.98", want: 72998},
+		{name: "database decimal", value: "0.10", want: 10},
+		{name: "rounds third decimal", value: "0.125", want: 13},
+		{name: "negative", value: "-1.05", want: -105},
+		{name: "no integer part", value: ".5", want: 50},
+		{name: "empty", value: "", wantErr: true},
+		{name: "garbage", value: "12a", wantErr: true},
+		{name: "dot only", value: ".", wantErr: true},
+		{name: "max", value: "92233720368547758.07", want: math.MaxInt64},
+		{name: "overflows int64", value: "92233720368547758.08", wantErr: true},
+		{name: "wraps to small value", value: "184467440737095517", wantErr: true},
+		{name: "negative overflow", value: "-184467440737095517", wantErr: true},
+		{name: "units overflow", value: "99999999999999999999", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := money.Parse(tt.value)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestMoney_ParseFloat(t *testing.T) {
+	got, err := money.ParseFloat(729.98)
+	require.NoError(t, err)
+	assert.Equal(t, money.Amount(72998), got)
+
+	for _, f := range []float64{1e17, -1e17, math.MaxFloat64, math.Inf(1), math.NaN()} {
+		_, err := money.ParseFloat(f)
+		assert.ErrorIs(t, err, money.ErrInvalidAmount, "%v", f)
+	}
+	assert.Panics(t, func() { money.FromFloat(1e17) })
+}
+
+func TestMoney_FloatArithmeticIsExact(t *testing.T) {
+	var total money.Amount
+	for i := 0; i < 10; i++ {
+		total += money.FromFloat(0.1)
+	}
+	assert.Equal(t, money.FromFloat(1), total)
+	assert.False(t, total < money.FromFloat(1))
+}
+
+func TestMoney_JSONIsWireCompatible(t *testing.T) {
+	data, err := json.Marshal(dto.BalanceResponse{
+		Current:   money.FromFloat(500.5),
+		Withdrawn: money.FromFloat(42),
+	})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"current":500.5,"withdrawn":42}`, string(data))
+
+	data, err = json.Marshal(dto.AccrualResponse{Order: "123", Status: "PROCESSING"})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"order":"123","status":"PROCESSING"}`, string(data))
+
+	var req dto.WithdrawRequest
+	require.NoError(t, json.Unmarshal([]byte(`{"order":"2377225624","sum":751.99}`), &req))
+	assert.Equal(t, money.Amount(75199), req.Sum)
+
+	require.NoError(t, json.Unmarshal([]byte(`{"order":"2377225624","sum":1e2}`), &req))
+	assert.Equal(t, money.FromFloat(100), req.Sum)
+
+	assert.Error(t, json.Unmarshal([]byte(`{"order":"2377225624","sum":"abc"}`), &req))
+	assert.ErrorIs(t, json.Unmarshal([]byte(`{"order":"2377225624","sum":184467440737095517}`), &req), money.ErrInvalidAmount)
+	assert.ErrorIs(t, json.Unmarshal([]byte(`{"order":"2377225624","sum":1e300}`), &req), money.ErrInvalidAmount)
+}
+
+func TestMoney_SQL(t *testing.T) {
+	var a money.Amount
+
+	require.NoError(t, a.Scan([]byte("123.45")))
+	assert.Equal(t, money.Amount(12345), a)
+
+	require.NoError(t, a.Scan(int64(7)))
+	assert.Equal(t, money.Amount(700), a)
+
+	require.NoError(t, a.Scan(nil))
+	assert.Equal(t, money.Amount(0), a)
+
+	assert.Error(t, a.Scan(true))
+	assert.ErrorIs(t, a.Scan(int64(math.MaxInt64)), money.ErrInvalidAmount)
+	assert.ErrorIs(t, a.Scan(1e300), money.ErrInvalidAmount)
+
+	v, err := money.Amount(-5).Value()
+	require.NoError(t, err)
+	assert.Equal(t, "-0.05", v)
+}
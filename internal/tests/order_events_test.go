@@ -0,0 +1,257 @@
+package tests
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/alisaviation/internal/gophermart/dto"
+	"github.com/alisaviation/internal/gophermart/events"
+	"github.com/alisaviation/internal/gophermart/models"
+	"github.com/alisaviation/internal/gophermart/services"
+	"github.com/alisaviation/internal/handlers"
+	"github.com/alisaviation/internal/middleware"
+	"github.com/alisaviation/internal/tests/mocks"
+	"github.com/alisaviation/pkg/money"
+)
+
+func eventNumbers(events []events.OrderEvent) []string {
+	var numbers []string
+	for _, event := range events {
+		numbers = append(numbers, event.Number)
+	}
+	return numbers
+}
+
+// publish returns the ID the bus assigned to event.
+func publish(t *testing.T, bus *events.Bus, event events.OrderEvent) events.EventID {
+	t.Helper()
+
+	sub, _, _ := bus.Subscribe(event.UserID, events.EventID{})
+	defer sub.Close()
+	bus.Publish(event)
+	published := <-sub.Events()
+	return published.ID
+}
+
+func TestBus_ResumeFromLastEventID(t *testing.T) {
+	bus := events.NewBus(3)
+	first := publish(t, bus, events.OrderEvent{UserID: 1, Number: "1"})
+	bus.Publish(events.OrderEvent{UserID: 2, Number: "2"})
+	bus.Publish(events.OrderEvent{UserID: 1, Number: "3"})
+
+	sub, missed, complete := bus.Subscribe(1, first)
+	defer sub.Close()
+	assert.True(t, complete)
+	assert.Equal(t, []string{"3"}, eventNumbers(missed), "only the user's events after the given ID")
+
+	bus.Publish(events.OrderEvent{UserID: 1, Number: "4"})
+	select {
+	case event := <-sub.Events():
+		assert.Equal(t, events.EventID{Epoch: first.Epoch, Seq: 4}, event.ID)
+		assert.Equal(t, "4", event.Number)
+		assert.False(t, event.At.IsZero())
+	case <-time.After(time.Second):
+		t.Fatal("live event not delivered")
+	}
+
+	// Events 1 and 2 have been evicted from the history of three, which
+	// does not matter to a client that has seen event 1.
+	_, missed, complete = bus.Subscribe(1, first)
+	assert.True(t, complete)
+	assert.Equal(t, []string{"3", "4"}, eventNumbers(missed))
+
+	bus.Publish(events.OrderEvent{UserID: 2, Number: "5"})
+	_, missed, complete = bus.Subscribe(1, first)
+	assert.False(t, complete, "event 2 is gone")
+	assert.Equal(t, []string{"3", "4"}, eventNumbers(missed))
+
+	_, _, complete = bus.Subscribe(1, events.EventID{Epoch: first.Epoch, Seq: 100})
+	assert.False(t, complete, "an ID from the future cannot be resumed")
+
+	// A restarted bus numbers its events from 1 again.
+	restarted := events.NewBus(3)
+	publish(t, restarted, events.OrderEvent{UserID: 1, Number: "1"})
+	publish(t, restarted, events.OrderEvent{UserID: 1, Number: "2"})
+	_, missed, complete = restarted.Subscribe(1, first)
+	assert.False(t, complete, "an ID from another bus cannot be resumed")
+	assert.Empty(t, missed)
+
+	_, missed, complete = bus.Subscribe(1, events.EventID{})
+	assert.True(t, complete)
+	assert.Empty(t, missed)
+}
+
+func TestBus_DropsLaggingSubscriber(t *testing.T) {
+	bus := events.NewBus(0)
+	sub, _, _ := bus.Subscribe(1, events.EventID{})
+
+	for i := 0; i < 1000; i++ {
+		bus.Publish(events.OrderEvent{UserID: 1, Number: "1"})
+	}
+
+	received := 0
+	for range sub.Events() {
+		received++
+	}
+	assert.Less(t, received, 1000, "a subscriber that stops reading is dropped")
+	sub.Close()
+}
+
+func TestBus_CloseEndsSubscriptions(t *testing.T) {
+	bus := events.NewBus(0)
+	sub, _, _ := bus.Subscribe(1, events.EventID{})
+
+	bus.Close()
+	_, ok := <-sub.Events()
+	assert.False(t, ok)
+
+	bus.Publish(events.OrderEvent{UserID: 1, Number: "1"})
+	late, _, _ := bus.Subscribe(1, events.EventID{})
+	_, ok = <-late.Events()
+	assert.False(t, ok, "subscriptions after close end immediately")
+}
+
+func TestOrderEventsHandler_Stream(t *testing.T) {
+	bus := events.NewBus(0)
+	handler := handlers.NewOrderEventsHandler(bus, 20*time.Millisecond)
+
+	srv := httptest.NewServer(middleware.GzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.Stream(w, r.WithContext(context.WithValue(r.Context(), middleware.UserIDKey, 1)))
+	})))
+	defer srv.Close()
+
+	first := publish(t, bus, events.OrderEvent{UserID: 1, Number: "missed", Status: "PROCESSING"})
+	bus.Publish(events.OrderEvent{UserID: 2, Number: "foreign", Status: "PROCESSING"})
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	require.NoError(t, err)
+	req.Header.Set("Last-Event-ID", "0")
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	// Streaming through gzip only works if every message is flushed.
+	gz, err := gzip.NewReader(resp.Body)
+	require.NoError(t, err)
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(gz)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	waitFor := func(want string) {
+		t.Helper()
+		timeout := time.After(2 * time.Second)
+		for {
+			select {
+			case line, ok := <-lines:
+				require.True(t, ok, "stream ended before %q", want)
+				assert.NotContains(t, line, "foreign")
+				if strings.HasPrefix(line, want) {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("no %q on the stream", want)
+			}
+		}
+	}
+
+	waitFor(": heartbeat")
+
+	bus.Publish(events.OrderEvent{UserID: 1, Number: "79927398713", Status: "PROCESSED", Accrual: money.FromFloat(500)})
+	waitFor("id: " + events.EventID{Epoch: first.Epoch, Seq: 3}.String())
+	waitFor("event: order")
+	waitFor(`data: {"number":"79927398713","status":"PROCESSED","accrual":500,`)
+
+	bus.Close()
+	select {
+	case _, ok := <-lines:
+		for ok {
+			_, ok = <-lines
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("stream did not end when the bus was closed")
+	}
+}
+
+func TestOrderEventsHandler_Resume(t *testing.T) {
+	bus := events.NewBus(0)
+	first := publish(t, bus, events.OrderEvent{UserID: 1, Number: "1", Status: "PROCESSING"})
+	second := publish(t, bus, events.OrderEvent{UserID: 1, Number: "2", Status: "INVALID"})
+
+	stream := func(lastEventID string) (int, string) {
+		// A canceled request returns right after the replay.
+		ctx, cancel := context.WithCancel(context.WithValue(context.Background(), middleware.UserIDKey, 1))
+		cancel()
+		req := httptest.NewRequest(http.MethodGet, "/api/user/orders/events", nil).WithContext(ctx)
+		req.Header.Set("Last-Event-ID", lastEventID)
+		rec := httptest.NewRecorder()
+		handlers.NewOrderEventsHandler(bus, time.Hour).Stream(rec, req)
+		body, _ := io.ReadAll(rec.Body)
+		return rec.Code, string(body)
+	}
+
+	code, body := stream(first.String())
+	assert.Equal(t, http.StatusOK, code)
+	assert.NotContains(t, body, `"number":"1"`)
+	assert.Contains(t, body, "id: "+second.String()+"\nevent: order\n")
+	assert.NotContains(t, body, "resync")
+
+	_, body = stream(events.EventID{Epoch: first.Epoch, Seq: 42}.String())
+	assert.Contains(t, body, "event: resync\n")
+
+	// IDs issued before a restart, with an older epoch or none at all.
+	for _, stale := range []string{events.EventID{Epoch: first.Epoch - 1, Seq: 1}.String(), "1"} {
+		code, body = stream(stale)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Contains(t, body, "event: resync\n", stale)
+		assert.NotContains(t, body, "event: order\n", stale)
+	}
+
+	code, _ = stream("yesterday")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestAccrualWorker_PublishesOrderEvents(t *testing.T) {
+	mockOrderDB := new(mocks.MockOrderDB)
+	mockAccrualClient := new(mocks.MockAccrualClient)
+	mockOrderDB.On("GetPendingOrders", 100).Return([]models.Order{
+		{UserID: 7, Number: "123", Status: "NEW"},
+	}, nil)
+	mockAccrualClient.On("GetOrderAccrual", mock.Anything, "123").
+		Return(&dto.AccrualResponse{Order: "123", Status: "PROCESSED", Accrual: money.FromFloat(50)}, nil)
+	mockOrderDB.On("UpdateOrderFromAccrual", "123", "PROCESSED", money.FromFloat(50)).Return(nil)
+
+	bus := events.NewBus(0)
+	sub, _, _ := bus.Subscribe(7, events.EventID{})
+	defer sub.Close()
+
+	worker := services.NewAccrualWorker(mockOrderDB, mockAccrualClient, 1, time.Second)
+	worker.Events = bus
+	require.NoError(t, worker.ProcessPending(context.Background()))
+
+	select {
+	case event := <-sub.Events():
+		assert.Equal(t, "123", event.Number)
+		assert.Equal(t, "PROCESSED", event.Status)
+		assert.Equal(t, money.FromFloat(50), event.Accrual)
+	default:
+		t.Fatal("no event published for the updated order")
+	}
+}
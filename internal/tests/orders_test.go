@@ -1,26 +1,39 @@
 package tests
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
 	"errors"
 	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 
+	"github.com/alisaviation/internal/database"
+	"github.com/alisaviation/internal/database/memory"
 	"github.com/alisaviation/internal/database/postgres"
 	"github.com/alisaviation/internal/gophermart/dto"
 	"github.com/alisaviation/internal/gophermart/models"
 	"github.com/alisaviation/internal/gophermart/services"
+	"github.com/alisaviation/internal/handlers"
+	"github.com/alisaviation/internal/middleware"
 	"github.com/alisaviation/internal/tests/mocks"
+	"github.com/alisaviation/pkg/money"
 )
 
 func TestOrderService_UploadOrder(t *testing.T) {
 	mockOrderDB := new(mocks.MockOrderDB)
 	mockAccrualClient := new(mocks.MockAccrualClient)
 
-	orderService := services.NewOrderService(mockOrderDB, mockAccrualClient)
+	orderService := services.NewOrderService(mockOrderDB, nil, mockAccrualClient, nil)
 
 	tests := []struct {
 		name           string
@@ -114,7 +127,7 @@ func TestOrderService_UploadOrder(t *testing.T) {
 
 			tt.mockSetup()
 
-			status, err := orderService.UploadOrder(tt.userID, tt.orderNumber)
+			status, err := orderService.UploadOrder(context.Background(), tt.userID, tt.orderNumber)
 
 			assert.Equal(t, tt.expectedStatus, status)
 			if tt.expectedError != nil {
@@ -133,7 +146,7 @@ func TestOrderService_GetOrders(t *testing.T) {
 	mockOrderDB := new(mocks.MockOrderDB)
 	mockAccrualClient := new(mocks.MockAccrualClient)
 
-	orderService := services.NewOrderService(mockOrderDB, mockAccrualClient)
+	orderService := services.NewOrderService(mockOrderDB, nil, mockAccrualClient, nil)
 
 	now := time.Now()
 
@@ -154,38 +167,31 @@ func TestOrderService_GetOrders(t *testing.T) {
 							UserID:     1,
 							Number:     "123",
 							Status:     "NEW",
-							Accrual:    0,
+							Accrual:    money.FromFloat(0),
 							UploadedAt: now,
 						},
 						{
 							UserID:     1,
 							Number:     "456",
 							Status:     "PROCESSED",
-							Accrual:    100,
+							Accrual:    money.FromFloat(100),
 							UploadedAt: now.Add(-time.Hour),
 						},
 					}, nil)
-
-				mockAccrualClient.On("GetOrderAccrual", mock.Anything, "123").
-					Return(&dto.AccrualResponse{
-						Order:   "123",
-						Status:  "NEW",
-						Accrual: 0,
-					}, nil)
 			},
 			expected: []models.Order{
 				{
 					UserID:     1,
 					Number:     "123",
 					Status:     "NEW",
-					Accrual:    0,
+					Accrual:    money.FromFloat(0),
 					UploadedAt: now,
 				},
 				{
 					UserID:     1,
 					Number:     "456",
 					Status:     "PROCESSED",
-					Accrual:    100,
+					Accrual:    money.FromFloat(100),
 					UploadedAt: now.Add(-time.Hour),
 				},
 			},
@@ -201,42 +207,6 @@ func TestOrderService_GetOrders(t *testing.T) {
 			expected:      nil,
 			expectedError: errors.New("failed to get user orders: database error"),
 		},
-		{
-			name:   "update order status from accrual",
-			userID: 1,
-			mockSetup: func() {
-				mockOrderDB.On("GetOrdersByUser", 1).
-					Return([]models.Order{
-						{
-							UserID:     1,
-							Number:     "123",
-							Status:     "NEW",
-							Accrual:    0,
-							UploadedAt: now,
-						},
-					}, nil)
-
-				mockAccrualClient.On("GetOrderAccrual", mock.Anything, "123").
-					Return(&dto.AccrualResponse{
-						Order:   "123",
-						Status:  "PROCESSED",
-						Accrual: 50,
-					}, nil)
-
-				mockOrderDB.On("UpdateOrderFromAccrual", "123", "PROCESSED", 50.0).
-					Return(nil)
-			},
-			expected: []models.Order{
-				{
-					UserID:     1,
-					Number:     "123",
-					Status:     "PROCESSED",
-					Accrual:    50,
-					UploadedAt: now,
-				},
-			},
-			expectedError: nil,
-		},
 	}
 
 	for _, tt := range tests {
@@ -246,7 +216,7 @@ func TestOrderService_GetOrders(t *testing.T) {
 
 			tt.mockSetup()
 
-			orders, err := orderService.GetOrders(tt.userID)
+			orders, err := orderService.GetOrders(context.Background(), tt.userID)
 
 			if tt.expectedError != nil {
 				assert.EqualError(t, err, tt.expectedError.Error())
@@ -311,3 +281,351 @@ func TestOrderService_ValidateOrderNumber(t *testing.T) {
 		})
 	}
 }
+
+func TestOrderService_UploadOrderRunsInSerializableTx(t *testing.T) {
+	mockOrderDB := new(mocks.MockOrderDB)
+	mockTx := new(mocks.MockTransactor)
+	orderService := services.NewOrderService(mockOrderDB, mockTx, new(mocks.MockAccrualClient), nil)
+
+	mockTx.On("WithinTx", database.TxOptions{Isolation: sql.LevelSerializable}).Return(nil).Once()
+	mockOrderDB.On("GetOrderByNumber", "4561261212345467").Return(nil, postgres.ErrNotFound)
+	mockOrderDB.On("CreateOrder", mock.AnythingOfType("*models.Order")).Return(nil)
+
+	status, err := orderService.UploadOrder(context.Background(), 1, "4561261212345467")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusAccepted, status)
+
+	mockTx.AssertExpectations(t)
+	mockOrderDB.AssertExpectations(t)
+}
+
+func TestOrderService_UploadOrderTxFailure(t *testing.T) {
+	mockOrderDB := new(mocks.MockOrderDB)
+	mockTx := new(mocks.MockTransactor)
+	orderService := services.NewOrderService(mockOrderDB, mockTx, new(mocks.MockAccrualClient), nil)
+
+	mockTx.On("WithinTx", mock.Anything).Return(errors.New("could not serialize access"))
+
+	status, err := orderService.UploadOrder(context.Background(), 1, "4561261212345467")
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusInternalServerError, status)
+	mockOrderDB.AssertNotCalled(t, "CreateOrder", mock.Anything)
+}
+
+func TestOrderService_ListOrdersPages(t *testing.T) {
+	mockOrderDB := new(mocks.MockOrderDB)
+	orderService := services.NewOrderService(mockOrderDB, nil, new(mocks.MockAccrualClient), nil)
+
+	now := time.Now()
+	orders := []models.Order{
+		{ID: 3, UserID: 1, Number: "3", UploadedAt: now},
+		{ID: 2, UserID: 1, Number: "2", UploadedAt: now.Add(-time.Minute)},
+		{ID: 1, UserID: 1, Number: "1", UploadedAt: now.Add(-2 * time.Minute)},
+	}
+	mockOrderDB.On("QueryOrders", database.OrderQuery{UserID: 1, Limit: 3}).Return(orders, nil)
+
+	page, err := orderService.ListOrders(context.Background(), 1, services.OrderListQuery{Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, orders[:2], page.Orders)
+	first := page
+	assert.NotEmpty(t, page.NextCursor)
+
+	mockOrderDB.On("QueryOrders", mock.MatchedBy(func(q database.OrderQuery) bool {
+		return q.After != nil && q.After.ID == 2 && q.After.UploadedAt.Equal(orders[1].UploadedAt)
+	})).Return(orders[2:], nil)
+
+	page, err = orderService.ListOrders(context.Background(), 1, services.OrderListQuery{Limit: 2, Cursor: page.NextCursor})
+	assert.NoError(t, err)
+	assert.Equal(t, orders[2:], page.Orders)
+	assert.Empty(t, page.NextCursor, "last page")
+
+	_, err = orderService.ListOrders(context.Background(), 1, services.OrderListQuery{Cursor: "not a cursor"})
+	assert.ErrorIs(t, err, services.ErrInvalidCursor)
+
+	_, err = orderService.ListOrders(context.Background(), 1, services.OrderListQuery{Limit: 2, Ascending: true, Cursor: first.NextCursor})
+	assert.ErrorIs(t, err, services.ErrInvalidCursor, "a cursor is bound to its sort direction")
+}
+
+func TestOrderHandler_GetOrdersQuery(t *testing.T) {
+	storage := memory.NewMemoryStorage()
+	handler := handlers.NewOrderHandler(services.NewOrderService(storage, storage, nil, nil))
+
+	ctx := context.Background()
+	userID, err := storage.CreateUser(ctx, models.User{Login: "history", PasswordHash: "hash"})
+	require.NoError(t, err)
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, status := range []string{"NEW", "PROCESSED", "INVALID", "NEW", "PROCESSING"} {
+		require.NoError(t, storage.CreateOrder(ctx, &models.Order{
+			UserID: userID, Number: strconv.Itoa(i), Status: status, UploadedAt: base.Add(time.Duration(i) * time.Hour),
+		}))
+	}
+
+	get := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/user/orders?"+query, nil)
+		req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, userID))
+		rec := httptest.NewRecorder()
+		handler.GetOrders(rec, req)
+		return rec
+	}
+	numbers := func(rec *httptest.ResponseRecorder) []string {
+		var orders []dto.OrderResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &orders))
+		var out []string
+		for _, order := range orders {
+			out = append(out, order.Number)
+		}
+		return out
+	}
+
+	rec := get("")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []string{"4", "3", "2", "1", "0"}, numbers(rec), "unparameterized request returns the whole history")
+	assert.Empty(t, rec.Header().Get("X-Next-Cursor"))
+
+	var paged []string
+	for cursor := ""; ; {
+		rec := get("limit=2&sort=asc&cursor=" + cursor)
+		require.Equal(t, http.StatusOK, rec.Code)
+		paged = append(paged, numbers(rec)...)
+		if cursor = rec.Header().Get("X-Next-Cursor"); cursor == "" {
+			break
+		}
+	}
+	assert.Equal(t, []string{"0", "1", "2", "3", "4"}, paged)
+
+	rec = get("status=new,processing&from=2024-01-01T01:00:00Z&to=2024-01-01T04:00:00Z")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []string{"3"}, numbers(rec))
+
+	assert.Equal(t, http.StatusNoContent, get("from=2030-01-01T00:00:00Z").Code)
+
+	cursor := get("limit=2&status=NEW,PROCESSED").Header().Get("X-Next-Cursor")
+	require.NotEmpty(t, cursor)
+	assert.Equal(t, http.StatusOK, get("limit=2&status=processed,new&cursor="+cursor).Code, "status order does not matter")
+	for _, query := range []string{"limit=2&status=NEW,PROCESSED&sort=asc", "limit=2&status=NEW", "limit=2",
+		"limit=2&status=NEW,PROCESSED&from=2024-01-01T00:00:00Z", "limit=2&status=NEW,PROCESSED&to=2024-01-02T00:00:00Z"} {
+		assert.Equal(t, http.StatusBadRequest, get(query+"&cursor="+cursor).Code, "cursor reused with %s", query)
+	}
+
+	for _, query := range []string{"limit=0", "limit=101", "limit=x", "status=DONE", "from=yesterday", "sort=up", "cursor=%21",
+		"from=2024-01-02T00:00:00Z&to=2024-01-01T00:00:00Z"} {
+		assert.Equal(t, http.StatusBadRequest, get(query).Code, query)
+	}
+}
+
+func TestOrderService_GetOrder(t *testing.T) {
+	now := time.Now()
+	stored := &models.Order{UserID: 1, Number: "79927398713", Status: "PROCESSING", UploadedAt: now}
+
+	tests := []struct {
+		name          string
+		userID        int
+		refresh       bool
+		mockSetup     func(*mocks.MockOrderDB, *mocks.MockAccrualClient)
+		expected      *models.Order
+		expectedError error
+	}{
+		{
+			name:   "unknown order",
+			userID: 1,
+			mockSetup: func(mdb *mocks.MockOrderDB, mac *mocks.MockAccrualClient) {
+				mdb.On("GetOrderByNumber", "79927398713").Return(nil, database.ErrNotFound)
+			},
+			expectedError: services.ErrOrderNotFound,
+		},
+		{
+			name:   "order of another user",
+			userID: 2,
+			mockSetup: func(mdb *mocks.MockOrderDB, mac *mocks.MockAccrualClient) {
+				mdb.On("GetOrderByNumber", "79927398713").Return(stored, nil)
+			},
+			expectedError: services.ErrOrderNotFound,
+		},
+		{
+			name:   "stored order without refresh",
+			userID: 1,
+			mockSetup: func(mdb *mocks.MockOrderDB, mac *mocks.MockAccrualClient) {
+				mdb.On("GetOrderByNumber", "79927398713").Return(stored, nil)
+			},
+			expected: stored,
+		},
+		{
+			name:    "refresh applies the accrual result",
+			userID:  1,
+			refresh: true,
+			mockSetup: func(mdb *mocks.MockOrderDB, mac *mocks.MockAccrualClient) {
+				processed := &models.Order{UserID: 1, Number: "79927398713", Status: "PROCESSED", Accrual: money.FromFloat(42), UploadedAt: now}
+				mdb.On("GetOrderByNumber", "79927398713").Return(stored, nil).Once()
+				mac.On("GetOrderAccrual", mock.Anything, "79927398713").
+					Return(&dto.AccrualResponse{Order: "79927398713", Status: "PROCESSED", Accrual: money.FromFloat(42)}, nil)
+				mdb.On("UpdateOrderFromAccrual", "79927398713", "PROCESSED", money.FromFloat(42)).Return(nil)
+				mdb.On("GetOrderByNumber", "79927398713").Return(processed, nil).Once()
+			},
+			expected: &models.Order{UserID: 1, Number: "79927398713", Status: "PROCESSED", Accrual: money.FromFloat(42), UploadedAt: now},
+		},
+		{
+			name:    "refresh failure returns the stored order",
+			userID:  1,
+			refresh: true,
+			mockSetup: func(mdb *mocks.MockOrderDB, mac *mocks.MockAccrualClient) {
+				mdb.On("GetOrderByNumber", "79927398713").Return(stored, nil)
+				mac.On("GetOrderAccrual", mock.Anything, "79927398713").Return(nil, errors.New("accrual system unavailable"))
+			},
+			expected: stored,
+		},
+		{
+			name:    "final orders are not refreshed",
+			userID:  1,
+			refresh: true,
+			mockSetup: func(mdb *mocks.MockOrderDB, mac *mocks.MockAccrualClient) {
+				mdb.On("GetOrderByNumber", "79927398713").
+					Return(&models.Order{UserID: 1, Number: "79927398713", Status: "INVALID", UploadedAt: now}, nil)
+			},
+			expected: &models.Order{UserID: 1, Number: "79927398713", Status: "INVALID", UploadedAt: now},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockOrderDB := new(mocks.MockOrderDB)
+			mockAccrualClient := new(mocks.MockAccrualClient)
+			tt.mockSetup(mockOrderDB, mockAccrualClient)
+
+			orderService := services.NewOrderService(mockOrderDB, nil, mockAccrualClient, nil)
+			order, err := orderService.GetOrder(context.Background(), tt.userID, "79927398713", tt.refresh)
+
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+				assert.Nil(t, order)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expected, order)
+			}
+
+			mockOrderDB.AssertExpectations(t)
+			mockAccrualClient.AssertExpectations(t)
+		})
+	}
+}
+
+func TestOrderHandler_GetOrder(t *testing.T) {
+	storage := memory.NewMemoryStorage()
+	handler := handlers.NewOrderHandler(services.NewOrderService(storage, storage, nil, nil))
+	r := chi.NewRouter()
+	r.Get("/api/user/orders/{number}", handler.GetOrder)
+
+	ctx := context.Background()
+	owner, err := storage.CreateUser(ctx, models.User{Login: "owner", PasswordHash: "hash"})
+	require.NoError(t, err)
+	other, err := storage.CreateUser(ctx, models.User{Login: "other", PasswordHash: "hash"})
+	require.NoError(t, err)
+	require.NoError(t, storage.CreateOrder(ctx, &models.Order{
+		UserID: owner, Number: "79927398713", Status: "NEW", UploadedAt: time.Now(),
+	}))
+	require.NoError(t, storage.UpdateOrderFromAccrual(ctx, "79927398713", "PROCESSED", money.FromFloat(12.5)))
+
+	get := func(userID int, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, userID))
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get(owner, "/api/user/orders/79927398713?refresh=true")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var order dto.OrderResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &order))
+	assert.Equal(t, "79927398713", order.Number)
+	assert.Equal(t, "PROCESSED", order.Status)
+	assert.Equal(t, money.FromFloat(12.5), order.Accrual)
+
+	assert.Equal(t, http.StatusNotFound, get(other, "/api/user/orders/79927398713").Code, "ownership is not leaked")
+	assert.Equal(t, http.StatusNotFound, get(owner, "/api/user/orders/2377225624").Code)
+	assert.Equal(t, http.StatusBadRequest, get(owner, "/api/user/orders/79927398713?refresh=maybe").Code)
+}
+
+func TestOrderService_UploadOrders(t *testing.T) {
+	mockOrderDB := new(mocks.MockOrderDB)
+	orderService := services.NewOrderService(mockOrderDB, nil, new(mocks.MockAccrualClient), nil)
+
+	mockOrderDB.On("CreateOrders", mock.MatchedBy(func(orders []models.Order) bool {
+		return assert.ObjectsAreEqual([]string{"79927398713", "4561261212345467", "2377225624"}, orderNumbersOf(orders))
+	})).Return([]database.OrderInsertResult{
+		{Number: "79927398713", Inserted: true},
+		{Number: "4561261212345467", OwnerID: 2},
+		{Number: "2377225624"},
+	}, nil)
+	// The owner of 2377225624 was unknown to the batch insert.
+	mockOrderDB.On("GetOrderByNumber", "2377225624").Return(&models.Order{UserID: 1, Number: "2377225624"}, nil)
+
+	results, err := orderService.UploadOrders(context.Background(), 1,
+		[]string{"79927398713", "12ab", "4561261212345467", "1234567890", "79927398713", "2377225624"})
+	require.NoError(t, err)
+	assert.Equal(t, []dto.BatchOrderResult{
+		{Number: "79927398713", Result: dto.BatchOrderAccepted},
+		{Number: "12ab", Result: dto.BatchOrderInvalid, Error: "order number must contain only digits"},
+		{Number: "4561261212345467", Result: dto.BatchOrderConflict},
+		{Number: "1234567890", Result: dto.BatchOrderInvalid, Error: "invalid order number by Luhn algorithm"},
+		{Number: "79927398713", Result: dto.BatchOrderAlreadyUploaded},
+		{Number: "2377225624", Result: dto.BatchOrderAlreadyUploaded},
+	}, results)
+	mockOrderDB.AssertExpectations(t)
+
+	mockOrderDB = new(mocks.MockOrderDB)
+	orderService = services.NewOrderService(mockOrderDB, nil, new(mocks.MockAccrualClient), nil)
+	mockOrderDB.On("CreateOrders", mock.Anything).Return(nil, errors.New("database error"))
+	_, err = orderService.UploadOrders(context.Background(), 1, []string{"79927398713"})
+	assert.Error(t, err)
+}
+
+func orderNumbersOf(orders []models.Order) []string {
+	numbers := make([]string, 0, len(orders))
+	for _, order := range orders {
+		numbers = append(numbers, order.Number)
+	}
+	return numbers
+}
+
+func TestOrderHandler_UploadOrders(t *testing.T) {
+	storage := memory.NewMemoryStorage()
+	handler := handlers.NewOrderHandler(services.NewOrderService(storage, storage, nil, nil))
+
+	ctx := context.Background()
+	userID, err := storage.CreateUser(ctx, models.User{Login: "kiosk", PasswordHash: "hash"})
+	require.NoError(t, err)
+
+	post := func(contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/user/orders/batch", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, userID))
+		rec := httptest.NewRecorder()
+		handler.UploadOrders(rec, req)
+		return rec
+	}
+	results := func(rec *httptest.ResponseRecorder) []string {
+		var items []dto.BatchOrderResult
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &items))
+		var out []string
+		for _, item := range items {
+			out = append(out, item.Number+":"+item.Result)
+		}
+		return out
+	}
+
+	rec := post("application/json; charset=utf-8", `["79927398713", "1234567890"]`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []string{"79927398713:accepted", "1234567890:invalid"}, results(rec))
+
+	rec = post("text/plain", "79927398713\r\n\n4561261212345467\n")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []string{"79927398713:already_uploaded", "4561261212345467:accepted"}, results(rec))
+
+	assert.Equal(t, http.StatusBadRequest, post("application/json", `{"order":"79927398713"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, post("application/json", `[]`).Code)
+	assert.Equal(t, http.StatusBadRequest, post("text/plain", "\n\n").Code)
+	assert.Equal(t, http.StatusUnsupportedMediaType, post("application/xml", "<orders/>").Code)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, post("text/plain", strings.Repeat("79927398713\n", 501)).Code)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, post("text/plain", strings.Repeat(" ", 1<<20)).Code,
+		"the body is not read past the limit")
+}
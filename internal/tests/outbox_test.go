@@ -0,0 +1,221 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/alisaviation/internal/database"
+	"github.com/alisaviation/internal/database/memory"
+	"github.com/alisaviation/internal/gophermart/models"
+	"github.com/alisaviation/internal/gophermart/outbox"
+)
+
+// recordingSink remembers delivered messages and fails every delivery for
+// the users in failFor.
+type recordingSink struct {
+	mu        sync.Mutex
+	delivered []outbox.Message
+	failFor   map[int]bool
+}
+
+func (s *recordingSink) Deliver(ctx context.Context, msg outbox.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.failFor[msg.UserID] {
+		return errors.New("sink unavailable")
+	}
+	s.delivered = append(s.delivered, msg)
+	return nil
+}
+
+func (s *recordingSink) Close() error { return nil }
+
+func (s *recordingSink) types(userID int) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var types []string
+	for _, msg := range s.delivered {
+		if msg.UserID == userID {
+			types = append(types, msg.Type)
+		}
+	}
+	return types
+}
+
+func newOutboxUser(t *testing.T, storage *memory.MemoryStorage, login string) int {
+	t.Helper()
+
+	userID, err := storage.CreateUser(t.Context(), models.User{Login: login, PasswordHash: "hash"})
+	require.NoError(t, err)
+	require.NoError(t, storage.CreateOrder(t.Context(), &models.Order{
+		UserID: userID, Number: login + "-order", Status: "NEW", UploadedAt: time.Now(),
+	}))
+	return userID
+}
+
+func TestRelay_DeliversInOrderAndAcknowledges(t *testing.T) {
+	storage := memory.NewMemoryStorage()
+	alice := newOutboxUser(t, storage, "alice")
+	bob := newOutboxUser(t, storage, "bob")
+
+	sink := &recordingSink{}
+	relay := outbox.NewRelay(storage, sink, time.Second)
+	require.NoError(t, relay.ProcessPending(t.Context()))
+
+	want := []string{database.EventUserRegistered, database.EventOrderUploaded}
+	assert.Equal(t, want, sink.types(alice))
+	assert.Equal(t, want, sink.types(bob))
+
+	pending, err := storage.PendingOutboxEvents(t.Context(), 100)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+}
+
+func TestRelay_FailedUserWaitsForRetry(t *testing.T) {
+	storage := memory.NewMemoryStorage()
+	alice := newOutboxUser(t, storage, "alice")
+	bob := newOutboxUser(t, storage, "bob")
+
+	sink := &recordingSink{failFor: map[int]bool{alice: true}}
+	relay := outbox.NewRelay(storage, sink, time.Second)
+	relay.MinBackoff = 0
+	relay.MaxBackoff = 0
+
+	require.NoError(t, relay.ProcessPending(t.Context()))
+	assert.Empty(t, sink.types(alice))
+	assert.Len(t, sink.types(bob), 2, "another user's failure does not block bob")
+
+	// The failed event stays first in line, ahead of alice's later events.
+	pending, err := storage.PendingOutboxEvents(t.Context(), 100)
+	require.NoError(t, err)
+	require.Len(t, pending, 2)
+	assert.Equal(t, database.EventUserRegistered, pending[0].Type)
+	assert.Equal(t, 1, pending[0].Attempts)
+	assert.Equal(t, "sink unavailable", pending[0].LastError)
+	failedID := pending[0].ID
+
+	relay.MinBackoff = time.Hour
+	relay.MaxBackoff = time.Hour
+	require.NoError(t, relay.ProcessPending(t.Context()))
+
+	pending, err = storage.PendingOutboxEvents(t.Context(), 100)
+	require.NoError(t, err)
+	assert.Empty(t, pending, "alice's events wait for the backoff to expire")
+
+	// Once the retry is due and the sink recovers, alice's events arrive in order.
+	require.NoError(t, storage.MarkOutboxEventFailed(t.Context(), failedID, time.Now().Add(-time.Second), "due"))
+	sink.mu.Lock()
+	sink.failFor = nil
+	sink.mu.Unlock()
+	require.NoError(t, relay.ProcessPending(t.Context()))
+	assert.Equal(t, []string{database.EventUserRegistered, database.EventOrderUploaded}, sink.types(alice))
+}
+
+func TestBackoff(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{
+		0:   time.Second,
+		1:   2 * time.Second,
+		3:   8 * time.Second,
+		4:   10 * time.Second,
+		100: 10 * time.Second,
+	} {
+		assert.Equal(t, want, outbox.Backoff(time.Second, 10*time.Second, attempts), "attempts %d", attempts)
+	}
+	assert.Zero(t, outbox.Backoff(0, 0, 5))
+}
+
+func TestWebhookSink_Deliver(t *testing.T) {
+	var (
+		status  = http.StatusNoContent
+		headers http.Header
+		body    []byte
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header.Clone()
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	sink := outbox.NewWebhookSink(srv.URL)
+	msg := outbox.Message{ID: 7, Type: database.EventOrderUploaded, UserID: 3, Payload: json.RawMessage(`{"number":"1"}`)}
+
+	require.NoError(t, sink.Deliver(t.Context(), msg))
+	assert.Equal(t, "7", headers.Get("X-Event-ID"))
+	assert.Equal(t, database.EventOrderUploaded, headers.Get("X-Event-Type"))
+	assert.Equal(t, "application/json", headers.Get("Content-Type"))
+
+	var got outbox.Message
+	require.NoError(t, json.Unmarshal(body, &got))
+	assert.Equal(t, msg.ID, got.ID)
+	assert.JSONEq(t, `{"number":"1"}`, string(got.Payload))
+
+	status = http.StatusInternalServerError
+	assert.Error(t, sink.Deliver(t.Context(), msg))
+}
+
+func TestFileSink_WritesNDJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+
+	sink, err := outbox.ParseSinks("file://" + path)
+	require.NoError(t, err)
+	for id := int64(1); id <= 2; id++ {
+		require.NoError(t, sink.Deliver(t.Context(), outbox.Message{ID: id, Type: database.EventUserRegistered, Payload: json.RawMessage(`{}`)}))
+	}
+	require.NoError(t, sink.Close())
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var ids []int64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var msg outbox.Message
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &msg))
+		ids = append(ids, msg.ID)
+	}
+	require.NoError(t, scanner.Err())
+	assert.Equal(t, []int64{1, 2}, ids)
+}
+
+func TestWriterSink_WritesOneLinePerMessage(t *testing.T) {
+	var buf strings.Builder
+	sink := outbox.NewWriterSink(&buf)
+
+	require.NoError(t, sink.Deliver(t.Context(), outbox.Message{ID: 1, Type: database.EventWithdrawalCreated, Payload: json.RawMessage(`{"sum":5}`)}))
+	assert.Equal(t, 1, strings.Count(buf.String(), "\n"))
+	assert.Contains(t, buf.String(), `"type":"withdrawal.created"`)
+}
+
+func TestParseSinks(t *testing.T) {
+	sink, err := outbox.ParseSinks("stdout, http://localhost:9000/hook")
+	require.NoError(t, err)
+	assert.IsType(t, outbox.MultiSink{}, sink)
+
+	sink, err = outbox.ParseSinks("https://example.com/hook")
+	require.NoError(t, err)
+	assert.IsType(t, &outbox.WebhookSink{}, sink)
+
+	_, err = outbox.ParseSinks("kafka://broker")
+	assert.Error(t, err)
+
+	_, err = outbox.ParseSinks(" , ")
+	assert.Error(t, err)
+}
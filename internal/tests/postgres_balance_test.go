@@ -0,0 +1,210 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/alisaviation/internal/database"
+	"github.com/alisaviation/internal/database/postgres"
+	"github.com/alisaviation/internal/gophermart/models"
+	"github.com/alisaviation/pkg/money"
+)
+
+func openTestPostgres(t *testing.T) *postgres.PostgresStorage {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DATABASE_URI")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URI is not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	require.NoError(t, postgres.Migrate(t.Context(), db))
+
+	storage, err := postgres.NewPostgresDatabase(db, 5*time.Second)
+	require.NoError(t, err)
+	return storage
+}
+
+func TestPostgres_ConcurrentWithdrawalsNeverOverdraw(t *testing.T) {
+	storage := openTestPostgres(t)
+	ctx := context.Background()
+
+	suffix := time.Now().UnixNano()
+	userID, err := storage.CreateUser(ctx, models.User{
+		Login:        fmt.Sprintf("withdraw-race-%d", suffix),
+		PasswordHash: "hash",
+	})
+	require.NoError(t, err)
+
+	orderNumber := fmt.Sprintf("%d", suffix)
+	require.NoError(t, storage.CreateOrder(ctx, &models.Order{
+		UserID:     userID,
+		Number:     orderNumber,
+		Status:     "NEW",
+		UploadedAt: time.Now(),
+	}))
+	require.NoError(t, storage.UpdateOrderFromAccrual(ctx, orderNumber, "PROCESSED", money.FromFloat(100)))
+
+	const attempts = 20
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		succeeded int
+	)
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := storage.Withdraw(ctx, &models.Withdrawal{
+				UserID:      userID,
+				OrderNumber: fmt.Sprintf("%d-%d", suffix, i),
+				Sum:         money.FromFloat(30),
+				ProcessedAt: time.Now(),
+			})
+			if err == nil {
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+				return
+			}
+			assert.True(t, errors.Is(err, database.ErrInsufficientFunds), "unexpected error: %v", err)
+		}(i)
+	}
+	wg.Wait()
+
+	balance, err := storage.GetBalance(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, 3, succeeded)
+	assert.GreaterOrEqual(t, balance.Current, money.Amount(0))
+	assert.Equal(t, money.FromFloat(10), balance.Current)
+	assert.Equal(t, money.FromFloat(90), balance.Withdrawn)
+}
+
+func TestPostgres_BalanceTableMatchesRecomputedSums(t *testing.T) {
+	storage := openTestPostgres(t)
+	ctx := context.Background()
+
+	suffix := time.Now().UnixNano()
+	userID, err := storage.CreateUser(ctx, models.User{
+		Login:        fmt.Sprintf("balance-reconcile-%d", suffix),
+		PasswordHash: "hash",
+	})
+	require.NoError(t, err)
+
+	orderNumber := fmt.Sprintf("%d", suffix)
+	require.NoError(t, storage.CreateOrder(ctx, &models.Order{
+		UserID:     userID,
+		Number:     orderNumber,
+		Status:     "NEW",
+		UploadedAt: time.Now(),
+	}))
+	require.NoError(t, storage.UpdateOrderFromAccrual(ctx, orderNumber, "PROCESSING", money.FromFloat(0)))
+	require.NoError(t, storage.UpdateOrderFromAccrual(ctx, orderNumber, "PROCESSED", money.FromFloat(500.5)))
+	require.NoError(t, storage.Withdraw(ctx, &models.Withdrawal{
+		UserID:      userID,
+		OrderNumber: orderNumber + "-w",
+		Sum:         money.FromFloat(200.25),
+		ProcessedAt: time.Now(),
+	}))
+
+	balance, err := storage.GetBalance(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, money.FromFloat(300.25), balance.Current)
+	assert.Equal(t, money.FromFloat(200.25), balance.Withdrawn)
+
+	mismatches, err := storage.ReconcileBalances(ctx)
+	require.NoError(t, err)
+	for _, m := range mismatches {
+		assert.NotEqual(t, userID, m.UserID, "balance mismatch: %+v", m)
+	}
+}
+
+func TestPostgres_QueryTimeout(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URI")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URI is not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	storage, err := postgres.NewPostgresDatabase(db, time.Nanosecond)
+	require.NoError(t, err)
+
+	_, err = storage.GetBalance(context.Background(), 1)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = openTestPostgres(t).GetUserByLogin(ctx, "anyone")
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestPostgres_OutboxEventsOfAUserCommitInIDOrder(t *testing.T) {
+	storage := openTestPostgres(t)
+	ctx := context.Background()
+
+	suffix := time.Now().UnixNano()
+	userID, err := storage.CreateUser(ctx, models.User{
+		Login:        fmt.Sprintf("outbox-order-%d", suffix),
+		PasswordHash: "hash",
+	})
+	require.NoError(t, err)
+
+	funded, pending := fmt.Sprintf("%d", suffix), fmt.Sprintf("%d-2", suffix)
+	for _, number := range []string{funded, pending} {
+		require.NoError(t, storage.CreateOrder(ctx, &models.Order{
+			UserID: userID, Number: number, Status: "NEW", UploadedAt: time.Now(),
+		}))
+	}
+	require.NoError(t, storage.UpdateOrderFromAccrual(ctx, funded, "PROCESSED", money.FromFloat(100)))
+
+	withdrawn := make(chan error, 1)
+	err = storage.WithinTx(ctx, database.TxOptions{}, func(txCtx context.Context) error {
+		// A status change without accrual posts no ledger entry, so only the
+		// outbox insert stands between it and a concurrent withdrawal.
+		if err := storage.UpdateOrderFromAccrual(txCtx, pending, "PROCESSING", 0); err != nil {
+			return err
+		}
+		go func() {
+			withdrawn <- storage.Withdraw(ctx, &models.Withdrawal{
+				UserID: userID, OrderNumber: pending + "-w", Sum: money.FromFloat(10), ProcessedAt: time.Now(),
+			})
+		}()
+
+		select {
+		case err := <-withdrawn:
+			t.Errorf("withdrawal committed while an earlier event was pending: %v", err)
+		case <-time.After(200 * time.Millisecond):
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, <-withdrawn)
+
+	events, err := storage.PendingOutboxEvents(ctx, 1_000_000)
+	require.NoError(t, err)
+	var types []string
+	for _, event := range events {
+		if event.UserID == userID {
+			types = append(types, event.Type)
+		}
+	}
+	require.NotEmpty(t, types)
+	assert.Equal(t, database.EventWithdrawalCreated, types[len(types)-1],
+		"the withdrawal follows the status change committed before it")
+}
@@ -0,0 +1,164 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/alisaviation/internal/database/memory"
+	"github.com/alisaviation/internal/gophermart/models"
+	"github.com/alisaviation/internal/gophermart/services"
+	"github.com/alisaviation/internal/handlers"
+	"github.com/alisaviation/internal/middleware"
+	"github.com/alisaviation/pkg/logger"
+)
+
+// observeLogs routes logger.Log into memory for the rest of the test.
+func observeLogs(t *testing.T) *observer.ObservedLogs {
+	t.Helper()
+	return observeLogsAt(t, zap.InfoLevel)
+}
+
+func observeLogsAt(t *testing.T, level zapcore.Level) *observer.ObservedLogs {
+	t.Helper()
+
+	core, logs := observer.New(level)
+	previous := logger.Log
+	logger.Log = zap.New(core)
+	t.Cleanup(func() { logger.Log = previous })
+	return logs
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	var seen string
+	handler := middleware.RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = logger.RequestID(r.Context())
+	}))
+
+	serve := func(requestID string) string {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if requestID != "" {
+			req.Header.Set(logger.RequestIDHeader, requestID)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assert.Equal(t, seen, rec.Header().Get(logger.RequestIDHeader), "the response carries the ID from the context")
+		return seen
+	}
+
+	assert.Equal(t, "client-id-42", serve("client-id-42"), "a caller's ID is kept")
+
+	generated := serve("")
+	assert.Len(t, generated, 32)
+	assert.NotEqual(t, generated, serve(""), "each request gets its own ID")
+
+	for _, invalid := range []string{"two words", "line\nbreak", strings.Repeat("x", 129)} {
+		id := serve(invalid)
+		assert.NotEqual(t, invalid, id)
+		assert.Len(t, id, 32)
+	}
+}
+
+func TestLoggerFromContext(t *testing.T) {
+	logs := observeLogs(t)
+
+	logger.FromContext(t.Context()).Info("plain")
+
+	ctx := logger.WithRequestID(t.Context(), "req-1")
+	ctx = logger.WithFields(ctx, zap.Int("userID", 7))
+	logger.FromContext(ctx).Info("correlated", zap.String("order", "79927398713"))
+
+	entries := logs.All()
+	require.Len(t, entries, 2)
+	assert.Empty(t, entries[0].Context)
+	assert.Equal(t, map[string]any{"request_id": "req-1", "userID": int64(7), "order": "79927398713"}, entries[1].ContextMap())
+	assert.Equal(t, "req-1", logger.RequestID(ctx))
+	assert.Empty(t, logger.RequestID(t.Context()))
+}
+
+func TestRequestID_CorrelatesLogsAcrossLayers(t *testing.T) {
+	var mu sync.Mutex
+	var accrualRequestIDs []string
+	accrual := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		accrualRequestIDs = append(accrualRequestIDs, r.Header.Get(logger.RequestIDHeader))
+		mu.Unlock()
+		w.Write([]byte(`{"order":"79927398713","status":"PROCESSED","accrual":10}`))
+	}))
+	defer accrual.Close()
+
+	storage := memory.NewMemoryStorage()
+	jwtService := services.NewJWTService([]byte("test_secret_key"), "gophermart")
+	orderService := services.NewOrderService(storage, storage, services.NewAccrualClient(accrual.URL, 0), nil)
+	orderHandler := handlers.NewOrderHandler(orderService)
+
+	userID, err := storage.CreateUser(t.Context(), models.User{Login: "correlated", PasswordHash: "hash"})
+	require.NoError(t, err)
+	require.NoError(t, storage.CreateOrder(t.Context(), &models.Order{
+		UserID: userID, Number: "79927398713", Status: "NEW", UploadedAt: time.Now(),
+	}))
+	token, err := jwtService.GenerateToken(userID, "correlated")
+	require.NoError(t, err)
+
+	r := chi.NewRouter()
+	r.Use(middleware.RequestIDMiddleware, logger.RequestResponseLogger)
+	r.With(middleware.AuthMiddleware(jwtService, storage)).Get("/api/user/orders/{number}", orderHandler.GetOrder)
+
+	logs := observeLogs(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/user/orders/79927398713?refresh=true", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set(logger.RequestIDHeader, "trace-me")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "trace-me", rec.Header().Get(logger.RequestIDHeader))
+
+	mu.Lock()
+	assert.Equal(t, []string{"trace-me"}, accrualRequestIDs, "the ID is passed on to the accrual system")
+	mu.Unlock()
+
+	accrualLogs := logs.FilterMessage("Accrual info response").All()
+	require.Len(t, accrualLogs, 1)
+	fields := accrualLogs[0].ContextMap()
+	assert.Equal(t, "trace-me", fields["request_id"])
+	assert.Equal(t, int64(userID), fields["userID"])
+
+	requestLogs := logs.FilterMessage("HTTP request").All()
+	require.Len(t, requestLogs, 1)
+	assert.Equal(t, "trace-me", requestLogs[0].ContextMap()["request_id"])
+	assert.Equal(t, int64(userID), requestLogs[0].ContextMap()["userID"], "the access log names the authenticated user")
+}
+
+func TestRequestResponseLogger_DebugDetailsAreCorrelated(t *testing.T) {
+	logs := observeLogsAt(t, zap.DebugLevel)
+
+	r := chi.NewRouter()
+	r.Use(middleware.RequestIDMiddleware, logger.RequestResponseLogger)
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		logger.WithUserID(r.Context(), 42)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(logger.RequestIDHeader, "debug-me")
+	req.Header.Set("Authorization", "Bearer secret")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	for _, message := range []string{"HTTP request", "HTTP request details"} {
+		entries := logs.FilterMessage(message).All()
+		require.Len(t, entries, 1, message)
+		fields := entries[0].ContextMap()
+		assert.Equal(t, "debug-me", fields["request_id"], message)
+		assert.Equal(t, int64(42), fields["userID"], message)
+	}
+}
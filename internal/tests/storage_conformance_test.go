@@ -0,0 +1,22 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/alisaviation/internal/database"
+	"github.com/alisaviation/internal/database/memory"
+	"github.com/alisaviation/internal/database/storagetest"
+)
+
+func TestStorageConformance_Memory(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) database.Storage {
+		return memory.NewMemoryStorage()
+	})
+}
+
+func TestStorageConformance_Postgres(t *testing.T) {
+	storage := openTestPostgres(t)
+	storagetest.Run(t, func(t *testing.T) database.Storage {
+		return storage
+	})
+}
@@ -0,0 +1,158 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/alisaviation/internal/database/memory"
+	"github.com/alisaviation/internal/gophermart/models"
+	"github.com/alisaviation/internal/gophermart/services"
+	"github.com/alisaviation/internal/middleware"
+	"github.com/alisaviation/internal/tracing"
+)
+
+// recordSpans installs a tracer provider that keeps ended spans in memory
+// for the rest of the test.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+
+	_, err := tracing.Setup(t.Context(), tracing.ExporterNone, "")
+	require.NoError(t, err)
+
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+func spansNamed(recorder *tracetest.SpanRecorder, name string) []sdktrace.ReadOnlySpan {
+	var spans []sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		if span.Name() == name {
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+func spanAttr(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestTracingMiddleware_ContinuesTraceAndNamesSpanByRoute(t *testing.T) {
+	recorder := recordSpans(t)
+
+	storage := memory.NewMemoryStorage()
+	userID, err := storage.CreateUser(t.Context(), models.User{Login: "traced", PasswordHash: "hash"})
+	require.NoError(t, err)
+	orderService := services.NewOrderService(storage, storage, nil, nil)
+
+	r := chi.NewRouter()
+	r.Use(middleware.TracingMiddleware)
+	r.Get("/api/user/orders/{number}", func(w http.ResponseWriter, r *http.Request) {
+		if _, err := orderService.GetOrder(r.Context(), userID, chi.URLParam(r, "number"), false); err != nil {
+			http.Error(w, "Order not found", http.StatusNotFound)
+		}
+	})
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodGet, "/api/user/orders/79927398713", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+
+	server := spansNamed(recorder, "GET /api/user/orders/{number}")
+	require.Len(t, server, 1)
+	assert.Equal(t, trace.SpanKindServer, server[0].SpanKind())
+	assert.Equal(t, traceID, server[0].SpanContext().TraceID().String(), "the caller's trace is continued")
+	assert.Equal(t, "/api/user/orders/{number}", spanAttr(server[0], "http.route").AsString())
+	assert.Equal(t, int64(http.StatusNotFound), spanAttr(server[0], "http.response.status_code").AsInt64())
+
+	service := spansNamed(recorder, "OrdersService.GetOrder")
+	require.Len(t, service, 1)
+	assert.Equal(t, server[0].SpanContext().SpanID(), service[0].Parent().SpanID())
+}
+
+func TestAccrualClient_TracesAttemptsAndPropagatesContext(t *testing.T) {
+	recorder := recordSpans(t)
+
+	var calls atomic.Int32
+	var mu sync.Mutex
+	var traceparents []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		traceparents = append(traceparents, r.Header.Get("traceparent"))
+		mu.Unlock()
+
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`{"order":"79927398713","status":"PROCESSED","accrual":10}`))
+	}))
+	defer srv.Close()
+
+	ctx, parent := tracing.Start(context.Background(), "test")
+	_, err := services.NewAccrualClient(srv.URL, 0).GetOrderAccrual(ctx, "79927398713")
+	parent.End()
+	require.NoError(t, err)
+
+	attempts := spansNamed(recorder, "GET /api/orders/{number}")
+	require.Len(t, attempts, 2)
+	for i, span := range attempts {
+		assert.Equal(t, trace.SpanKindClient, span.SpanKind())
+		assert.Equal(t, parent.SpanContext().TraceID(), span.SpanContext().TraceID())
+		assert.Equal(t, int64(i+1), spanAttr(span, "accrual.attempt").AsInt64())
+		assert.Equal(t, i > 0, spanAttr(span, "accrual.retry").AsBool())
+	}
+	assert.Equal(t, int64(http.StatusInternalServerError), spanAttr(attempts[0], "http.response.status_code").AsInt64())
+	assert.Equal(t, "accrual system internal error", spanAttr(attempts[1], "accrual.retry_reason").AsString())
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, traceparents, 2)
+	for i, header := range traceparents {
+		want := "00-" + parent.SpanContext().TraceID().String() + "-" + attempts[i].SpanContext().SpanID().String() + "-01"
+		assert.Equal(t, want, header, "each attempt sends its own span as parent")
+	}
+}
+
+func TestPostgres_TracesStatementsWithinSpans(t *testing.T) {
+	storage := openTestPostgres(t)
+	recorder := recordSpans(t)
+
+	_, err := storage.GetUserByLogin(t.Context(), "untraced")
+	require.NoError(t, err)
+	assert.Empty(t, recorder.Ended(), "statements outside a span start no trace")
+
+	ctx, parent := tracing.Start(t.Context(), "test")
+	_, err = storage.GetUserByLogin(ctx, "traced")
+	parent.End()
+	require.NoError(t, err)
+
+	selects := spansNamed(recorder, "SELECT")
+	require.Len(t, selects, 1)
+	assert.Equal(t, parent.SpanContext().SpanID(), selects[0].Parent().SpanID())
+	assert.Equal(t, "postgresql", spanAttr(selects[0], "db.system").AsString())
+	assert.Contains(t, spanAttr(selects[0], "db.query.text").AsString(), "FROM users")
+}
@@ -0,0 +1,343 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/alisaviation/internal/database"
+	"github.com/alisaviation/internal/database/memory"
+	"github.com/alisaviation/internal/gophermart/dto"
+	"github.com/alisaviation/internal/gophermart/models"
+	"github.com/alisaviation/internal/gophermart/outbox"
+	"github.com/alisaviation/internal/gophermart/services"
+	"github.com/alisaviation/internal/gophermart/webhooks"
+	"github.com/alisaviation/internal/handlers"
+	"github.com/alisaviation/internal/middleware"
+	"github.com/alisaviation/pkg/money"
+)
+
+type webhookRequest struct {
+	header http.Header
+	body   []byte
+}
+
+// webhookReceiver records requests and answers with status.
+type webhookReceiver struct {
+	mu       sync.Mutex
+	status   int
+	requests []webhookRequest
+}
+
+func (rcv *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	rcv.requests = append(rcv.requests, webhookRequest{header: r.Header.Clone(), body: body})
+	w.WriteHeader(rcv.status)
+}
+
+func (rcv *webhookReceiver) received() []webhookRequest {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	return append([]webhookRequest(nil), rcv.requests...)
+}
+
+func TestWebhooks_OrderProcessedIsSignedAndDelivered(t *testing.T) {
+	ctx := t.Context()
+	storage := memory.NewMemoryStorage()
+	receiver := &webhookReceiver{status: http.StatusNoContent}
+	srv := httptest.NewServer(receiver)
+	defer srv.Close()
+
+	userID, err := storage.CreateUser(ctx, models.User{Login: "integrator", PasswordHash: "hash"})
+	require.NoError(t, err)
+	service := services.NewWebhookService(storage, storage)
+	webhook, err := service.CreateWebhook(ctx, userID, dto.WebhookRequest{URL: srv.URL, Secret: "0123456789abcdef"})
+	require.NoError(t, err)
+
+	require.NoError(t, storage.CreateOrder(ctx, &models.Order{UserID: userID, Number: "79927398713", Status: "NEW", UploadedAt: time.Now()}))
+	require.NoError(t, storage.UpdateOrderFromAccrual(ctx, "79927398713", "PROCESSING", 0))
+	require.NoError(t, storage.UpdateOrderFromAccrual(ctx, "79927398713", "PROCESSED", money.FromFloat(42)))
+	require.NoError(t, storage.Withdraw(ctx, &models.Withdrawal{
+		UserID: userID, OrderNumber: "2377225624", Sum: money.FromFloat(10), ProcessedAt: time.Now(),
+	}))
+
+	relay := outbox.NewRelay(storage, webhooks.NewEventSink(storage), time.Second)
+	require.NoError(t, relay.ProcessPending(ctx))
+	sender := webhooks.NewSender(storage, time.Second)
+	// The receiver listens on loopback, which the sender refuses to dial.
+	sender.Client.Transport = srv.Client().Transport
+	require.NoError(t, sender.ProcessPending(ctx))
+
+	requests := receiver.received()
+	require.Len(t, requests, 2, "only the final order status and the withdrawal are sent")
+
+	var types []string
+	for _, req := range requests {
+		types = append(types, req.header.Get(webhooks.HeaderEvent))
+
+		timestamp, err := strconv.ParseInt(req.header.Get(webhooks.HeaderTimestamp), 10, 64)
+		require.NoError(t, err)
+		assert.Equal(t, webhooks.Sign(webhook.Secret, timestamp, req.body), req.header.Get(webhooks.HeaderSignature))
+		assert.Equal(t, "application/json", req.header.Get("Content-Type"))
+	}
+	assert.Equal(t, []string{database.WebhookOrderProcessed, database.WebhookWithdrawalCreated}, types)
+
+	var payload webhooks.Payload
+	require.NoError(t, json.Unmarshal(requests[0].body, &payload))
+	assert.Equal(t, strconv.FormatInt(payload.ID, 10), requests[0].header.Get(webhooks.HeaderEventID))
+	assert.Equal(t, database.WebhookOrderProcessed, payload.Type)
+	assert.JSONEq(t, `{"number":"79927398713","status":"PROCESSED","accrual":42}`, string(payload.Data))
+
+	// Offering the same events again does not send them twice.
+	sink := webhooks.NewEventSink(storage)
+	require.NoError(t, sink.Deliver(ctx, outbox.Message{ID: payload.ID, Type: database.EventOrderUpdated, UserID: userID, Payload: payload.Data}))
+	require.NoError(t, sender.ProcessPending(ctx))
+	assert.Len(t, receiver.received(), 2)
+
+	log, err := service.GetWebhookDeliveries(ctx, userID, webhook.ID, 10)
+	require.NoError(t, err)
+	require.Len(t, log, 2)
+	for _, delivery := range log {
+		assert.Equal(t, database.WebhookDeliverySucceeded, delivery.Status)
+		assert.Equal(t, http.StatusNoContent, delivery.ResponseCode)
+	}
+}
+
+func TestWebhookSender_RetriesGivesUpAndDisables(t *testing.T) {
+	ctx := t.Context()
+	storage := memory.NewMemoryStorage()
+	receiver := &webhookReceiver{status: http.StatusInternalServerError}
+	srv := httptest.NewServer(receiver)
+	defer srv.Close()
+
+	webhook := &models.Webhook{UserID: 1, URL: srv.URL, Secret: "0123456789abcdef", Enabled: true}
+	require.NoError(t, storage.CreateWebhook(ctx, webhook))
+	for id := int64(1); id <= 2; id++ {
+		_, err := storage.EnqueueWebhookDeliveries(ctx, 1, models.WebhookDelivery{
+			EventID: id, EventType: database.WebhookWithdrawalCreated, Payload: json.RawMessage(`{}`),
+		})
+		require.NoError(t, err)
+	}
+
+	sender := webhooks.NewSender(storage, time.Second)
+	// The receiver listens on loopback, which the sender refuses to dial.
+	sender.Client.Transport = srv.Client().Transport
+	sender.MinBackoff = 0
+	sender.MaxBackoff = 0
+	sender.MaxAttempts = 3
+	sender.DisableAfter = 5
+
+	for i := 1; i <= 5; i++ {
+		require.NoError(t, sender.ProcessPending(ctx))
+		assert.Len(t, receiver.received(), i, "a failure skips the webhook's other deliveries in the batch")
+	}
+
+	stored, err := storage.GetWebhook(ctx, webhook.ID)
+	require.NoError(t, err)
+	assert.False(t, stored.Enabled)
+	assert.NotNil(t, stored.DisabledAt)
+
+	log, err := storage.GetWebhookDeliveries(ctx, webhook.ID, 10)
+	require.NoError(t, err)
+	require.Len(t, log, 2)
+	assert.Equal(t, database.WebhookDeliveryPending, log[0].Status)
+	assert.Equal(t, 2, log[0].Attempts)
+	assert.Equal(t, database.WebhookDeliveryFailed, log[1].Status, "given up after MaxAttempts")
+	assert.Equal(t, 3, log[1].Attempts)
+	assert.Equal(t, http.StatusInternalServerError, log[1].ResponseCode)
+
+	require.NoError(t, sender.ProcessPending(ctx))
+	assert.Len(t, receiver.received(), 5, "a disabled webhook is not called")
+
+	// Enabling it again resumes the pending delivery.
+	receiver.mu.Lock()
+	receiver.status = http.StatusOK
+	receiver.mu.Unlock()
+	enabled := true
+	service := services.NewWebhookService(storage, storage)
+	_, err = service.UpdateWebhook(ctx, 1, webhook.ID, dto.WebhookUpdateRequest{Enabled: &enabled})
+	require.NoError(t, err)
+
+	require.NoError(t, sender.ProcessPending(ctx))
+	assert.Len(t, receiver.received(), 6)
+	log, err = storage.GetWebhookDeliveries(ctx, webhook.ID, 1)
+	require.NoError(t, err)
+	assert.Equal(t, database.WebhookDeliverySucceeded, log[0].Status)
+}
+
+func TestWebhookSender_RefusesPrivateAddresses(t *testing.T) {
+	ctx := t.Context()
+	storage := memory.NewMemoryStorage()
+	receiver := &webhookReceiver{status: http.StatusOK}
+	srv := httptest.NewServer(receiver)
+	defer srv.Close()
+
+	// By IP and by a name that resolves to loopback.
+	urls := []string{srv.URL, strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)}
+	var ids []int64
+	for _, url := range urls {
+		webhook := &models.Webhook{UserID: 1, URL: url, Secret: "0123456789abcdef", Enabled: true}
+		require.NoError(t, storage.CreateWebhook(ctx, webhook))
+		ids = append(ids, webhook.ID)
+	}
+	_, err := storage.EnqueueWebhookDeliveries(ctx, 1, models.WebhookDelivery{
+		EventID: 1, EventType: database.WebhookWithdrawalCreated, Payload: json.RawMessage(`{}`),
+	})
+	require.NoError(t, err)
+
+	sender := webhooks.NewSender(storage, time.Second)
+	require.NoError(t, sender.ProcessPending(ctx))
+	assert.Empty(t, receiver.received())
+
+	for _, id := range ids {
+		log, err := storage.GetWebhookDeliveries(ctx, id, 1)
+		require.NoError(t, err)
+		require.Len(t, log, 1)
+		assert.Equal(t, 1, log[0].Attempts)
+		assert.Zero(t, log[0].ResponseCode)
+		assert.Contains(t, log[0].LastError, webhooks.ErrForbiddenAddress.Error())
+	}
+}
+
+func TestWebhooks_IsPublicAddr(t *testing.T) {
+	for addr, public := range map[string]bool{
+		"93.184.216.34":                        true,
+		"2606:4700::1111":                      true,
+		"127.0.0.1":                            false,
+		"::1":                                  false,
+		"10.1.2.3":                             false,
+		"172.16.0.1":                           false,
+		"192.168.1.1":                          false,
+		"169.254.169.254":                      false,
+		"0.0.0.0":                              false,
+		"::":                                   false,
+		"fe80::1":                              false,
+		"fd00::1":                              false,
+		"224.0.0.1":                            false,
+		"::ffff:127.0.0.1":                     false,
+		"::ffff:10.0.0.1":                      false,
+		"0.1.2.3":                              false,
+		"0.255.255.255":                        false,
+		"1.0.0.0":                              true,
+		"100.63.255.255":                       true,
+		"100.64.0.1":                           false,
+		"100.127.255.255":                      false,
+		"100.128.0.0":                          true,
+		"192.0.0.8":                            false,
+		"192.0.0.255":                          false,
+		"192.0.1.1":                            true,
+		"198.17.255.255":                       true,
+		"198.18.0.1":                           false,
+		"198.19.255.255":                       false,
+		"198.20.0.0":                           true,
+		"240.0.0.1":                            false,
+		"255.255.255.255":                      false,
+		"::ffff:100.64.0.1":                    false,
+		"64:ff9b::a00:1":                       false,
+		"64:ff9b::5db8:d822":                   false,
+		"64:ff9b:1::a00:1":                     false,
+		"2001:0:a00:1::1":                      false,
+		"2001:0:4136:e378:8000:63bf:f5ff:fffe": false,
+		"2001:4860:4860::8888":                 true,
+		"2002:a00:1::1":                        false,
+		"2002:5db8:d822::1":                    false,
+		"2003::1":                              true,
+	} {
+		assert.Equal(t, public, webhooks.IsPublicAddr(netip.MustParseAddr(addr)), addr)
+	}
+}
+
+func TestWebhookHandler_CRUD(t *testing.T) {
+	storage := memory.NewMemoryStorage()
+	handler := handlers.NewWebhookHandler(services.NewWebhookService(storage, storage))
+	r := chi.NewRouter()
+	r.Post("/api/user/webhooks", handler.CreateWebhook)
+	r.Get("/api/user/webhooks", handler.GetWebhooks)
+	r.Get("/api/user/webhooks/{id}", handler.GetWebhook)
+	r.Patch("/api/user/webhooks/{id}", handler.UpdateWebhook)
+	r.Delete("/api/user/webhooks/{id}", handler.DeleteWebhook)
+	r.Get("/api/user/webhooks/{id}/deliveries", handler.GetDeliveries)
+
+	do := func(userID int, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, userID))
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+	const owner, other = 1, 2
+
+	assert.Equal(t, http.StatusNoContent, do(owner, http.MethodGet, "/api/user/webhooks", "").Code)
+
+	rec := do(owner, http.MethodPost, "/api/user/webhooks", `{"url":"https://example.com/hook","events":["order.processed","order.processed"]}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var created dto.WebhookResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	assert.Len(t, created.Secret, 64, "a secret is generated and shown once")
+	assert.Equal(t, []string{database.WebhookOrderProcessed}, created.Events)
+	assert.True(t, created.Enabled)
+	path := "/api/user/webhooks/" + strconv.FormatInt(created.ID, 10)
+
+	rec = do(owner, http.MethodGet, path, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var got dto.WebhookResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	assert.Empty(t, got.Secret)
+	assert.Equal(t, created.URL, got.URL)
+
+	assert.Equal(t, http.StatusNotFound, do(other, http.MethodGet, path, "").Code, "ownership is not leaked")
+	assert.Equal(t, http.StatusNotFound, do(other, http.MethodDelete, path, "").Code)
+	assert.Equal(t, http.StatusNotFound, do(owner, http.MethodGet, "/api/user/webhooks/abc", "").Code)
+
+	rec = do(owner, http.MethodPatch, path, `{"enabled":false,"events":[]}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	assert.False(t, got.Enabled)
+	assert.NotNil(t, got.DisabledAt)
+	assert.Equal(t, database.WebhookEventTypes, got.Events, "an empty list subscribes to everything")
+
+	for _, body := range []string{
+		`{"url":"ftp://example.com"}`,
+		`{"url":"https://example.com","secret":"short"}`,
+		`{"url":"https://example.com","events":["order.uploaded"]}`,
+	} {
+		assert.Equal(t, http.StatusUnprocessableEntity, do(owner, http.MethodPost, "/api/user/webhooks", body).Code, body)
+	}
+	assert.Equal(t, http.StatusBadRequest, do(owner, http.MethodPost, "/api/user/webhooks", `{`).Code)
+
+	assert.Equal(t, http.StatusNoContent, do(owner, http.MethodGet, path+"/deliveries", "").Code)
+	assert.Equal(t, http.StatusBadRequest, do(owner, http.MethodGet, path+"/deliveries?limit=0", "").Code)
+
+	assert.Equal(t, http.StatusNoContent, do(owner, http.MethodDelete, path, "").Code)
+	assert.Equal(t, http.StatusNotFound, do(owner, http.MethodGet, path, "").Code)
+}
+
+func TestWebhookService_LimitsWebhooksPerUser(t *testing.T) {
+	storage := memory.NewMemoryStorage()
+	service := services.NewWebhookService(storage, storage)
+
+	var err error
+	for i := 0; err == nil && i < 100; i++ {
+		_, err = service.CreateWebhook(t.Context(), 1, dto.WebhookRequest{URL: "https://example.com/" + strconv.Itoa(i)})
+	}
+	assert.ErrorIs(t, err, services.ErrTooManyWebhooks)
+
+	list, err := service.GetWebhooks(t.Context(), 1)
+	require.NoError(t, err)
+	assert.Len(t, list, 10)
+}
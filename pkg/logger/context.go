@@ -0,0 +1,68 @@
+package logger
+
+import (
+	"context"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+// RequestIDHeader carries the request ID in both directions.
+const RequestIDHeader = "X-Request-ID"
+
+type contextKey int
+
+const (
+	fieldsKey contextKey = iota
+	requestIDKey
+	userKey
+)
+
+// requestUser is put in the context by RequestResponseLogger and filled in
+// by WithUserID further down the chain, so that the access log, written
+// after the handler returns, can name the authenticated user.
+type requestUser struct {
+	id atomic.Int64
+}
+
+// WithFields returns a context whose logger, see FromContext, adds fields to
+// every entry on top of those ctx already carries.
+func WithFields(ctx context.Context, fields ...zap.Field) context.Context {
+	carried, _ := ctx.Value(fieldsKey).([]zap.Field)
+	merged := make([]zap.Field, 0, len(carried)+len(fields))
+	merged = append(merged, carried...)
+	merged = append(merged, fields...)
+	return context.WithValue(ctx, fieldsKey, merged)
+}
+
+// WithRequestID stores the request ID in ctx and adds it to its logger.
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	ctx = context.WithValue(ctx, requestIDKey, requestID)
+	return WithFields(ctx, zap.String("request_id", requestID))
+}
+
+// WithUserID adds the authenticated user to the logger of ctx and to the
+// access log of the request.
+func WithUserID(ctx context.Context, userID int) context.Context {
+	if user, ok := ctx.Value(userKey).(*requestUser); ok {
+		user.id.Store(int64(userID))
+	}
+	return WithFields(ctx, zap.Int("userID", userID))
+}
+
+// RequestID returns the ID of the request ctx belongs to, or "" outside of
+// one.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// FromContext returns Log with the fields carried by ctx, so entries logged
+// while serving a request can be correlated.
+func FromContext(ctx context.Context) *zap.Logger {
+	fields, _ := ctx.Value(fieldsKey).([]zap.Field)
+	if len(fields) == 0 {
+		return Log
+	}
+	return Log.With(fields...)
+}
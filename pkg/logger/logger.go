@@ -1,6 +1,7 @@
 package logger
 
 import (
+	"context"
 	"net/http"
 	"time"
 
@@ -39,23 +40,28 @@ func RequestResponseLogger(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 		ww := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		user := &requestUser{}
 
-		next.ServeHTTP(ww, r)
+		next.ServeHTTP(ww, r.WithContext(context.WithValue(r.Context(), userKey, user)))
 
 		duration := time.Since(start)
 
-		Log.Info("HTTP request",
+		log := FromContext(r.Context())
+		if id := user.id.Load(); id != 0 {
+			log = log.With(zap.Int64("userID", id))
+		}
+
+		log.Info("HTTP request",
 			zap.String("method", r.Method),
 			zap.String("path", r.URL.Path),
 			zap.String("query", r.URL.RawQuery),
 			zap.Int("status", ww.statusCode),
 			zap.Int("size", ww.size),
 			zap.Duration("duration", duration),
-			zap.String("request_id", ww.Header().Get("X-Request-ID")),
 		)
 
-		if Log.Core().Enabled(zapcore.DebugLevel) {
-			Log.Debug("HTTP request details",
+		if log.Core().Enabled(zapcore.DebugLevel) {
+			log.Debug("HTTP request details",
 				zap.Any("headers", sanitizeHeaders(r.Header)),
 				zap.Any("response_headers", sanitizeHeaders(ww.Header())),
 			)
@@ -97,6 +103,13 @@ func (rw *responseWriter) Write(b []byte) (int, error) {
 	rw.size += size
 	return size, err
 }
+
+// Unwrap lets http.ResponseController reach the underlying writer, for
+// example to flush a streaming response.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 func (rw *responseWriter) Header() http.Header {
 	if rw.headers == nil {
 		rw.headers = make(http.Header)
@@ -0,0 +1,182 @@
+package money
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const scale = 100
+
+var ErrInvalidAmount = errors.New("invalid money amount")
+
+// Amount is a number of loyalty points stored in hundredths, matching DECIMAL(10, 2).
+type Amount int64
+
+// FromFloat converts a float literal to an Amount. It panics if f does not
+// fit, so untrusted input must go through ParseFloat instead.
+func FromFloat(f float64) Amount {
+	amount, err := ParseFloat(f)
+	if err != nil {
+		panic(err)
+	}
+	return amount
+}
+
+func ParseFloat(f float64) (Amount, error) {
+	r := math.Round(f * scale)
+	if math.IsNaN(r) || r >= math.MaxInt64 || r < math.MinInt64 {
+		return 0, ErrInvalidAmount
+	}
+	return Amount(r), nil
+}
+
+func Parse(s string) (Amount, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, ErrInvalidAmount
+	}
+
+	negative := false
+	switch s[0] {
+	case '-':
+		negative = true
+		s = s[1:]
+	case '+':
+		s = s[1:]
+	}
+
+	whole, fraction, _ := strings.Cut(s, ".")
+	if whole == "" && fraction == "" {
+		return 0, ErrInvalidAmount
+	}
+	if whole == "" {
+		whole = "0"
+	}
+	if !isDigits(whole) || !isDigits(fraction) {
+		return 0, ErrInvalidAmount
+	}
+
+	units, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidAmount, err)
+	}
+
+	var cents int64
+	for i := 0; i < 2; i++ {
+		cents *= 10
+		if i < len(fraction) {
+			cents += int64(fraction[i] - '0')
+		}
+	}
+	if len(fraction) > 2 && fraction[2] >= '5' {
+		cents++
+	}
+
+	if units > (math.MaxInt64-cents)/scale {
+		return 0, ErrInvalidAmount
+	}
+
+	amount := Amount(units*scale + cents)
+	if negative {
+		amount = -amount
+	}
+	return amount, nil
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func (a Amount) Float64() float64 {
+	return float64(a) / scale
+}
+
+func (a Amount) String() string {
+	sign := ""
+	v := int64(a)
+	if v < 0 {
+		sign = "-"
+		v = -v
+	}
+
+	units, cents := v/scale, v%scale
+	switch {
+	case cents == 0:
+		return fmt.Sprintf("%s%d", sign, units)
+	case cents%10 == 0:
+		return fmt.Sprintf("%s%d.%d", sign, units, cents/10)
+	default:
+		return fmt.Sprintf("%s%d.%02d", sign, units, cents)
+	}
+}
+
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+
+	amount, err := Parse(s)
+	if err != nil {
+		f, ferr := strconv.ParseFloat(s, 64)
+		if ferr != nil {
+			return err
+		}
+		if amount, err = ParseFloat(f); err != nil {
+			return err
+		}
+	}
+
+	*a = amount
+	return nil
+}
+
+func (a *Amount) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*a = 0
+	case []byte:
+		amount, err := Parse(string(v))
+		if err != nil {
+			return err
+		}
+		*a = amount
+	case string:
+		amount, err := Parse(v)
+		if err != nil {
+			return err
+		}
+		*a = amount
+	case int64:
+		if v > math.MaxInt64/scale || v < math.MinInt64/scale {
+			return ErrInvalidAmount
+		}
+		*a = Amount(v * scale)
+	case float64:
+		amount, err := ParseFloat(v)
+		if err != nil {
+			return err
+		}
+		*a = amount
+	default:
+		return fmt.Errorf("cannot scan %T into money.Amount", src)
+	}
+	return nil
+}
+
+func (a Amount) Value() (driver.Value, error) {
+	return a.String(), nil
+}